@@ -0,0 +1,91 @@
+// Command dode manages the servers of the schedulers from outside of them.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider"
+	_ "github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/all"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/reaper"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/state"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/option"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/version"
+	"github.com/jessevdk/go-flags"
+)
+
+type ReapCommand struct {
+	option.ProviderOption
+	option.LeaseOption
+	Prefix string        `long:"prefix" description:"Prefix of the tags of the managed servers" required:"true"`
+	TTL    time.Duration `long:"ttl" description:"Destroy servers up for longer than this, even if their controller is alive"`
+	DryRun bool          `long:"dry-run" description:"Only list the orphaned servers"`
+}
+
+// Execute destroys the orphaned servers and prints them.
+func (c *ReapCommand) Execute(args []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	p, err := provider.Use(c.Provider, c.ProviderConfig(""))
+	if err != nil {
+		return err
+	}
+	orphans, err := reaper.New(p).
+		WithPrefix(c.Prefix).
+		WithTTL(c.TTL).
+		WithLeases(c.Leases()).
+		WithDryRun(c.DryRun).
+		Reap(ctx)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTAG\tREGION\tCREATED\tREASON")
+	for _, orphan := range orphans {
+		created := "-"
+		if !orphan.Server.CreatedAt().IsZero() {
+			created = orphan.Server.CreatedAt().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", orphan.Server.Name(), orphan.Tag, orphan.Server.Region(), created, orphan.Reason)
+	}
+	w.Flush()
+	return err
+}
+
+type StateCommand struct {
+	StateFile string `long:"state-file" description:"State file of the controller" required:"true"`
+	Job       string `long:"job" description:"Name of the job" required:"true"`
+}
+
+// Execute prints the state of the tasks of the job.
+func (c *StateCommand) Execute(args []string) error {
+	store, err := state.OpenBoltStore(c.StateFile)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	records, err := store.List(context.Background(), c.Job)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TASK\tSTATUS\tSERVER\tCONTAINER\tATTEMPTS\tUPDATED\tERROR")
+	for _, r := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", r.TaskID, r.Status, r.Server, r.ContainerID, r.Attempts, r.UpdatedAt.Format(time.RFC3339), r.Error)
+	}
+	return w.Flush()
+}
+
+type Option struct {
+	Version func()       `long:"version" description:"print version and exit" json:"-"`
+	Reap    ReapCommand  `command:"reap" description:"Destroy the servers left behind by crashed controllers"`
+	State   StateCommand `command:"state" description:"Print the state of the tasks of a job, the controller must not be running"`
+}
+
+func main() {
+	opt := Option{Version: version.PrintVersion}
+	if _, err := flags.Parse(&opt); err != nil {
+		os.Exit(1)
+	}
+}
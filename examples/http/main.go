@@ -1,18 +1,25 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"os/signal"
 
 	http_task "github.com/WangYihang/digital-ocean-docker-executor/examples/http/pkg/model/task"
 	"github.com/WangYihang/digital-ocean-docker-executor/examples/http/pkg/option"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/cost"
 	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider"
+	_ "github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/all"
 	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/api"
 	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/scheduler"
 	gojob_utils "github.com/WangYihang/gojob/pkg/utils"
 	"github.com/charmbracelet/log"
 )
 
-func init() {
+func main() {
+	option.Parse()
 	log.SetLevel(log.DebugLevel)
 	fd, err := os.OpenFile(option.Opt.LogFilePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
@@ -20,27 +27,78 @@ func init() {
 		os.Exit(1)
 	}
 	log.SetOutput(gojob_utils.NewTeeWriterCloser(os.Stdout, fd))
-}
-
-func main() {
 	log.Info("starting", "options", option.Opt)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	config := option.Opt.ProviderConfig(option.Opt.DigitalOceanToken)
+	// Find the servers of a previous run in every region they may be created in
+	config.Regions = append(config.Regions, option.Opt.Regions()...)
+	p, err := provider.Use(option.Opt.Provider, config)
+	if err != nil {
+		log.Error("failed to create provider", "error", err)
+		os.Exit(1)
+	}
 	s := scheduler.New(option.Opt.Name).
-		WithProvider(provider.Use("digitalocean", option.Opt.DigitalOceanToken)).
+		WithProvider(p).
 		WithCreateServerOptions(
 			api.NewCreateServerOptions().
 				WithName(option.Opt.Name).
 				WithTag(option.Opt.Name).
-				WithRegion(option.Opt.DropletRegion).
-				WithSize(option.Opt.DropletSize).
+				WithRegions(option.Opt.Regions()...).
+				WithSizes(option.Opt.Sizes()...).
 				WithImage(option.Opt.DropletImage).
 				WithPrivateKeyPath(option.Opt.DropletPrivateKeyPath).
 				WithPublicKeyPath(option.Opt.DropletPublicKeyPath).
-				WithPublicKeyName(option.Opt.Name),
+				WithPublicKeyName(option.Opt.Name).
+				WithProvisioningScripts(option.Opt.DropletProvisioningScripts...).
+				WithReservedIPs(option.Opt.DropletReservedIPs...).
+				WithVolume(option.Opt.VolumeOptions()),
 		).
 		WithMaxConcurrency(option.Opt.NumDroplets).
+		WithQueueSize(option.Opt.QueueSize).
+		WithBudget(option.Opt.Budget()).
+		WithPricer(option.Opt.Pricer(option.Opt.Sizes()...)).
+		WithFirewall(option.Opt.FirewallOptions()).
+		WithServerCapacity(option.Opt.ServerCapacity()).
 		WithDestroyAfterFinished(true)
+	if leases := option.Opt.Leases(); leases != nil {
+		s.WithLease(leases, option.Opt.LeaseTTL)
+	}
+	if pl := option.Opt.NewPlan(); pl != nil {
+		s.WithPlan(pl)
+	}
+	if m := option.Opt.KeyManager(p); m != nil {
+		s.WithEphemeralKey(m)
+	}
+	store, err := option.Opt.OpenState()
+	if err != nil {
+		log.Error("failed to open state file", "error", err, "path", option.Opt.StateFile)
+		os.Exit(1)
+	}
+	if store != nil {
+		defer store.Close()
+		s.WithState(store)
+	}
+	if option.Opt.BakeImage != "" {
+		if _, err := s.Bake(ctx, option.Opt.BakeImage, http_task.Images()...); err != nil {
+			log.Error("failed to bake image", "error", err)
+			os.Exit(1)
+		}
+	}
 	for t := range http_task.Generate(option.Opt.Name, 80) {
-		s.Submit(t)
+		t.WithDataRoot(option.Opt.DataRoot()).WithRequirements(option.Opt.Requirements())
+		if err := s.Submit(ctx, t); err != nil {
+			log.Error("failed to submit task", "task", t.String(), "error", err)
+			if ctx.Err() != nil || errors.Is(err, cost.ErrBudgetExceeded) {
+				break
+			}
+		}
+	}
+	if err := s.Wait(ctx); err != nil {
+		log.Error("run finished with errors", "error", err)
+		os.Exit(1)
+	}
+	if pl := s.Plan(); pl != nil {
+		fmt.Print(pl)
 	}
-	s.Wait()
 }
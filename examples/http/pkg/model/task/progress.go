@@ -17,7 +17,7 @@ var DoneProgress *GoJobProgress
 
 func init() {
 	PendingProgress, _ = NewHTTPGrabProgress(`{"timestamp":"2024-03-18T10:46:40Z","num_done":0,"num_total":100}`)
-	DoneProgress, _ = NewHTTPGrabProgress(`{"timestamp":"2024-03-18T10:46:40Z","num_done":100,"num_total":100}`)
+	DoneProgress, _ = NewHTTPGrabProgress(`{"timestamp":"2024-03-18T10:46:40Z","num_succeed":100,"num_done":100,"num_total":100}`)
 }
 
 func NewHTTPGrabProgress(message string) (*GoJobProgress, error) {
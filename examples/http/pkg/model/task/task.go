@@ -7,6 +7,7 @@ import (
 
 	"github.com/WangYihang/digital-ocean-docker-executor/examples/http/pkg/option"
 	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/executor/secureshell"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/resources"
 	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/task"
 	"github.com/charmbracelet/log"
 )
@@ -22,6 +23,7 @@ type HTTPGrabTask struct {
 	shard         int
 	shards        int
 	inputFileName string
+	requirements  resources.Resources
 }
 
 func Generate(name string, port int) <-chan *HTTPGrabTask {
@@ -39,6 +41,15 @@ func Generate(name string, port int) <-chan *HTTPGrabTask {
 	return out
 }
 
+// dockerImage is the docker image running the task
+const dockerImage = "ghcr.io/wangyihang/http-grab:main"
+
+// Images returns the docker images pulled by Prepare, e.g. to bake them into
+// the image of the servers.
+func Images() []string {
+	return []string{"amazon/aws-cli", dockerImage}
+}
+
 func New(port, shard, shards int, label string) *HTTPGrabTask {
 	folder := fmt.Sprintf("/data/%s/shards-%d/shard-%d", label, shards, shard)
 	inputFileName := fmt.Sprintf("zmap-80-%d-%d.json", shard, shards)
@@ -57,7 +68,7 @@ func New(port, shard, shards int, label string) *HTTPGrabTask {
 			WithPort(port).
 			WithNumWorkers(4096),
 		labels: make(map[string]interface{}),
-		image:  "ghcr.io/wangyihang/http-grab:main",
+		image:  dockerImage,
 		port:   port,
 	}
 	h.folder = folder
@@ -70,6 +81,24 @@ func New(port, shard, shards int, label string) *HTTPGrabTask {
 	return h
 }
 
+// WithDataRoot keeps the inputs and outputs under root (e.g. the mount point
+// of a volume) instead of /data.
+func (h *HTTPGrabTask) WithDataRoot(root string) *HTTPGrabTask {
+	h.folder = filepath.Join(root, strings.TrimPrefix(h.folder, "/data"))
+	return h
+}
+
+// WithRequirements declares what the task needs, so that the scheduler packs
+// several tasks onto a server.
+func (h *HTTPGrabTask) WithRequirements(requirements resources.Resources) *HTTPGrabTask {
+	h.requirements = requirements
+	return h
+}
+
+func (h *HTTPGrabTask) Requirements() resources.Resources {
+	return h.requirements
+}
+
 func (h *HTTPGrabTask) WithArguments(arguments *HTTPGrabArguments) *HTTPGrabTask {
 	h.arguments = arguments
 	return h
@@ -144,6 +173,11 @@ func (h *HTTPGrabTask) Start() error {
 	return nil
 }
 
+// ContainerID returns the ID of the container once started.
+func (h *HTTPGrabTask) ContainerID() string {
+	return h.containerID
+}
+
 func (h *HTTPGrabTask) Stop() error {
 	_, _, err := h.e.RunCommand(strings.Join([]string{
 		h.containerID,
@@ -225,12 +259,14 @@ func (h *HTTPGrabTask) Download() error {
 		h.e.RunCommand(fmt.Sprintf("docker run --rm -v ~/.aws:/root/.aws amazon/aws-cli configure set aws_access_key_id %s", option.Opt.S3Option.S3AccessKey))
 		h.e.RunCommand(fmt.Sprintf("docker run --rm -v ~/.aws:/root/.aws amazon/aws-cli configure set aws_secret_access_key %s", option.Opt.S3Option.S3SecretKey))
 		h.e.RunCommand(fmt.Sprintf("docker run --rm -v ~/.aws:/root/.aws amazon/aws-cli configure set default.region %s", option.Opt.S3Option.S3Region))
-		h.e.RunCommand(fmt.Sprintf("docker run --rm -v ~/.aws:/root/.aws -v /data:/data amazon/aws-cli s3 cp /data/ s3://%s/ --recursive", option.Opt.S3Bucket))
+		// Keep the layout of the data root in the bucket
+		prefix := fmt.Sprintf("%v/shards-%d/shard-%d", h.labels["task.label"], h.shards, h.shard)
+		h.e.RunCommand(fmt.Sprintf("docker run --rm -v ~/.aws:/root/.aws -v %s:/data amazon/aws-cli s3 cp /data/ s3://%s/%s/ --recursive", h.folder, option.Opt.S3Bucket, prefix))
 	}
 
 	// Download to local
-	h.e.DownloadFile(filepath.Join("/data", h.arguments.OutputFilePath), filepath.Join("data", filepath.Base(h.arguments.OutputFilePath)))
-	h.e.DownloadFile(filepath.Join("/data", h.arguments.StatusFilePath), filepath.Join("data", filepath.Base(h.arguments.StatusFilePath)))
+	h.e.DownloadFile(filepath.Join(h.folder, filepath.Base(h.arguments.OutputFilePath)), filepath.Join("data", filepath.Base(h.arguments.OutputFilePath)))
+	h.e.DownloadFile(filepath.Join(h.folder, filepath.Base(h.arguments.StatusFilePath)), filepath.Join("data", filepath.Base(h.arguments.StatusFilePath)))
 
 	// Remove files
 	h.e.RunCommand(fmt.Sprintf("rm -rf %s", h.folder))
@@ -0,0 +1,60 @@
+package http_grab_task_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	http_grab_task "github.com/WangYihang/digital-ocean-docker-executor/examples/http/pkg/model/task"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/dodetest"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/executor/secureshell"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/task"
+)
+
+func TestHTTPGrabTaskLifecycle(t *testing.T) {
+	s := dodetest.NewServer()
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	key, err := dodetest.WritePrivateKey(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := http_grab_task.New(80, 1, 254, "http-grab")
+	err = h.Assign(secureshell.NewSSHExecutor().WithIP(s.Host()).WithPort(s.Port()).WithPrivateKeyPath(key))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := h.Prepare(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := h.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	folder := "/data/http-grab/shards-254/shard-1"
+	err = os.WriteFile(filepath.Join(s.Path(folder), "http-grab-1-254-status.json"), []byte(
+		`{"timestamp":"2024-03-18T10:46:40Z","num_succeed":10,"num_failed":2,"num_done":12,"num_total":100}`+"\n",
+	), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := h.Status()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status.GetStatus() != task.RUNNING {
+		t.Errorf("expected running task, got %v", status.GetStatus())
+	}
+
+	s.Docker.FinishAll()
+	status, err = h.Status()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status.GetStatus() != task.FINISHED {
+		t.Errorf("expected finished task, got %v", status.GetStatus())
+	}
+}
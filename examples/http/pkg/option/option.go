@@ -13,16 +13,27 @@ type HTTPGrabOption struct {
 }
 
 type Option struct {
+	option.ProviderOption
 	option.S3Option
 	option.DigitalOceanOption
 	option.DropletOption
+	option.BudgetOption
+	option.FirewallOption
+	option.VolumeOption
+	option.BakeOption
+	option.LeaseOption
+	option.PlanOption
+	option.KeyOption
+	option.StateOption
+	option.ResourcesOption
 	option.MetaOption
 	HTTPGrabOption
 }
 
 var Opt Option
 
-func init() {
+// Parse parses the command line into Opt, exiting on invalid flags.
+func Parse() {
 	Opt.Version = version.PrintVersion
 	if _, err := flags.Parse(&Opt); err != nil {
 		os.Exit(1)
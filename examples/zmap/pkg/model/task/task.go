@@ -8,6 +8,7 @@ import (
 
 	"github.com/WangYihang/digital-ocean-docker-executor/examples/zmap/pkg/option"
 	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/executor/secureshell"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/resources"
 	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/task"
 	"github.com/charmbracelet/log"
 )
@@ -19,6 +20,7 @@ type ZmapTask struct {
 	labels       map[string]interface{}
 	arguments    *ZMapArguments
 	outputFolder string
+	requirements resources.Resources
 }
 
 func Generate(name string, port int, bandwidth string) <-chan *ZmapTask {
@@ -33,6 +35,15 @@ func Generate(name string, port int, bandwidth string) <-chan *ZmapTask {
 	return out
 }
 
+// dockerImage is the docker image running the task
+const dockerImage = "ghcr.io/zmap/zmap:latest"
+
+// Images returns the docker images pulled by Prepare, e.g. to bake them into
+// the image of the servers.
+func Images() []string {
+	return []string{"amazon/aws-cli", dockerImage}
+}
+
 func New(port, shard, shards int, label, bandwidth string) *ZmapTask {
 	folder := fmt.Sprintf("/data/zmap/port-%d/shards-%d/shard-%d", port, shards, shard)
 	path := fmt.Sprintf("zmap-%d-%d-%d", port, shard, shards)
@@ -46,7 +57,7 @@ func New(port, shard, shards int, label, bandwidth string) *ZmapTask {
 			WithStatusUpdateFileName(fmt.Sprintf("%s.status", path)).
 			WithLogFileName(fmt.Sprintf("%s.log", path)),
 		labels: make(map[string]interface{}),
-		image:  "ghcr.io/zmap/zmap:latest",
+		image:  dockerImage,
 	}
 	z.outputFolder = folder
 	z.labels["task.label"] = label
@@ -56,6 +67,24 @@ func New(port, shard, shards int, label, bandwidth string) *ZmapTask {
 	return z
 }
 
+// WithDataRoot keeps the outputs under root (e.g. the mount point of a volume)
+// instead of /data.
+func (z *ZmapTask) WithDataRoot(root string) *ZmapTask {
+	z.outputFolder = filepath.Join(root, strings.TrimPrefix(z.outputFolder, "/data"))
+	return z
+}
+
+// WithRequirements declares what the task needs, so that the scheduler packs
+// several tasks onto a server.
+func (z *ZmapTask) WithRequirements(requirements resources.Resources) *ZmapTask {
+	z.requirements = requirements
+	return z
+}
+
+func (z *ZmapTask) Requirements() resources.Resources {
+	return z.requirements
+}
+
 func (z *ZmapTask) WithArguments(arguments *ZMapArguments) *ZmapTask {
 	z.arguments = arguments
 	return z
@@ -116,6 +145,11 @@ func (z *ZmapTask) Start() error {
 	return nil
 }
 
+// ContainerID returns the ID of the container once started.
+func (z *ZmapTask) ContainerID() string {
+	return z.containerID
+}
+
 func (z *ZmapTask) Stop() error {
 	_, _, err := z.e.RunCommand(strings.Join([]string{
 		z.containerID,
@@ -0,0 +1,106 @@
+package zmap_task_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	zmap_task "github.com/WangYihang/digital-ocean-docker-executor/examples/zmap/pkg/model/task"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/dodetest"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/executor/secureshell"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/task"
+)
+
+func TestZmapTaskLifecycle(t *testing.T) {
+	s := dodetest.NewServer()
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	key, err := dodetest.WritePrivateKey(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	z := zmap_task.New(80, 3, 254, "zmap", "1M")
+	err = z.Assign(secureshell.NewSSHExecutor().WithIP(s.Host()).WithPort(s.Port()).WithPrivateKeyPath(key))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	status, err := z.Status()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status.GetStatus() != task.PENDING {
+		t.Errorf("expected pending task, got %v", status.GetStatus())
+	}
+
+	if err := z.Prepare(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := z.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// zmap writes its status updates into the mounted output folder
+	folder := "/data/zmap/port-80/shards-254/shard-3"
+	err = os.WriteFile(filepath.Join(s.Path(folder), "zmap-80-3-254.status"), []byte(
+		"real-time,time-elapsed,time-remaining,percent-complete,hit-rate,active-send-threads,sent-total,sent-last-one-sec,sent-avg-per-sec,recv-success-total,recv-success-last-one-sec,recv-success-avg-per-sec,recv-total,recv-total-last-one-sec,recv-total-avg-per-sec,pcap-drop-total,drop-last-one-sec,drop-avg-per-sec,sendto-fail-total,sendto-fail-last-one-sec,sendto-fail-avg-per-sec\n"+
+			"2024-01-31 23:05:05,4,425,0.937285,0.381937,1,40059,10001,9955,153,35,38,556,175,138,0,0,0,0,0,0\n"+
+			"2024-01-31 23:05:06,5,424,1.937285,0.381937,1,40059,10001,9955,153,35,38,556,175,138,0,0,0,0,0,0\n",
+	), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err = z.Status()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status.GetStatus() != task.RUNNING {
+		t.Errorf("expected running task, got %v", status.GetStatus())
+	}
+
+	s.Docker.FinishAll()
+	status, err = z.Status()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status.GetStatus() != task.FINISHED {
+		t.Errorf("expected finished task, got %v", status.GetStatus())
+	}
+
+	containers := s.Docker.Containers()
+	if len(containers) != 1 || containers[0].Image != "ghcr.io/zmap/zmap:latest" || containers[0].Volumes["/data"] != folder {
+		t.Errorf("unexpected containers: %+v", containers)
+	}
+}
+
+func TestZmapTaskDataRoot(t *testing.T) {
+	s := dodetest.NewServer()
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	key, err := dodetest.WritePrivateKey(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	z := zmap_task.New(80, 3, 254, "zmap", "1M").WithDataRoot("/mnt/data")
+	err = z.Assign(secureshell.NewSSHExecutor().WithIP(s.Host()).WithPort(s.Port()).WithPrivateKeyPath(key))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := z.Prepare(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := z.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	containers := s.Docker.Containers()
+	if len(containers) != 1 || containers[0].Volumes["/data"] != "/mnt/data/zmap/port-80/shards-254/shard-3" {
+		t.Errorf("expected the outputs on the volume, got %+v", containers)
+	}
+}
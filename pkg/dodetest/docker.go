@@ -0,0 +1,359 @@
+package dodetest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Container is a container started through the emulated docker CLI.
+type Container struct {
+	ID      string
+	Image   string
+	Args    []string
+	Labels  map[string]string
+	Volumes map[string]string // container path -> host path
+	Created time.Time
+
+	finishAt time.Time
+	stopped  bool
+}
+
+// Docker emulates the subset of the docker CLI used by the tasks:
+// pull, run, ps, inspect, stop, rm and info.
+type Docker struct {
+	mu          sync.Mutex
+	containers  []*Container
+	images      map[string]bool
+	runDuration time.Duration
+	onRun       func(*Container)
+}
+
+func NewDocker() *Docker {
+	return &Docker{
+		images: make(map[string]bool),
+	}
+}
+
+// WithRunDuration sets how long detached containers keep running. With the
+// default of zero, containers run until Finish is called.
+func (d *Docker) WithRunDuration(duration time.Duration) *Docker {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.runDuration = duration
+	return d
+}
+
+// OnRun registers a callback invoked for every started container, e.g. to
+// write the output files the real image would have produced.
+func (d *Docker) OnRun(f func(*Container)) *Docker {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onRun = f
+	return d
+}
+
+// Finish marks the container as exited.
+func (d *Docker) Finish(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if c := d.find(id); c != nil {
+		c.stopped = true
+	}
+}
+
+// FinishAll marks every container as exited.
+func (d *Docker) FinishAll() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, c := range d.containers {
+		c.stopped = true
+	}
+}
+
+// Containers returns all containers, including exited ones.
+func (d *Docker) Containers() []*Container {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]*Container{}, d.containers...)
+}
+
+// Running reports whether the container is still running.
+func (d *Docker) Running(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c := d.find(id)
+	return c != nil && d.running(c)
+}
+
+// WithImages marks the images as already pulled, like on a server booted
+// from a baked image.
+func (d *Docker) WithImages(images ...string) *Docker {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, image := range images {
+		d.images[image] = true
+	}
+	return d
+}
+
+// Images returns the pulled images.
+func (d *Docker) Images() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	images := []string{}
+	for image := range d.images {
+		images = append(images, image)
+	}
+	return images
+}
+
+func (d *Docker) running(c *Container) bool {
+	if c.stopped {
+		return false
+	}
+	return c.finishAt.IsZero() || time.Now().Before(c.finishAt)
+}
+
+// find looks a container up by its full or abbreviated ID.
+func (d *Docker) find(id string) *Container {
+	if id == "" {
+		return nil
+	}
+	for _, c := range d.containers {
+		if strings.HasPrefix(c.ID, id) {
+			return c
+		}
+	}
+	return nil
+}
+
+// Exec runs a docker CLI invocation (without the leading "docker") and
+// returns its exit status.
+func (d *Docker) Exec(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "docker: missing command")
+		return 1
+	}
+	switch args[0] {
+	case "pull":
+		return d.pull(args[1:], stdout, stderr)
+	case "run":
+		return d.run(args[1:], stdout, stderr)
+	case "ps":
+		return d.ps(args[1:], stdout, stderr)
+	case "inspect":
+		return d.inspect(args[1:], stdout, stderr)
+	case "stop", "rm":
+		return d.stop(args[0] == "rm", args[1:], stdout, stderr)
+	case "info":
+		fmt.Fprintln(stdout, "24.0.7")
+		return 0
+	}
+	fmt.Fprintf(stderr, "docker: '%s' is not a docker command.\n", args[0])
+	return 1
+}
+
+func (d *Docker) pull(args []string, stdout, stderr io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintln(stderr, "\"docker pull\" requires exactly 1 argument.")
+		return 1
+	}
+	d.mu.Lock()
+	d.images[args[0]] = true
+	d.mu.Unlock()
+	fmt.Fprintf(stdout, "Status: Downloaded newer image for %s\n", args[0])
+	return 0
+}
+
+// runFlagsWithValue are the flags of docker run which consume the next argument.
+var runFlagsWithValue = map[string]bool{
+	"--label": true, "-l": true,
+	"--volume": true, "-v": true,
+	"--network": true, "--name": true,
+	"--env": true, "-e": true,
+	"--workdir": true, "-w": true,
+	"--entrypoint": true,
+}
+
+func (d *Docker) run(args []string, stdout, stderr io.Writer) int {
+	c := &Container{
+		ID:      newContainerID(),
+		Labels:  make(map[string]string),
+		Volumes: make(map[string]string),
+		Created: time.Now(),
+	}
+	detach, remove := false, false
+	i := 0
+	for ; i < len(args) && strings.HasPrefix(args[i], "-"); i++ {
+		flag := args[i]
+		switch flag {
+		case "--detach", "-d", "-itd", "-dit":
+			detach = true
+		case "--rm":
+			remove = true
+		}
+		if !runFlagsWithValue[flag] {
+			continue
+		}
+		i++
+		if i >= len(args) {
+			fmt.Fprintf(stderr, "flag needs an argument: %s\n", flag)
+			return 125
+		}
+		switch flag {
+		case "--label", "-l":
+			key, value, _ := strings.Cut(args[i], "=")
+			c.Labels[key] = value
+		case "--volume", "-v":
+			host, container, _ := strings.Cut(args[i], ":")
+			c.Volumes[container] = host
+		}
+	}
+	if i >= len(args) {
+		fmt.Fprintln(stderr, "\"docker run\" requires at least 1 argument.")
+		return 125
+	}
+	c.Image = args[i]
+	c.Args = args[i+1:]
+
+	d.mu.Lock()
+	d.images[c.Image] = true
+	if !detach {
+		c.stopped = true
+	} else if d.runDuration > 0 {
+		c.finishAt = c.Created.Add(d.runDuration)
+	}
+	if !remove {
+		d.containers = append(d.containers, c)
+	}
+	onRun := d.onRun
+	d.mu.Unlock()
+
+	if onRun != nil {
+		onRun(c)
+	}
+	if detach {
+		fmt.Fprintln(stdout, c.ID)
+	}
+	return 0
+}
+
+func (d *Docker) ps(args []string, stdout, stderr io.Writer) int {
+	all, quiet := false, false
+	filters := []string{}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--all", "-a":
+			all = true
+		case "--quiet", "-q":
+			quiet = true
+		case "--filter", "-f":
+			i++
+			if i >= len(args) {
+				fmt.Fprintln(stderr, "flag needs an argument: --filter")
+				return 125
+			}
+			filters = append(filters, args[i])
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, c := range d.containers {
+		if !all && !d.running(c) {
+			continue
+		}
+		if !matchFilters(c, filters) {
+			continue
+		}
+		if quiet {
+			fmt.Fprintln(stdout, c.ID[:12])
+		} else {
+			fmt.Fprintf(stdout, "%s\t%s\n", c.ID[:12], c.Image)
+		}
+	}
+	return 0
+}
+
+func matchFilters(c *Container, filters []string) bool {
+	for _, filter := range filters {
+		kind, value, _ := strings.Cut(filter, "=")
+		if kind != "label" {
+			continue
+		}
+		key, expected, hasValue := strings.Cut(value, "=")
+		actual, ok := c.Labels[key]
+		if !ok || (hasValue && actual != expected) {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *Docker) inspect(args []string, stdout, stderr io.Writer) int {
+	format := ""
+	ids := []string{}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--format", "-f":
+			i++
+			if i < len(args) {
+				format = args[i]
+			}
+		default:
+			ids = append(ids, args[i])
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, id := range ids {
+		c := d.find(id)
+		if c == nil {
+			fmt.Fprintf(stderr, "Error: No such object: %s\n", id)
+			return 1
+		}
+		switch format {
+		case "{{.State.Running}}":
+			fmt.Fprintln(stdout, d.running(c))
+		default:
+			fmt.Fprintf(stdout, "[{\"Id\": %q, \"Image\": %q, \"State\": {\"Running\": %t}}]\n", c.ID, c.Image, d.running(c))
+		}
+	}
+	return 0
+}
+
+func (d *Docker) stop(remove bool, args []string, stdout, stderr io.Writer) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, id := range args {
+		if strings.HasPrefix(id, "-") {
+			continue
+		}
+		c := d.find(id)
+		if c == nil {
+			fmt.Fprintf(stderr, "Error response from daemon: No such container: %s\n", id)
+			return 1
+		}
+		c.stopped = true
+		if remove {
+			for i := range d.containers {
+				if d.containers[i] == c {
+					d.containers = append(d.containers[:i], d.containers[i+1:]...)
+					break
+				}
+			}
+		}
+		fmt.Fprintln(stdout, id)
+	}
+	return 0
+}
+
+func newContainerID() string {
+	buffer := make([]byte, 32)
+	rand.Read(buffer)
+	return hex.EncodeToString(buffer)
+}
@@ -0,0 +1,95 @@
+package dodetest
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/sftp"
+)
+
+// rootFS maps the remote filesystem of a Server onto a local directory.
+type rootFS struct {
+	root string
+}
+
+// local returns the local path of a remote path.
+func (r *rootFS) local(remote string) string {
+	return filepath.Join(r.root, filepath.FromSlash(path.Clean("/"+remote)))
+}
+
+func (r *rootFS) handlers() sftp.Handlers {
+	return sftp.Handlers{
+		FileGet:  r,
+		FilePut:  r,
+		FileCmd:  r,
+		FileList: r,
+	}
+}
+
+func (r *rootFS) Fileread(req *sftp.Request) (io.ReaderAt, error) {
+	return os.Open(r.local(req.Filepath))
+}
+
+func (r *rootFS) Filewrite(req *sftp.Request) (io.WriterAt, error) {
+	local := r.local(req.Filepath)
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(local, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+}
+
+func (r *rootFS) Filecmd(req *sftp.Request) error {
+	switch req.Method {
+	case "Setstat":
+		return nil
+	case "Rename":
+		return os.Rename(r.local(req.Filepath), r.local(req.Target))
+	case "Rmdir", "Remove":
+		return os.Remove(r.local(req.Filepath))
+	case "Mkdir":
+		return os.MkdirAll(r.local(req.Filepath), 0755)
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+func (r *rootFS) Filelist(req *sftp.Request) (sftp.ListerAt, error) {
+	local := r.local(req.Filepath)
+	switch req.Method {
+	case "List":
+		entries, err := os.ReadDir(local)
+		if err != nil {
+			return nil, err
+		}
+		infos := []os.FileInfo{}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, info)
+		}
+		return listerAt(infos), nil
+	case "Stat":
+		info, err := os.Stat(local)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt{info}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(infos []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(infos, l[offset:])
+	if n < len(infos) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,36 @@
+package dodetest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// WritePrivateKey generates an ed25519 key pair in dir and returns the path
+// of the private key. The public key is written next to it with a .pub suffix.
+func WritePrivateKey(dir string) (string, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "dodetest")
+	if err != nil {
+		return "", err
+	}
+	publicKey, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path+".pub", ssh.MarshalAuthorizedKey(publicKey), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
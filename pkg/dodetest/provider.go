@@ -0,0 +1,420 @@
+package dodetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/api"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+)
+
+// Instance is a server created by the fake Provider, backed by an in-process
+// SSH server.
+type Instance struct {
+	Server *Server
+	// UserData is the user data the server was created with
+	UserData string
+	// Firewalled reports whether a firewall was bound to the tag of the
+	// server when it was created
+	Firewalled bool
+	// KeyName is the name of the key pair the server authorizes
+	KeyName string
+
+	id        string
+	name      string
+	tags      []string
+	region    string
+	size      string
+	image     string
+	createdAt time.Time
+}
+
+func (i *Instance) ID() string {
+	return i.id
+}
+
+func (i *Instance) Name() string {
+	return i.name
+}
+
+func (i *Instance) IPv4() (string, error) {
+	return i.Server.Host(), nil
+}
+
+func (i *Instance) IPv6() (string, error) {
+	return "", fmt.Errorf("%w: fake servers only listen on ipv4", server.ErrNoAddress)
+}
+
+func (i *Instance) PrivateIPv4() (string, error) {
+	return "", fmt.Errorf("%w: fake servers have no private network", server.ErrNoAddress)
+}
+
+func (i *Instance) EgressIPv4() (string, error) {
+	return i.IPv4()
+}
+
+func (i *Instance) Tags() []string {
+	return i.tags
+}
+
+func (i *Instance) Region() string {
+	return i.region
+}
+
+func (i *Instance) Size() string {
+	return i.size
+}
+
+// Image returns the image the server booted from.
+func (i *Instance) Image() string {
+	return i.image
+}
+
+func (i *Instance) Status() server.Status {
+	return server.StatusRunning
+}
+
+func (i *Instance) CreatedAt() time.Time {
+	return i.createdAt
+}
+
+func (i *Instance) HourlyPrice() float64 {
+	return 0
+}
+
+func (i *Instance) Provider() string {
+	return "dodetest"
+}
+
+func (i *Instance) SSHPort() int {
+	return i.Server.Port()
+}
+
+func (i *Instance) SSHUser() string {
+	return "root"
+}
+
+func (i *Instance) SSHPrivateKeyPath() string {
+	return ""
+}
+
+// Provider is an in-memory CloudServiceProvider. Every created server is an
+// in-process SSH server emulating a docker host.
+type Provider struct {
+	mu             sync.Mutex
+	instances      []*Instance
+	interrupted    []*Instance
+	keys           map[string]string
+	firewalls      map[string]*api.FirewallOptions
+	snapshots      map[string]snapshot
+	nextID         int
+	numCreated     int
+	numDestroyed   int
+	createLatency  time.Duration
+	createFailures int
+	setup          func(*Server)
+}
+
+func NewProvider() *Provider {
+	return &Provider{
+		keys:      make(map[string]string),
+		firewalls: make(map[string]*api.FirewallOptions),
+		snapshots: make(map[string]snapshot),
+	}
+}
+
+// snapshot is an image baked from a server, keeping its pulled images
+type snapshot struct {
+	id     string
+	images []string
+}
+
+// WithCreateLatency delays every server creation.
+func (p *Provider) WithCreateLatency(latency time.Duration) *Provider {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.createLatency = latency
+	return p
+}
+
+// WithCreateFailures makes the next n server creations fail.
+func (p *Provider) WithCreateFailures(n int) *Provider {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.createFailures = n
+	return p
+}
+
+// WithServerSetup registers a callback invoked on every new server before it
+// starts, e.g. to configure the emulated docker or inject failures.
+func (p *Provider) WithServerSetup(setup func(*Server)) *Provider {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.setup = setup
+	return p
+}
+
+// Instances returns the servers which are currently alive.
+func (p *Provider) Instances() []*Instance {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*Instance{}, p.instances...)
+}
+
+// NumCreated returns the number of servers created so far.
+func (p *Provider) NumCreated() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.numCreated
+}
+
+// NumDestroyed returns the number of servers destroyed so far.
+func (p *Provider) NumDestroyed() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.numDestroyed
+}
+
+// KeyPairs returns the registered public keys by name.
+func (p *Provider) KeyPairs() map[string]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keys := make(map[string]string)
+	for name, pubkey := range p.keys {
+		keys[name] = pubkey
+	}
+	return keys
+}
+
+// Close stops every remaining server.
+func (p *Provider) Close() {
+	p.mu.Lock()
+	instances := p.instances
+	p.instances = nil
+	p.mu.Unlock()
+	for _, instance := range instances {
+		instance.Server.Close()
+	}
+}
+
+func (p *Provider) listServers() []server.Server {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	servers := []server.Server{}
+	for _, instance := range p.instances {
+		servers = append(servers, instance)
+	}
+	return servers
+}
+
+func (p *Provider) ListServers(ctx context.Context) ([]server.Server, error) {
+	return p.listServers(), ctx.Err()
+}
+
+func (p *Provider) ListServersByName(ctx context.Context, name string) ([]server.Server, error) {
+	servers := []server.Server{}
+	for _, s := range p.listServers() {
+		if s.Name() == name {
+			servers = append(servers, s)
+		}
+	}
+	return servers, ctx.Err()
+}
+
+func (p *Provider) ListServersByTag(ctx context.Context, tag string) ([]server.Server, error) {
+	servers := []server.Server{}
+	for _, s := range p.listServers() {
+		for _, t := range s.Tags() {
+			if t == tag {
+				servers = append(servers, s)
+				break
+			}
+		}
+	}
+	return servers, ctx.Err()
+}
+
+func (p *Provider) CreateKeyPair(ctx context.Context, name string, pubkey string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys[name] = pubkey
+	return nil
+}
+
+func (p *Provider) DeleteKeyPair(ctx context.Context, name string, pubkey string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.keys, name)
+	return nil
+}
+
+// CreateServer waits for the create latency, bounded by the create timeout of
+// cso, before starting the server.
+func (p *Provider) CreateServer(ctx context.Context, cso *api.CreateServerOptions) (server.Server, error) {
+	p.mu.Lock()
+	latency := p.createLatency
+	fail := p.createFailures > 0
+	if fail {
+		p.createFailures--
+	}
+	setup := p.setup
+	var baked []string
+	for _, snapshot := range p.snapshots {
+		if snapshot.id == cso.Image {
+			baked = snapshot.images
+		}
+	}
+	p.mu.Unlock()
+
+	userData, err := cso.BuildUserData()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := cso.CreateContext(ctx)
+	defer cancel()
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("server %s is not ready: %w", cso.Name, ctx.Err())
+	case <-time.After(latency):
+	}
+	if fail {
+		return nil, fmt.Errorf("simulated failure while creating server %s", cso.Name)
+	}
+
+	s := NewServer()
+	s.Docker.WithImages(baked...)
+	if setup != nil {
+		setup(s)
+	}
+	if err := s.Start(); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nextID++
+	p.numCreated++
+	_, firewalled := p.firewalls[cso.Tag]
+	instance := &Instance{
+		Server:     s,
+		UserData:   userData,
+		Firewalled: firewalled,
+		KeyName:    cso.PublicKeyName,
+		id:         fmt.Sprintf("%d", p.nextID),
+		name:       cso.Name,
+		tags:       []string{cso.Tag},
+		region:     cso.Region,
+		size:       cso.Size,
+		image:      cso.Image,
+		createdAt:  time.Now(),
+	}
+	p.instances = append(p.instances, instance)
+	return instance, nil
+}
+
+func (p *Provider) destroy(match func(*Instance) bool) error {
+	p.mu.Lock()
+	kept := []*Instance{}
+	destroyed := []*Instance{}
+	for _, instance := range p.instances {
+		if match(instance) {
+			destroyed = append(destroyed, instance)
+		} else {
+			kept = append(kept, instance)
+		}
+	}
+	p.instances = kept
+	p.numDestroyed += len(destroyed)
+	p.mu.Unlock()
+	for _, instance := range destroyed {
+		instance.Server.Close()
+	}
+	return nil
+}
+
+func (p *Provider) DestroyServerByName(ctx context.Context, name string) error {
+	return p.destroy(func(i *Instance) bool {
+		return i.name == name
+	})
+}
+
+func (p *Provider) DestroyServerByTag(ctx context.Context, tag string) error {
+	return p.destroy(func(i *Instance) bool {
+		for _, t := range i.tags {
+			if t == tag {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (p *Provider) CreateFirewall(ctx context.Context, tag string, fo *api.FirewallOptions) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.firewalls[tag] = fo
+	return nil
+}
+
+func (p *Provider) DestroyFirewall(ctx context.Context, tag string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.firewalls, tag)
+	return nil
+}
+
+// Firewall returns the firewall of the tag, nil if there is none.
+func (p *Provider) Firewall(tag string) *api.FirewallOptions {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.firewalls[tag]
+}
+
+func (p *Provider) SnapshotServer(ctx context.Context, s server.Server, name string, regions []string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nextID++
+	id := fmt.Sprintf("snapshot-%d", p.nextID)
+	p.snapshots[name] = snapshot{id: id, images: s.(*Instance).Server.Docker.Images()}
+	return id, nil
+}
+
+func (p *Provider) FindImage(ctx context.Context, name string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.snapshots[name].id, nil
+}
+
+// Interrupt simulates the cloud reclaiming a server, like an interrupted spot
+// instance: the server stops and is only listed as interrupted.
+func (p *Provider) Interrupt(id string) {
+	p.mu.Lock()
+	kept := []*Instance{}
+	for _, instance := range p.instances {
+		if instance.id == id {
+			p.interrupted = append(p.interrupted, instance)
+			defer instance.Server.Close()
+		} else {
+			kept = append(kept, instance)
+		}
+	}
+	p.instances = kept
+	p.mu.Unlock()
+}
+
+func (p *Provider) ListInterruptedServersByTag(ctx context.Context, tag string) ([]server.Server, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	servers := []server.Server{}
+	for _, instance := range p.interrupted {
+		for _, t := range instance.tags {
+			if t == tag {
+				servers = append(servers, instance)
+				break
+			}
+		}
+	}
+	return servers, nil
+}
@@ -0,0 +1,389 @@
+// Package dodetest provides an in-process SSH server emulating a docker host
+// and a fake cloud service provider backed by it, so that schedulers and
+// tasks can be exercised in go test without a real cloud account.
+package dodetest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// CommandHandler handles a command executed on the server and returns its exit status.
+type CommandHandler func(args []string, stdout, stderr io.Writer) int
+
+// Server is an in-process SSH server which emulates the parts of a docker host
+// used by secureshell.SSHExecutor: command execution, SFTP and the docker CLI.
+// The remote filesystem is backed by a temporary local directory.
+type Server struct {
+	Docker *Docker
+
+	fs       *rootFS
+	config   *ssh.ServerConfig
+	listener net.Listener
+	latency  time.Duration
+	failures int
+	handlers map[string]CommandHandler
+
+	cloudInitDuration time.Duration
+	cloudInitStatus   string
+	started           time.Time
+
+	mu          sync.Mutex
+	commands    []string
+	connections map[*ssh.ServerConn]bool
+	closed      bool
+	wg          sync.WaitGroup
+}
+
+func NewServer() *Server {
+	return &Server{
+		Docker:          NewDocker(),
+		handlers:        make(map[string]CommandHandler),
+		connections:     make(map[*ssh.ServerConn]bool),
+		cloudInitStatus: "done",
+	}
+}
+
+// WithLatency delays every command and SFTP session to simulate a slow server.
+func (s *Server) WithLatency(latency time.Duration) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = latency
+	return s
+}
+
+// WithConnectionFailures makes the server drop the next n incoming connections.
+func (s *Server) WithConnectionFailures(n int) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = n
+	return s
+}
+
+// WithCloudInit makes cloud-init finish with the status ("done" or "error")
+// after running for duration since the server started.
+func (s *Server) WithCloudInit(duration time.Duration, status string) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cloudInitDuration = duration
+	s.cloudInitStatus = status
+	return s
+}
+
+// Handle registers a handler for a command name, overriding the built-in emulation.
+func (s *Server) Handle(name string, handler CommandHandler) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[name] = handler
+	return s
+}
+
+// Start listens on a random loopback port. Any public key is accepted.
+func (s *Server) Start() error {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return err
+	}
+	s.config = &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	s.config.AddHostKey(signer)
+
+	root, err := os.MkdirTemp("", "dodetest-")
+	if err != nil {
+		return err
+	}
+	s.fs = &rootFS{root: root}
+
+	s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.RemoveAll(root)
+		return err
+	}
+	s.started = time.Now()
+	s.wg.Add(1)
+	go s.acceptLoop()
+	return nil
+}
+
+// Host returns the IP address the server listens on.
+func (s *Server) Host() string {
+	return s.listener.Addr().(*net.TCPAddr).IP.String()
+}
+
+// Port returns the port the server listens on.
+func (s *Server) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Path returns the local path backing a remote path.
+func (s *Server) Path(remote string) string {
+	return s.fs.local(remote)
+}
+
+// Commands returns the commands executed so far, in order.
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.commands...)
+}
+
+// Close stops the server, drops all connections and removes its filesystem.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	for conn := range s.connections {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	err := s.listener.Close()
+	s.wg.Wait()
+	os.RemoveAll(s.fs.root)
+	return err
+}
+
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		fail := s.failures > 0
+		if fail {
+			s.failures--
+		}
+		s.mu.Unlock()
+		if fail {
+			conn.Close()
+			continue
+		}
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer s.wg.Done()
+	sconn, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		sconn.Close()
+		return
+	}
+	s.connections[sconn] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.connections, sconn)
+		s.mu.Unlock()
+		sconn.Close()
+	}()
+
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.session(channel, requests)
+	}
+}
+
+func (s *Server) session(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		switch req.Type {
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			status := s.exec(payload.Command, channel, channel.Stderr())
+			channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+			return
+		case "subsystem":
+			var payload struct{ Name string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil || payload.Name != "sftp" {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			s.delay()
+			server := sftp.NewRequestServer(channel, s.fs.handlers())
+			server.Serve()
+			server.Close()
+			return
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func (s *Server) delay() {
+	s.mu.Lock()
+	latency := s.latency
+	s.mu.Unlock()
+	time.Sleep(latency)
+}
+
+// exec emulates a shell running the command. Commands chained with && are
+// run in order until one of them fails.
+func (s *Server) exec(command string, stdout, stderr io.Writer) int {
+	s.delay()
+	s.mu.Lock()
+	s.commands = append(s.commands, command)
+	s.mu.Unlock()
+	for _, part := range strings.Split(command, "&&") {
+		args := strings.Fields(part)
+		if len(args) == 0 {
+			continue
+		}
+		if status := s.run(args, stdout, stderr); status != 0 {
+			return status
+		}
+	}
+	return 0
+}
+
+func (s *Server) run(args []string, stdout, stderr io.Writer) int {
+	s.mu.Lock()
+	handler, ok := s.handlers[args[0]]
+	s.mu.Unlock()
+	if ok {
+		return handler(args[1:], stdout, stderr)
+	}
+	switch args[0] {
+	case "docker":
+		return s.Docker.Exec(args[1:], stdout, stderr)
+	case "true", "chmod":
+		return 0
+	case "echo":
+		fmt.Fprintln(stdout, strings.Join(args[1:], " "))
+		return 0
+	case "mkdir":
+		for _, arg := range args[1:] {
+			if strings.HasPrefix(arg, "-") {
+				continue
+			}
+			if err := os.MkdirAll(s.Path(arg), 0755); err != nil {
+				fmt.Fprintf(stderr, "mkdir: %s\n", err)
+				return 1
+			}
+		}
+		return 0
+	case "rm":
+		for _, arg := range args[1:] {
+			if !strings.HasPrefix(arg, "-") {
+				os.RemoveAll(s.Path(arg))
+			}
+		}
+		return 0
+	case "tail":
+		return s.tail(args[1:], stdout, stderr)
+	case "cloud-init":
+		return s.cloudInit(args[1:], stdout, stderr)
+	case "wget":
+		for i := 1; i < len(args)-1; i++ {
+			if args[i] == "-O" {
+				if err := os.WriteFile(s.Path(args[i+1]), []byte("{}\n"), 0644); err != nil {
+					fmt.Fprintf(stderr, "wget: %s\n", err)
+					return 1
+				}
+			}
+		}
+		return 0
+	}
+	// Uploaded executables are run successfully
+	if info, err := os.Stat(s.Path(args[0])); err == nil && !info.IsDir() {
+		return 0
+	}
+	fmt.Fprintf(stderr, "bash: %s: command not found\n", args[0])
+	return 127
+}
+
+// cloudInit emulates "cloud-init status [--wait]".
+func (s *Server) cloudInit(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] != "status" {
+		fmt.Fprintln(stderr, "usage: cloud-init status [--wait]")
+		return 2
+	}
+	s.mu.Lock()
+	remaining := s.cloudInitDuration - time.Since(s.started)
+	status := s.cloudInitStatus
+	s.mu.Unlock()
+	if remaining > 0 {
+		if len(args) < 2 || args[1] != "--wait" {
+			fmt.Fprintln(stdout, "status: running")
+			return 0
+		}
+		time.Sleep(remaining)
+	}
+	fmt.Fprintf(stdout, "status: %s\n", status)
+	if status == "error" {
+		return 1
+	}
+	return 0
+}
+
+func (s *Server) tail(args []string, stdout, stderr io.Writer) int {
+	n := 10
+	files := []string{}
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-n" && i+1 < len(args) {
+			n, _ = strconv.Atoi(args[i+1])
+			i++
+			continue
+		}
+		files = append(files, args[i])
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(s.Path(file))
+		if err != nil {
+			fmt.Fprintf(stderr, "tail: cannot open '%s' for reading: No such file or directory\n", file)
+			return 1
+		}
+		lines := strings.SplitAfter(string(data), "\n")
+		if lines[len(lines)-1] == "" {
+			lines = lines[:len(lines)-1]
+		}
+		if len(lines) > n {
+			lines = lines[len(lines)-n:]
+		}
+		io.WriteString(stdout, strings.Join(lines, ""))
+	}
+	return 0
+}
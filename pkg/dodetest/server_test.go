@@ -0,0 +1,126 @@
+package dodetest_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/dodetest"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/executor/secureshell"
+)
+
+func startServer(t *testing.T, s *dodetest.Server) *secureshell.SSHExecutor {
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	key, err := dodetest.WritePrivateKey(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := secureshell.NewSSHExecutor().
+		WithIP(s.Host()).
+		WithPort(s.Port()).
+		WithPrivateKeyPath(key)
+	if err := e.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestDockerLifecycle(t *testing.T) {
+	s := dodetest.NewServer()
+	e := startServer(t, s)
+
+	if _, _, err := e.RunCommand("docker pull ghcr.io/zmap/zmap:latest"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stdout, stderr, err := e.RunCommand("docker run --interactive --tty --detach --network host --volume /data/x:/data --label task.label=job --label task.shard=1 ghcr.io/zmap/zmap:latest --target-port 80")
+	if err != nil || stderr != "" {
+		t.Fatalf("unexpected error: %v %s", err, stderr)
+	}
+	id := strings.TrimSpace(stdout)
+
+	testcases := []struct {
+		command  string
+		expected string
+	}{
+		{"docker ps --quiet --filter label=task.label=job", id[:12]},
+		{"docker ps --quiet --filter label=task.label=other", ""},
+		{"docker inspect --format {{.State.Running}} " + id, "true"},
+	}
+	for _, testcase := range testcases {
+		stdout, _, err := e.RunCommand(testcase.command)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if strings.TrimSpace(stdout) != testcase.expected {
+			t.Errorf("%s: expected %q, got %q", testcase.command, testcase.expected, stdout)
+		}
+	}
+
+	containers := s.Docker.Containers()
+	if len(containers) != 1 || containers[0].Volumes["/data"] != "/data/x" || containers[0].Labels["task.shard"] != "1" {
+		t.Fatalf("unexpected containers: %+v", containers)
+	}
+
+	s.Docker.Finish(id)
+	stdout, _, _ = e.RunCommand("docker ps --quiet --filter label=task.label=job")
+	if strings.TrimSpace(stdout) != "" {
+		t.Errorf("expected no running container, got %q", stdout)
+	}
+	stdout, _, _ = e.RunCommand("docker ps --all --quiet --filter label=task.label=job")
+	if strings.TrimSpace(stdout) != id[:12] {
+		t.Errorf("expected exited container to be listed with --all, got %q", stdout)
+	}
+}
+
+func TestFileTransfer(t *testing.T) {
+	s := dodetest.NewServer()
+	e := startServer(t, s)
+
+	local := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(local, []byte("1.1.1.1\n2.2.2.2\n3.3.3.3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := e.RunCommand("mkdir -p /data/input"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := e.UploadFile(local, "/data/input/input.txt"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stdout, _, err := e.RunCommand("tail -n 2 /data/input/input.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stdout != "2.2.2.2\n3.3.3.3\n" {
+		t.Errorf("unexpected tail output %q", stdout)
+	}
+	downloaded := filepath.Join(t.TempDir(), "output.txt")
+	if err := e.DownloadFile("/data/input/input.txt", downloaded); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	content, _ := os.ReadFile(downloaded)
+	if string(content) != "1.1.1.1\n2.2.2.2\n3.3.3.3\n" {
+		t.Errorf("unexpected downloaded content %q", content)
+	}
+}
+
+func TestUnknownCommandFails(t *testing.T) {
+	e := startServer(t, dodetest.NewServer())
+	if _, _, err := e.RunCommand("definitely-not-a-command"); err == nil {
+		t.Errorf("expected error for unknown command")
+	}
+}
+
+func TestConnectionFailuresAreRetried(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the connection pool waits 5 seconds before retrying")
+	}
+	s := dodetest.NewServer().WithConnectionFailures(1)
+	e := startServer(t, s)
+	if _, _, err := e.RunCommand("true"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+// Package cost estimates what a fleet of servers costs and enforces budgets.
+package cost
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrBudgetExceeded is returned when creating a server would exceed the budget.
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// Pricer returns the hourly price in dollars of a server size in a region.
+// Providers with a pricing API (e.g. DigitalOcean) implement it.
+type Pricer interface {
+	HourlyPrice(ctx context.Context, region, size string) (float64, error)
+}
+
+// PriceTable is a static Pricer for providers without a pricing API. Keys
+// are sizes, or "region/size" to override the price of a size in a region.
+type PriceTable map[string]float64
+
+func (t PriceTable) HourlyPrice(ctx context.Context, region, size string) (float64, error) {
+	if price, ok := t[region+"/"+size]; ok {
+		return price, nil
+	}
+	if price, ok := t[size]; ok {
+		return price, nil
+	}
+	return 0, fmt.Errorf("no price for size %s in region %s", size, region)
+}
+
+// Budget caps the spend of a fleet in dollars and/or server-hours, a zero
+// value means no limit.
+type Budget struct {
+	Dollars     float64
+	ServerHours float64
+	// Lookahead is how long the fleet is projected to keep running when
+	// deciding whether a new server fits the budget, one hour by default.
+	Lookahead time.Duration
+}
+
+func NewBudget() *Budget {
+	return &Budget{
+		Lookahead: time.Hour,
+	}
+}
+
+func (b *Budget) WithDollars(dollars float64) *Budget {
+	b.Dollars = dollars
+	return b
+}
+
+func (b *Budget) WithServerHours(serverHours float64) *Budget {
+	b.ServerHours = serverHours
+	return b
+}
+
+func (b *Budget) WithLookahead(lookahead time.Duration) *Budget {
+	b.Lookahead = lookahead
+	return b
+}
+
+// ServerCost is the usage of one server.
+type ServerCost struct {
+	ID          string
+	Name        string
+	Region      string
+	Size        string
+	HourlyPrice float64
+	Started     time.Time
+	Stopped     time.Time
+}
+
+// Hours returns how long the server has been running at now.
+func (s *ServerCost) Hours(now time.Time) float64 {
+	end := now
+	if !s.Stopped.IsZero() {
+		end = s.Stopped
+	}
+	return end.Sub(s.Started).Hours()
+}
+
+// Dollars returns what the server has cost at now.
+func (s *ServerCost) Dollars(now time.Time) float64 {
+	return s.Hours(now) * s.HourlyPrice
+}
+
+// Tracker records when servers start and stop to account for their cost.
+type Tracker struct {
+	mu      sync.Mutex
+	servers map[string]*ServerCost
+	now     func() time.Time
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		servers: make(map[string]*ServerCost),
+		now:     time.Now,
+	}
+}
+
+// WithClock replaces the clock, for tests.
+func (t *Tracker) WithClock(now func() time.Time) *Tracker {
+	t.now = now
+	return t
+}
+
+// Start records that the server is running, it is a no-op for known servers.
+func (t *Tracker) Start(id, name, region, size string, hourlyPrice float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.servers[id]; ok {
+		return
+	}
+	t.servers[id] = &ServerCost{
+		ID:          id,
+		Name:        name,
+		Region:      region,
+		Size:        size,
+		HourlyPrice: hourlyPrice,
+		Started:     t.now(),
+	}
+}
+
+// Stop records that the server has been destroyed.
+func (t *Tracker) Stop(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s, ok := t.servers[id]; ok && s.Stopped.IsZero() {
+		s.Stopped = t.now()
+	}
+}
+
+// StopAll records that every running server has been destroyed.
+func (t *Tracker) StopAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	for _, s := range t.servers {
+		if s.Stopped.IsZero() {
+			s.Stopped = now
+		}
+	}
+}
+
+// StopMissing records that the running servers which are not in ids have
+// been destroyed, e.g. reclaimed by the cloud.
+func (t *Tracker) StopMissing(ids ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	listed := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		listed[id] = true
+	}
+	for id, s := range t.servers {
+		if !listed[id] && s.Stopped.IsZero() {
+			s.Stopped = now
+		}
+	}
+}
+
+// Check returns ErrBudgetExceeded if running one more server at hourlyPrice,
+// along with the running ones, for the lookahead of the budget would exceed it.
+func (t *Tracker) Check(budget *Budget, hourlyPrice float64) error {
+	return t.CheckN(budget, 1, hourlyPrice)
+}
+
+// CheckN is Check for n more servers, e.g. when some are still being created.
+func (t *Tracker) CheckN(budget *Budget, n int, hourlyPrice float64) error {
+	if budget == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	lookahead := budget.Lookahead.Hours()
+	dollars, hours := float64(n)*hourlyPrice*lookahead, float64(n)*lookahead
+	for _, s := range t.servers {
+		dollars += s.Dollars(now)
+		hours += s.Hours(now)
+		if s.Stopped.IsZero() {
+			dollars += s.HourlyPrice * lookahead
+			hours += lookahead
+		}
+	}
+	if budget.Dollars > 0 && dollars > budget.Dollars {
+		return fmt.Errorf("%w: projected spend $%.2f is over $%.2f", ErrBudgetExceeded, dollars, budget.Dollars)
+	}
+	if budget.ServerHours > 0 && hours > budget.ServerHours {
+		return fmt.Errorf("%w: projected usage %.1f server-hours is over %.1f", ErrBudgetExceeded, hours, budget.ServerHours)
+	}
+	return nil
+}
+
+// Report summarizes the cost of every server seen so far.
+func (t *Tracker) Report() *Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	report := &Report{At: t.now()}
+	for _, s := range t.servers {
+		server := *s
+		report.Servers = append(report.Servers, server)
+		report.Dollars += server.Dollars(report.At)
+		report.ServerHours += server.Hours(report.At)
+	}
+	sort.Slice(report.Servers, func(i, j int) bool {
+		return report.Servers[i].Started.Before(report.Servers[j].Started)
+	})
+	return report
+}
+
+// Report is the cost of a fleet at a point in time.
+type Report struct {
+	At          time.Time
+	Servers     []ServerCost
+	Dollars     float64
+	ServerHours float64
+}
+
+func (r *Report) String() string {
+	lines := []string{
+		fmt.Sprintf("%-24s %-8s %-16s %10s %10s %10s", "SERVER", "REGION", "SIZE", "$/HOUR", "HOURS", "COST"),
+	}
+	for _, s := range r.Servers {
+		lines = append(lines, fmt.Sprintf(
+			"%-24s %-8s %-16s %10.4f %10.2f %10.2f",
+			s.Name, s.Region, s.Size, s.HourlyPrice, s.Hours(r.At), s.Dollars(r.At),
+		))
+	}
+	lines = append(lines, fmt.Sprintf("%-24s %-8s %-16s %10s %10.2f %10.2f", "TOTAL", "", "", "", r.ServerHours, r.Dollars))
+	return strings.Join(lines, "\n")
+}
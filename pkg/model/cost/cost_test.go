@@ -0,0 +1,107 @@
+package cost_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/cost"
+)
+
+func TestPriceTable(t *testing.T) {
+	table := cost.PriceTable{
+		"s-1vcpu-1gb":      0.00893,
+		"sgp1/s-1vcpu-1gb": 0.01,
+	}
+	testcases := []struct {
+		region   string
+		size     string
+		expected float64
+		fails    bool
+	}{
+		{"nyc1", "s-1vcpu-1gb", 0.00893, false},
+		{"sgp1", "s-1vcpu-1gb", 0.01, false},
+		{"nyc1", "s-2vcpu-2gb", 0, true},
+	}
+	for _, tc := range testcases {
+		price, err := table.HourlyPrice(context.Background(), tc.region, tc.size)
+		if (err != nil) != tc.fails {
+			t.Errorf("%s/%s: unexpected error %v", tc.region, tc.size, err)
+		}
+		if price != tc.expected {
+			t.Errorf("%s/%s: expected %f, got %f", tc.region, tc.size, tc.expected, price)
+		}
+	}
+}
+
+func TestTrackerCheck(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newTracker := func() *cost.Tracker {
+		// One server has been running for an hour at $1/h, another one stopped after two hours
+		tracker := cost.NewTracker().WithClock(func() time.Time { return now })
+		tracker.Start("1", "scan-0", "nyc1", "large", 1)
+		tracker.Start("2", "scan-1", "nyc1", "large", 1)
+		now = now.Add(time.Hour)
+		tracker.Stop("2")
+		now = now.Add(time.Hour)
+		return tracker
+	}
+	testcases := []struct {
+		name   string
+		budget *cost.Budget
+		price  float64
+		fails  bool
+	}{
+		{"no budget", nil, 1, false},
+		{"within dollars", cost.NewBudget().WithDollars(5), 1, false},
+		{"over dollars", cost.NewBudget().WithDollars(4.5), 1, true},
+		{"longer lookahead", cost.NewBudget().WithDollars(5).WithLookahead(2 * time.Hour), 1, true},
+		{"within server-hours", cost.NewBudget().WithServerHours(5), 100, false},
+		{"over server-hours", cost.NewBudget().WithServerHours(4.5), 0, true},
+	}
+	for _, tc := range testcases {
+		err := newTracker().Check(tc.budget, tc.price)
+		if tc.fails && !errors.Is(err, cost.ErrBudgetExceeded) {
+			t.Errorf("%s: expected budget error, got %v", tc.name, err)
+		}
+		if !tc.fails && err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		}
+	}
+	// Servers still being created count too
+	if err := newTracker().CheckN(cost.NewBudget().WithDollars(5), 2, 1); !errors.Is(err, cost.ErrBudgetExceeded) {
+		t.Errorf("expected budget error for two more servers, got %v", err)
+	}
+}
+
+func TestTrackerReport(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracker := cost.NewTracker().WithClock(func() time.Time { return now })
+	tracker.Start("1", "scan-0", "nyc1", "small", 0.5)
+	now = now.Add(30 * time.Minute)
+	tracker.Start("2", "scan-1", "nyc1", "small", 0.5)
+	// Servers seen again are not restarted
+	tracker.Start("1", "scan-0", "nyc1", "small", 0.5)
+	now = now.Add(30 * time.Minute)
+	tracker.StopMissing("2")
+	now = now.Add(time.Hour)
+	tracker.StopAll()
+	now = now.Add(time.Hour)
+
+	report := tracker.Report()
+	if len(report.Servers) != 2 || report.Servers[0].Name != "scan-0" {
+		t.Fatalf("unexpected servers %v", report.Servers)
+	}
+	if math.Abs(report.ServerHours-2.5) > 1e-9 {
+		t.Errorf("expected 2.5 server-hours, got %f", report.ServerHours)
+	}
+	if math.Abs(report.Dollars-1.25) > 1e-9 {
+		t.Errorf("expected $1.25, got %f", report.Dollars)
+	}
+	if !strings.Contains(report.String(), "TOTAL") {
+		t.Errorf("expected a total in the report, got %q", report.String())
+	}
+}
@@ -21,9 +21,15 @@ type SSHExecutor struct {
 	Port           int
 	User           string
 	PrivateKeyPath string
-	connection     *sshutil.SSHConnection
+	// Recorder receives the calls instead of the server in a dry run
+	Recorder   Recorder
+	connection *sshutil.SSHConnection
 }
 
+// Recorder is called with the kind (run, upload or download) and detail of
+// every call of a dry run executor, which then succeeds without output.
+type Recorder func(kind, detail string)
+
 func NewSSHExecutor() *SSHExecutor {
 	return &SSHExecutor{
 		Port: 22,
@@ -51,6 +57,12 @@ func (s *SSHExecutor) WithPrivateKeyPath(path string) *SSHExecutor {
 	return s
 }
 
+// WithRecorder makes a dry run executor, which never connects to the server.
+func (s *SSHExecutor) WithRecorder(recorder Recorder) *SSHExecutor {
+	s.Recorder = recorder
+	return s
+}
+
 func (s *SSHExecutor) String() string {
 	return fmt.Sprintf(
 		"SSHExecutor{IP: %s, Port: %d, User: %s, KeyPath: %s}",
@@ -81,6 +93,9 @@ func (s *SSHExecutor) GetConfig() (*ssh.ClientConfig, error) {
 
 // Connect now uses the SSHConnectionPool to get the connection
 func (s *SSHExecutor) Connect() error {
+	if s.Recorder != nil {
+		return nil
+	}
 	// Using the connection pool to get the connection
 	pool := sshutil.GetSSHConnectionPool()
 
@@ -135,6 +150,10 @@ func (s *SSHExecutor) RunExecutable(path string) error {
 
 func (s *SSHExecutor) RunCommand(cmd string) (string, string, error) {
 	log.Debug("running command", "cmd", cmd)
+	if s.Recorder != nil {
+		s.Recorder("run", cmd)
+		return "", "", nil
+	}
 	session, err := s.connection.Client.NewSession()
 	if err != nil {
 		return "", "", err
@@ -168,6 +187,7 @@ func (s *SSHExecutor) RunCommand(cmd string) (string, string, error) {
 			text := scanner.Text()
 			log.Debug("output", filename, text)
 			buffer.WriteString(text)
+			buffer.WriteByte('\n')
 		}
 		if err := scanner.Err(); err != nil {
 			log.Error("Error reading output: %v", err)
@@ -189,6 +209,10 @@ func (s *SSHExecutor) RunCommand(cmd string) (string, string, error) {
 
 func (s *SSHExecutor) UploadFile(localFilePath, remoteFilePath string) error {
 	log.Info("uploading file", "local", localFilePath, "remote", remoteFilePath)
+	if s.Recorder != nil {
+		s.Recorder("upload", localFilePath+" to "+remoteFilePath)
+		return nil
+	}
 	sftpClient, err := sftp.NewClient(s.connection.Client)
 	if err != nil {
 		return err
@@ -213,6 +237,10 @@ func (s *SSHExecutor) UploadFile(localFilePath, remoteFilePath string) error {
 
 func (s *SSHExecutor) DownloadFile(remoteFilePath, localFilePath string) error {
 	log.Info("downloading file", "remote", remoteFilePath, "local", localFilePath)
+	if s.Recorder != nil {
+		s.Recorder("download", remoteFilePath+" to "+localFilePath)
+		return nil
+	}
 	sftpClient, err := sftp.NewClient(s.connection.Client)
 	if err != nil {
 		return err
@@ -238,5 +266,8 @@ func (s *SSHExecutor) DownloadFile(remoteFilePath, localFilePath string) error {
 }
 
 func (s *SSHExecutor) Close() error {
+	if s.Recorder != nil {
+		return nil
+	}
 	return s.connection.Client.Close()
 }
@@ -0,0 +1,108 @@
+// Package keys manages the SSH key pair of a run: it is generated when the
+// run starts, registered with the provider and deleted from the provider
+// account with the servers, so that no long-lived key is left authorized.
+package keys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/util/sshutil"
+	"github.com/charmbracelet/log"
+)
+
+// Key is a key pair saved on disk and registered with the provider.
+type Key struct {
+	Name           string
+	PrivateKeyPath string
+	PublicKeyPath  string
+	PublicKey      string
+	// Fingerprint is the SHA256 fingerprint of the public key
+	Fingerprint string
+}
+
+type Manager struct {
+	provider provider.CloudServiceProvider
+	folder   string
+	keyType  sshutil.KeyType
+	numBits  int
+}
+
+// NewManager returns a manager saving the ed25519 key pairs in folder.
+func NewManager(p provider.CloudServiceProvider, folder string) *Manager {
+	return &Manager{
+		provider: p,
+		folder:   folder,
+		keyType:  sshutil.Ed25519,
+		numBits:  4096,
+	}
+}
+
+func (m *Manager) WithKeyType(keyType sshutil.KeyType) *Manager {
+	m.keyType = keyType
+	return m
+}
+
+// WithNumBits sets the size of RSA keys.
+func (m *Manager) WithNumBits(numBits int) *Manager {
+	m.numBits = numBits
+	return m
+}
+
+func (m *Manager) paths(name string) (string, string) {
+	return filepath.Join(m.folder, name), filepath.Join(m.folder, name+".pub")
+}
+
+// Create generates the key pair named name and registers it with the
+// provider. If the key pair is already on disk, e.g. when a run is resumed,
+// it is reused so that the existing servers still accept it.
+func (m *Manager) Create(ctx context.Context, name string) (*Key, error) {
+	privateKeyPath, publicKeyPath := m.paths(name)
+	_, pubkey, err := sshutil.LoadSSHKeyPair(m.folder, name)
+	if errors.Is(err, os.ErrNotExist) {
+		_, pubkey, err = sshutil.CreateKeyPair(m.folder, name, m.keyType, m.numBits)
+	} else if err == nil {
+		log.Info("reusing ssh key pair", "path", privateKeyPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fingerprint, err := sshutil.Fingerprint(pubkey)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.provider.CreateKeyPair(ctx, name, pubkey); err != nil {
+		return nil, fmt.Errorf("failed to register key pair: %w", err)
+	}
+	log.Info("ssh key pair registered", "name", name, "fingerprint", fingerprint)
+	return &Key{
+		Name:           name,
+		PrivateKeyPath: privateKeyPath,
+		PublicKeyPath:  publicKeyPath,
+		PublicKey:      pubkey,
+		Fingerprint:    fingerprint,
+	}, nil
+}
+
+// Delete deletes the key pair from the provider account if the provider can
+// delete key pairs, then from disk.
+func (m *Manager) Delete(ctx context.Context, k *Key) error {
+	if deleter, ok := m.provider.(provider.KeyPairDeleter); ok {
+		if err := deleter.DeleteKeyPair(ctx, k.Name, k.PublicKey); err != nil {
+			return fmt.Errorf("failed to delete key pair: %w", err)
+		}
+	} else {
+		log.Warn("the provider can not delete key pairs, delete it by hand", "name", k.Name, "fingerprint", k.Fingerprint)
+	}
+	for _, path := range []string{k.PrivateKeyPath, k.PublicKeyPath} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	log.Info("ssh key pair deleted", "name", k.Name, "fingerprint", k.Fingerprint)
+	return nil
+}
@@ -0,0 +1,62 @@
+package keys_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/dodetest"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/keys"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/util/sshutil"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestManager(t *testing.T) {
+	ctx := context.Background()
+	p := dodetest.NewProvider()
+	t.Cleanup(p.Close)
+	m := keys.NewManager(p, t.TempDir())
+
+	key, err := m.Create(ctx, "zmap")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	privkey, err := os.ReadFile(key.PrivateKeyPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	signer, err := ssh.ParsePrivateKey(privkey)
+	if err != nil || signer.PublicKey().Type() != ssh.KeyAlgoED25519 {
+		t.Fatalf("expected an ed25519 private key (%v)", err)
+	}
+	if registered := p.KeyPairs()["zmap"]; !sshutil.SameKey(registered, key.PublicKey) {
+		t.Errorf("expected the key pair to be registered, got %q", registered)
+	}
+	if fingerprint := ssh.FingerprintSHA256(signer.PublicKey()); key.Fingerprint != fingerprint {
+		t.Errorf("expected fingerprint %s, got %s", fingerprint, key.Fingerprint)
+	}
+
+	// A resumed run reuses the key pair
+	resumed, err := m.Create(ctx, "zmap")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resumed.Fingerprint != key.Fingerprint {
+		t.Errorf("expected the key pair to be reused")
+	}
+
+	if err := m.Delete(ctx, key); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := p.KeyPairs()["zmap"]; ok {
+		t.Errorf("expected the key pair to be deleted from the provider")
+	}
+	for _, path := range []string{key.PrivateKeyPath, key.PublicKeyPath} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be deleted", path)
+		}
+	}
+	if err := m.Delete(ctx, key); err != nil {
+		t.Errorf("expected deleting twice to succeed, got %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+// Package lease records which tags have a live controller, so that servers
+// left behind by a crashed controller can be told apart from busy ones.
+package lease
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrNotFound is returned when no controller ever held the lease of a tag, or
+// it has been released.
+var ErrNotFound = errors.New("lease not found")
+
+// Lease is held by the controller of the servers of a tag, which renews it
+// before it expires. A kept lease marks servers the user chose to keep after
+// the run, it never expires.
+type Lease struct {
+	Tag       string    `json:"tag"`
+	Holder    string    `json:"holder"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Kept      bool      `json:"kept,omitempty"`
+}
+
+// Alive reports whether the lease is kept or has not expired at now.
+func (l *Lease) Alive(now time.Time) bool {
+	return l.Kept || now.Before(l.ExpiresAt)
+}
+
+// Store keeps the leases, it has to be shared by the controllers and the
+// reaper.
+type Store interface {
+	Renew(ctx context.Context, l *Lease) error
+	Get(ctx context.Context, tag string) (*Lease, error)
+	Release(ctx context.Context, tag string) error
+}
+
+// Holder identifies this controller process.
+func Holder() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s/%d", hostname, os.Getpid())
+}
+
+// FileStore keeps one JSON file per tag in a folder, e.g. on a shared disk.
+type FileStore struct {
+	folder string
+}
+
+func NewFileStore(folder string) *FileStore {
+	return &FileStore{
+		folder: folder,
+	}
+}
+
+func (f *FileStore) path(tag string) string {
+	return filepath.Join(f.folder, tag+".json")
+}
+
+// Renew writes the lease atomically, so that a reader never sees a partial one.
+func (f *FileStore) Renew(ctx context.Context, l *Lease) error {
+	if err := os.MkdirAll(f.folder, 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(f.folder, l.Tag+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path(l.Tag))
+}
+
+func (f *FileStore) Get(ctx context.Context, tag string) (*Lease, error) {
+	data, err := os.ReadFile(f.path(tag))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, tag)
+	}
+	if err != nil {
+		return nil, err
+	}
+	l := &Lease{}
+	if err := json.Unmarshal(data, l); err != nil {
+		return nil, fmt.Errorf("failed to parse lease of %s: %w", tag, err)
+	}
+	return l, nil
+}
+
+func (f *FileStore) Release(ctx context.Context, tag string) error {
+	if err := os.Remove(f.path(tag)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package lease_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/lease"
+)
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	store := lease.NewFileStore(t.TempDir())
+
+	if _, err := store.Get(ctx, "zmap"); !errors.Is(err, lease.ErrNotFound) {
+		t.Fatalf("expected no lease, got %v", err)
+	}
+	now := time.Now()
+	if err := store.Renew(ctx, &lease.Lease{Tag: "zmap", Holder: lease.Holder(), ExpiresAt: now.Add(time.Minute)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	l, err := store.Get(ctx, "zmap")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if l.Holder != lease.Holder() || !l.Alive(now) || l.Alive(now.Add(2*time.Minute)) {
+		t.Errorf("unexpected lease %+v", l)
+	}
+	if err := store.Release(ctx, "zmap"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := store.Get(ctx, "zmap"); !errors.Is(err, lease.ErrNotFound) {
+		t.Errorf("expected the lease to be released, got %v", err)
+	}
+	if err := store.Release(ctx, "zmap"); err != nil {
+		t.Errorf("expected releasing twice to succeed, got %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+// Package plan records what a scheduler would do without doing it, like
+// terraform plan.
+package plan
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Action is a call which changes something, recorded instead of being made.
+type Action struct {
+	// Kind is e.g. create, destroy, run, upload or download
+	Kind string
+	// Target is the server, tag or key pair the action applies to
+	Target string
+	Detail string
+}
+
+// Assignment is a task and the server it would run on, or the server it
+// already runs on if Done.
+type Assignment struct {
+	Task   string
+	Server string
+	// Done reports whether the task already runs or finished, it is skipped
+	Done bool
+}
+
+// Plan is filled by the plan provider and a scheduler in plan mode.
+type Plan struct {
+	mu          sync.Mutex
+	actions     []Action
+	servers     []*Server
+	assignments []Assignment
+	// TaskDuration is the estimated duration of one task, used to estimate the
+	// total cost.
+	TaskDuration time.Duration
+}
+
+func New() *Plan {
+	return &Plan{}
+}
+
+func (p *Plan) WithTaskDuration(d time.Duration) *Plan {
+	p.TaskDuration = d
+	return p
+}
+
+// Record records an action.
+func (p *Plan) Record(kind, target, detail string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.actions = append(p.actions, Action{Kind: kind, Target: target, Detail: detail})
+}
+
+// Assign records the server a task would run on.
+func (p *Plan) Assign(task, server string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.assignments = append(p.assignments, Assignment{Task: task, Server: server})
+}
+
+// Skip records a task which already runs or finished.
+func (p *Plan) Skip(task, server string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.assignments = append(p.assignments, Assignment{Task: task, Server: server, Done: true})
+}
+
+func (p *Plan) addServer(s *Server) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.servers = append(p.servers, s)
+}
+
+func (p *Plan) Actions() []Action {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Action{}, p.actions...)
+}
+
+// Servers returns the servers which would be created.
+func (p *Plan) Servers() []*Server {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*Server{}, p.servers...)
+}
+
+func (p *Plan) Assignments() []Assignment {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Assignment{}, p.assignments...)
+}
+
+// Estimate returns the hourly price of the servers which would be created,
+// and the total price of running them for their tasks if TaskDuration is set.
+func (p *Plan) Estimate() (hourly float64, total float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	numTasks := map[string]int{}
+	for _, a := range p.assignments {
+		if !a.Done {
+			numTasks[a.Server]++
+		}
+	}
+	for _, s := range p.servers {
+		hourly += s.price
+		total += s.price * p.TaskDuration.Hours() * float64(numTasks[s.name])
+	}
+	return hourly, total
+}
+
+func (p *Plan) String() string {
+	servers, actions, assignments := p.Servers(), p.Actions(), p.Assignments()
+	hourly, total := p.Estimate()
+	numCreates, numDestroys, numSkipped := 0, 0, 0
+	for _, a := range actions {
+		switch a.Kind {
+		case "create":
+			numCreates++
+		case "destroy":
+			numDestroys++
+		}
+	}
+	for _, a := range assignments {
+		if a.Done {
+			numSkipped++
+		}
+	}
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "Plan: %d to create, %d to destroy, %d tasks to run, %d already started.\n", numCreates, numDestroys, len(assignments)-numSkipped, numSkipped)
+	if len(servers) > 0 {
+		fmt.Fprintln(b, "\nServers:")
+		for _, s := range servers {
+			fmt.Fprintf(b, "  + %s (%s in %s, image %s, $%.4f/h)\n", s.name, s.size, s.region, s.image, s.price)
+		}
+	}
+	if len(assignments) > 0 {
+		fmt.Fprintln(b, "\nTasks:")
+		for _, a := range assignments {
+			if a.Done {
+				fmt.Fprintf(b, "  = %s (already started on %s)\n", a.Task, a.Server)
+				continue
+			}
+			fmt.Fprintf(b, "  > %s on %s\n", a.Task, a.Server)
+		}
+	}
+	if len(actions) > 0 {
+		fmt.Fprintln(b, "\nActions:")
+		for _, a := range actions {
+			sign := "~"
+			switch a.Kind {
+			case "create":
+				sign = "+"
+			case "destroy":
+				sign = "-"
+			}
+			fmt.Fprintf(b, "  %s %s %s: %s\n", sign, a.Kind, a.Target, a.Detail)
+		}
+	}
+	fmt.Fprintf(b, "\nCost: $%.4f/h for %d new servers", hourly, len(servers))
+	if p.TaskDuration > 0 {
+		fmt.Fprintf(b, ", about $%.2f with tasks of %s", total, p.TaskDuration)
+	}
+	fmt.Fprintln(b, ".")
+	return b.String()
+}
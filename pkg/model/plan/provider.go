@@ -0,0 +1,165 @@
+package plan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/cost"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/api"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+	"github.com/charmbracelet/log"
+)
+
+// Provider wraps a provider, passing the calls which only read through and
+// recording the ones which change something in the plan. The servers it
+// would create are listed along with the existing ones.
+type Provider struct {
+	provider provider.CloudServiceProvider
+	plan     *Plan
+}
+
+func NewProvider(p provider.CloudServiceProvider, plan *Plan) *Provider {
+	return &Provider{
+		provider: p,
+		plan:     plan,
+	}
+}
+
+// Unwrap returns the wrapped provider.
+func (p *Provider) Unwrap() provider.CloudServiceProvider {
+	return p.provider
+}
+
+func (p *Provider) CreateKeyPair(ctx context.Context, name string, pub string) error {
+	p.plan.Record("create", name, "key pair")
+	return nil
+}
+
+func (p *Provider) DeleteKeyPair(ctx context.Context, name string, pub string) error {
+	if _, ok := p.provider.(provider.KeyPairDeleter); !ok {
+		return nil
+	}
+	p.plan.Record("destroy", name, "key pair")
+	return nil
+}
+
+// CreateServer records the server, priced by the wrapped provider if it has a
+// pricing API.
+func (p *Provider) CreateServer(ctx context.Context, cso *api.CreateServerOptions) (server.Server, error) {
+	s := &Server{
+		id:     fmt.Sprintf("planned-%d", len(p.plan.Servers())+1),
+		name:   cso.Name,
+		tag:    cso.Tag,
+		region: cso.Region,
+		size:   cso.Size,
+		image:  cso.Image,
+	}
+	if pricer, ok := p.provider.(cost.Pricer); ok {
+		price, err := pricer.HourlyPrice(ctx, cso.Region, cso.Size)
+		if err != nil {
+			log.Warn("failed to get server price", "region", cso.Region, "size", cso.Size, "error", err)
+		}
+		s.price = price
+	}
+	detail := fmt.Sprintf("server of size %s in %s from image %s", cso.Size, cso.Region, cso.Image)
+	for _, vo := range cso.Volumes {
+		detail += fmt.Sprintf(", %d GB volume at %s", vo.SizeGB, vo.MountPoint)
+	}
+	if len(cso.ReservedIPs) > 0 {
+		detail += fmt.Sprintf(", reserved IP from %s", strings.Join(cso.ReservedIPs, ", "))
+	}
+	p.plan.Record("create", cso.Name, detail)
+	p.plan.addServer(s)
+	return s, nil
+}
+
+func (p *Provider) planned(match func(*Server) bool) []server.Server {
+	servers := []server.Server{}
+	for _, s := range p.plan.Servers() {
+		if match(s) {
+			servers = append(servers, s)
+		}
+	}
+	return servers
+}
+
+func (p *Provider) ListServers(ctx context.Context) ([]server.Server, error) {
+	servers, err := p.provider.ListServers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return append(servers, p.planned(func(s *Server) bool { return true })...), nil
+}
+
+func (p *Provider) ListServersByName(ctx context.Context, name string) ([]server.Server, error) {
+	servers, err := p.provider.ListServersByName(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return append(servers, p.planned(func(s *Server) bool { return s.name == name })...), nil
+}
+
+func (p *Provider) ListServersByTag(ctx context.Context, tag string) ([]server.Server, error) {
+	servers, err := p.provider.ListServersByTag(ctx, tag)
+	if err != nil {
+		return nil, err
+	}
+	return append(servers, p.planned(func(s *Server) bool { return s.tag == tag })...), nil
+}
+
+func (p *Provider) DestroyServerByName(ctx context.Context, name string) error {
+	p.plan.Record("destroy", name, "server")
+	return nil
+}
+
+func (p *Provider) DestroyServerByTag(ctx context.Context, tag string) error {
+	p.plan.Record("destroy", tag, "every server with the tag")
+	return nil
+}
+
+// HourlyPrice passes through to the wrapped provider.
+func (p *Provider) HourlyPrice(ctx context.Context, region, size string) (float64, error) {
+	pricer, ok := p.provider.(cost.Pricer)
+	if !ok {
+		return 0, errors.New("the provider has no pricing API")
+	}
+	return pricer.HourlyPrice(ctx, region, size)
+}
+
+func (p *Provider) CreateFirewall(ctx context.Context, tag string, fo *api.FirewallOptions) error {
+	if _, ok := p.provider.(provider.FirewallManager); !ok {
+		return errors.New("the provider does not manage firewalls")
+	}
+	rules := []string{}
+	for _, rule := range fo.Rules() {
+		rules = append(rules, fmt.Sprintf("%s/%s from %s", rule.Protocol, rule.Ports, strings.Join(rule.Sources, ", ")))
+	}
+	p.plan.Record("create", tag, "firewall allowing "+strings.Join(rules, "; "))
+	return nil
+}
+
+func (p *Provider) DestroyFirewall(ctx context.Context, tag string) error {
+	if _, ok := p.provider.(provider.FirewallManager); !ok {
+		return nil
+	}
+	p.plan.Record("destroy", tag, "firewall")
+	return nil
+}
+
+// FindImage passes through to the wrapped provider.
+func (p *Provider) FindImage(ctx context.Context, name string) (string, error) {
+	baker, ok := p.provider.(provider.ImageBaker)
+	if !ok {
+		return "", errors.New("the provider can not bake images")
+	}
+	return baker.FindImage(ctx, name)
+}
+
+// SnapshotServer records the snapshot, its ID is the name of the image.
+func (p *Provider) SnapshotServer(ctx context.Context, s server.Server, name string, regions []string) (string, error) {
+	p.plan.Record("create", name, fmt.Sprintf("image snapshotted from %s in %s", s.Name(), strings.Join(regions, ", ")))
+	return name, nil
+}
@@ -0,0 +1,54 @@
+package plan_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/dodetest"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/plan"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/api"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+)
+
+func TestProvider(t *testing.T) {
+	ctx := context.Background()
+	fake := dodetest.NewProvider()
+	t.Cleanup(fake.Close)
+	if _, err := fake.CreateServer(ctx, api.NewCreateServerOptions().WithName("zmap-0").WithTag("zmap")); err != nil {
+		t.Fatal(err)
+	}
+	pl := plan.New()
+	p := plan.NewProvider(fake, pl)
+
+	s, err := p.CreateServer(ctx, api.NewCreateServerOptions().WithName("zmap-1").WithTag("zmap").WithRegion("sfo3").WithSize("s-2vcpu-4gb"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Region() != "sfo3" || s.Size() != "s-2vcpu-4gb" || s.Status() != server.StatusProvisioning {
+		t.Errorf("unexpected planned server %s/%s %s", s.Region(), s.Size(), s.Status())
+	}
+	servers, err := p.ListServersByTag(ctx, "zmap")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(servers) != 2 {
+		t.Errorf("expected the existing and the planned server, got %d", len(servers))
+	}
+	if err := p.DestroyServerByTag(ctx, "zmap"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := p.CreateFirewall(ctx, "zmap", api.NewFirewallOptions().WithSSHSources("192.0.2.1/32")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fake.NumCreated() != 1 || fake.NumDestroyed() != 0 || fake.Firewall("zmap") != nil {
+		t.Errorf("expected the wrapped provider not to be changed")
+	}
+	kinds := []string{}
+	for _, a := range pl.Actions() {
+		kinds = append(kinds, a.Kind+" "+a.Target)
+	}
+	if len(kinds) != 3 || kinds[0] != "create zmap-1" || kinds[1] != "destroy zmap" || kinds[2] != "create zmap" {
+		t.Errorf("unexpected actions %v", kinds)
+	}
+}
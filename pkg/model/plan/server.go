@@ -0,0 +1,83 @@
+package plan
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+)
+
+// Server is a server which would be created.
+type Server struct {
+	id     string
+	name   string
+	tag    string
+	region string
+	size   string
+	image  string
+	price  float64
+}
+
+func (s *Server) ID() string {
+	return s.id
+}
+
+func (s *Server) Name() string {
+	return s.name
+}
+
+// IPv4, IPv6, PrivateIPv4 and EgressIPv4 fail, a planned server has no address.
+func (s *Server) IPv4() (string, error) {
+	return "", fmt.Errorf("%w: %s is only planned", server.ErrNoAddress, s.name)
+}
+
+func (s *Server) IPv6() (string, error) {
+	return s.IPv4()
+}
+
+func (s *Server) PrivateIPv4() (string, error) {
+	return s.IPv4()
+}
+
+func (s *Server) EgressIPv4() (string, error) {
+	return s.IPv4()
+}
+
+func (s *Server) Tags() []string {
+	return []string{s.tag}
+}
+
+func (s *Server) Region() string {
+	return s.region
+}
+
+func (s *Server) Size() string {
+	return s.size
+}
+
+func (s *Server) Image() string {
+	return s.image
+}
+
+func (s *Server) Status() server.Status {
+	return server.StatusProvisioning
+}
+
+func (s *Server) CreatedAt() time.Time {
+	return time.Time{}
+}
+
+func (s *Server) HourlyPrice() float64 {
+	return s.price
+}
+
+// WithHourlyPrice overrides the price given by the provider, e.g. with a
+// price table.
+func (s *Server) WithHourlyPrice(price float64) *Server {
+	s.price = price
+	return s
+}
+
+func (s *Server) Provider() string {
+	return "plan"
+}
@@ -0,0 +1,390 @@
+package alibaba
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/util/ratelimit"
+	"github.com/charmbracelet/log"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultEndpoint = "https://ecs.aliyuncs.com"
+	apiVersion      = "2014-05-26"
+	pageSize        = 100
+)
+
+// Error is returned by the ECS API when a request fails.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+	RequestID  string `json:"RequestId"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("ecs api error (status: %d, code: %s, request: %s): %s", e.StatusCode, e.Code, e.RequestID, e.Message)
+}
+
+type Tag struct {
+	TagKey   string
+	TagValue string
+}
+
+type Instance struct {
+	InstanceId      string
+	InstanceName    string
+	RegionId        string
+	ZoneId          string
+	InstanceType    string
+	ImageId         string
+	Status          string
+	CreationTime    string
+	PublicIpAddress struct {
+		IpAddress []string
+	}
+	EipAddress struct {
+		IpAddress string
+	}
+	VpcAttributes struct {
+		PrivateIpAddress struct {
+			IpAddress []string
+		}
+	}
+	Tags struct {
+		Tag []Tag
+	}
+}
+
+// PublicIPv4 returns the public or elastic ip address of the instance.
+func (i *Instance) PublicIPv4() (string, error) {
+	if len(i.PublicIpAddress.IpAddress) > 0 {
+		return i.PublicIpAddress.IpAddress[0], nil
+	}
+	if i.EipAddress.IpAddress != "" {
+		return i.EipAddress.IpAddress, nil
+	}
+	return "", fmt.Errorf("instance %s has no public ipv4 address", i.InstanceId)
+}
+
+type KeyPair struct {
+	KeyPairName        string
+	KeyPairFingerPrint string
+}
+
+// ECS is a minimal client of the Alibaba Cloud ECS RPC API.
+type ECS struct {
+	client          *http.Client
+	endpoint        string
+	accessKeyID     string
+	accessKeySecret string
+}
+
+func newECS(accessKeyID, accessKeySecret string) *ECS {
+	return &ECS{
+		client:          &http.Client{Transport: ratelimit.NewTransport(nil), Timeout: 30 * time.Second},
+		endpoint:        defaultEndpoint,
+		accessKeyID:     accessKeyID,
+		accessKeySecret: accessKeySecret,
+	}
+}
+
+// percentEncode encodes a string as required by the signature algorithm (RFC 3986).
+func percentEncode(s string) string {
+	encoded := url.QueryEscape(s)
+	encoded = strings.ReplaceAll(encoded, "+", "%20")
+	encoded = strings.ReplaceAll(encoded, "*", "%2A")
+	encoded = strings.ReplaceAll(encoded, "%7E", "~")
+	return encoded
+}
+
+// sign computes the signature of the request parameters with HMAC-SHA1.
+func sign(method string, params map[string]string, secret string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, percentEncode(k)+"="+percentEncode(params[k]))
+	}
+	stringToSign := method + "&" + percentEncode("/") + "&" + percentEncode(strings.Join(pairs, "&"))
+	mac := hmac.New(sha1.New, []byte(secret+"&"))
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// call invokes an API action and decodes the JSON response into out.
+func (e *ECS) call(ctx context.Context, action string, params map[string]string, out interface{}) error {
+	query := map[string]string{
+		"Action":           action,
+		"Format":           "JSON",
+		"Version":          apiVersion,
+		"AccessKeyId":      e.accessKeyID,
+		"SignatureMethod":  "HMAC-SHA1",
+		"SignatureVersion": "1.0",
+		"SignatureNonce":   uuid.New().String(),
+		"Timestamp":        time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+	}
+	for k, v := range params {
+		query[k] = v
+	}
+	query["Signature"] = sign(http.MethodGet, query, e.accessKeySecret)
+	values := url.Values{}
+	for k, v := range query {
+		values.Set(k, v)
+	}
+	log.Debug("calling ecs api", "action", action)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.endpoint+"/?"+values.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		if err := json.Unmarshal(body, apiErr); err != nil {
+			apiErr.Message = string(body)
+		}
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(body, out)
+}
+
+func isErrorCode(err error, code string) bool {
+	apiErr, ok := err.(*Error)
+	return ok && apiErr.Code == code
+}
+
+func (e *ECS) DescribeKeyPairs(ctx context.Context, region string, name string) ([]KeyPair, error) {
+	keyPairs := []KeyPair{}
+	for page := 1; ; page++ {
+		params := map[string]string{
+			"RegionId":   region,
+			"PageNumber": fmt.Sprintf("%d", page),
+			"PageSize":   fmt.Sprintf("%d", pageSize),
+		}
+		if name != "" {
+			params["KeyPairName"] = name
+		}
+		var resp struct {
+			TotalCount int
+			KeyPairs   struct {
+				KeyPair []KeyPair
+			}
+		}
+		if err := e.call(ctx, "DescribeKeyPairs", params, &resp); err != nil {
+			return nil, err
+		}
+		keyPairs = append(keyPairs, resp.KeyPairs.KeyPair...)
+		if len(resp.KeyPairs.KeyPair) < pageSize || len(keyPairs) >= resp.TotalCount {
+			return keyPairs, nil
+		}
+	}
+}
+
+// ImportKeyPair makes sure the public key is available in the region and
+// returns the name of the key pair holding it, which may differ from name
+// if the key had already been imported under another name. Key pairs cannot
+// be updated, so a key pair named so holding another key is replaced.
+func (e *ECS) ImportKeyPair(ctx context.Context, region, name, pubkey, fingerprint string) (string, error) {
+	keyPairs, err := e.DescribeKeyPairs(ctx, region, name)
+	if err != nil {
+		return "", err
+	}
+	for _, keyPair := range keyPairs {
+		if keyPair.KeyPairName != name {
+			continue
+		}
+		if normalizeFingerprint(keyPair.KeyPairFingerPrint) == normalizeFingerprint(fingerprint) {
+			log.Info("ssh key already exists", "name", name, "region", region)
+			return name, nil
+		}
+		log.Warn("ssh key holds another public key, replacing it", "name", name, "region", region, "fingerprint", keyPair.KeyPairFingerPrint)
+		if err := e.DeleteKeyPair(ctx, region, name); err != nil {
+			return "", fmt.Errorf("failed to replace key pair %s holding another public key: %w", name, err)
+		}
+	}
+	err = e.call(ctx, "ImportKeyPair", map[string]string{
+		"RegionId":      region,
+		"KeyPairName":   name,
+		"PublicKeyBody": strings.TrimSpace(pubkey),
+	}, nil)
+	if err == nil {
+		log.Info("ssh key imported", "name", name, "region", region)
+		return name, nil
+	}
+	if !isErrorCode(err, "KeyPair.AlreadyExist") {
+		return "", err
+	}
+	// The same public key has been imported with another name
+	keyPairs, err = e.DescribeKeyPairs(ctx, region, "")
+	if err != nil {
+		return "", err
+	}
+	for _, keyPair := range keyPairs {
+		if normalizeFingerprint(keyPair.KeyPairFingerPrint) == normalizeFingerprint(fingerprint) {
+			log.Info("ssh key already exists", "name", keyPair.KeyPairName, "region", region)
+			return keyPair.KeyPairName, nil
+		}
+	}
+	return "", err
+}
+
+// DeleteKeyPair deletes the key pair from the region, deleting a missing key
+// pair is not an error.
+func (e *ECS) DeleteKeyPair(ctx context.Context, region, name string) error {
+	names, err := json.Marshal([]string{name})
+	if err != nil {
+		return err
+	}
+	err = e.call(ctx, "DeleteKeyPairs", map[string]string{
+		"RegionId":     region,
+		"KeyPairNames": string(names),
+	}, nil)
+	if err != nil {
+		return err
+	}
+	log.Info("ssh key deleted", "name", name, "region", region)
+	return nil
+}
+
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+}
+
+type RunInstanceRequest struct {
+	Region                  string
+	Name                    string
+	InstanceType            string
+	ImageID                 string
+	KeyPairName             string
+	Tag                     string
+	SecurityGroupID         string
+	VSwitchID               string
+	InternetMaxBandwidthOut int
+	UserData                string
+}
+
+func (e *ECS) RunInstance(ctx context.Context, r *RunInstanceRequest) (string, error) {
+	params := map[string]string{
+		"RegionId":                r.Region,
+		"InstanceName":            r.Name,
+		"HostName":                r.Name,
+		"InstanceType":            r.InstanceType,
+		"ImageId":                 r.ImageID,
+		"KeyPairName":             r.KeyPairName,
+		"InternetChargeType":      "PayByTraffic",
+		"InternetMaxBandwidthOut": fmt.Sprintf("%d", r.InternetMaxBandwidthOut),
+		"Amount":                  "1",
+		"Tag.1.Key":               r.Tag,
+	}
+	if r.SecurityGroupID != "" {
+		params["SecurityGroupId"] = r.SecurityGroupID
+	}
+	if r.VSwitchID != "" {
+		params["VSwitchId"] = r.VSwitchID
+	}
+	if r.UserData != "" {
+		params["UserData"] = base64.StdEncoding.EncodeToString([]byte(r.UserData))
+	}
+	var resp struct {
+		InstanceIdSets struct {
+			InstanceIdSet []string
+		}
+	}
+	if err := e.call(ctx, "RunInstances", params, &resp); err != nil {
+		return "", err
+	}
+	if len(resp.InstanceIdSets.InstanceIdSet) == 0 {
+		return "", fmt.Errorf("no instance created")
+	}
+	return resp.InstanceIdSets.InstanceIdSet[0], nil
+}
+
+// DescribeInstances lists the instances of the region matching the filters
+// (e.g. InstanceName, Tag.1.Key or InstanceIds), walking every page.
+func (e *ECS) DescribeInstances(ctx context.Context, region string, filters map[string]string) ([]Instance, error) {
+	instances := []Instance{}
+	for page := 1; ; page++ {
+		params := map[string]string{
+			"RegionId":   region,
+			"PageNumber": fmt.Sprintf("%d", page),
+			"PageSize":   fmt.Sprintf("%d", pageSize),
+		}
+		for k, v := range filters {
+			params[k] = v
+		}
+		var resp struct {
+			TotalCount int
+			Instances  struct {
+				Instance []Instance
+			}
+		}
+		if err := e.call(ctx, "DescribeInstances", params, &resp); err != nil {
+			return nil, err
+		}
+		instances = append(instances, resp.Instances.Instance...)
+		if len(resp.Instances.Instance) < pageSize || len(instances) >= resp.TotalCount {
+			return instances, nil
+		}
+	}
+}
+
+// WaitInstance polls the instance until it is running and has a public ip
+// address, or ctx is done.
+func (e *ECS) WaitInstance(ctx context.Context, region, id string) (*Instance, error) {
+	ids, _ := json.Marshal([]string{id})
+	numTries := 0
+	for {
+		instances, err := e.DescribeInstances(ctx, region, map[string]string{
+			"InstanceIds": string(ids),
+		})
+		if err != nil {
+			log.Error("error occured while getting instance", "error", err.Error())
+		} else if len(instances) > 0 {
+			instance := instances[0]
+			ip, ipErr := instance.PublicIPv4()
+			log.Debug("waiting", "instance_id", id, "ip", ip, "status", instance.Status, "num_tries", numTries)
+			if instance.Status == "Running" && ipErr == nil {
+				return &instance, nil
+			}
+		}
+		numTries++
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("instance %s is not running: %w", id, ctx.Err())
+		case <-time.After(1 * time.Second):
+		}
+	}
+}
+
+func (e *ECS) DeleteInstance(ctx context.Context, region, id string) error {
+	return e.call(ctx, "DeleteInstances", map[string]string{
+		"RegionId":     region,
+		"InstanceId.1": id,
+		"Force":        "true",
+	}, nil)
+}
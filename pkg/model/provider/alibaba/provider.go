@@ -1,41 +1,240 @@
 package alibaba
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
 	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/api"
 	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/util/sshutil"
+	"github.com/charmbracelet/log"
 )
 
+// AlibabaProvider manages ECS instances. Scheduler tags are stored as ECS tag
+// keys. Servers are listed in the default region, in the regions added with
+// WithRegions and in every region a server has been created in.
 type AlibabaProvider struct {
+	ecs                     *ECS
+	securityGroupID         string
+	vSwitchID               string
+	internetMaxBandwidthOut int
+
+	mu      sync.Mutex
+	regions map[string]bool
 }
 
+// NewProvider creates a provider from a token of the form
+// "ACCESS_KEY_ID:ACCESS_KEY_SECRET".
 func NewProvider(token string) *AlibabaProvider {
-	return &AlibabaProvider{}
+	accessKeyID, accessKeySecret, _ := strings.Cut(token, ":")
+	return &AlibabaProvider{
+		ecs:                     newECS(accessKeyID, accessKeySecret),
+		internetMaxBandwidthOut: 100,
+		regions:                 map[string]bool{"cn-hangzhou": true},
+	}
+}
+
+// WithRegion replaces the default region used to list servers.
+func (a *AlibabaProvider) WithRegion(region string) *AlibabaProvider {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.regions = map[string]bool{region: true}
+	return a
 }
 
-func (a *AlibabaProvider) ListServers() []server.Server {
-	panic("not implemented")
+// WithRegions adds regions to list servers in, e.g. the ones the servers of a
+// previous run have been created in.
+func (a *AlibabaProvider) WithRegions(regions ...string) *AlibabaProvider {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, region := range regions {
+		a.regions[region] = true
+	}
+	return a
 }
 
-func (a *AlibabaProvider) ListServersByName(name string) []server.Server {
-	panic("not implemented")
+func (a *AlibabaProvider) WithEndpoint(endpoint string) *AlibabaProvider {
+	a.ecs.endpoint = strings.TrimRight(endpoint, "/")
+	return a
 }
 
-func (a *AlibabaProvider) ListServersByTag(tag string) []server.Server {
-	panic("not implemented")
+func (a *AlibabaProvider) WithSecurityGroupID(securityGroupID string) *AlibabaProvider {
+	a.securityGroupID = securityGroupID
+	return a
 }
 
-func (a *AlibabaProvider) CreateKeyPair(name string, pubkey string) error {
-	panic("not implemented")
+func (a *AlibabaProvider) WithVSwitchID(vSwitchID string) *AlibabaProvider {
+	a.vSwitchID = vSwitchID
+	return a
+}
+
+// WithInternetMaxBandwidthOut sets the outbound bandwidth in Mbps, it must be
+// positive for instances to be assigned a public ip address.
+func (a *AlibabaProvider) WithInternetMaxBandwidthOut(bandwidth int) *AlibabaProvider {
+	a.internetMaxBandwidthOut = bandwidth
+	return a
+}
+
+func (a *AlibabaProvider) listRegions() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	regions := []string{}
+	for region := range a.regions {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	return regions
+}
+
+func (a *AlibabaProvider) addRegion(region string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.regions[region] = true
+}
+
+func (a *AlibabaProvider) listServers(ctx context.Context, filters map[string]string) ([]server.Server, error) {
+	servers := []server.Server{}
+	for _, region := range a.listRegions() {
+		instances, err := a.ecs.DescribeInstances(ctx, region, filters)
+		if err != nil {
+			log.Error("error occured when listing instances", "region", region, "error", err.Error())
+			return nil, fmt.Errorf("failed to list instances in %s: %w", region, err)
+		}
+		for _, instance := range instances {
+			servers = append(servers, NewServer(instance))
+		}
+	}
+	return servers, nil
+}
+
+func (a *AlibabaProvider) ListServers(ctx context.Context) ([]server.Server, error) {
+	return a.listServers(ctx, nil)
+}
+
+func (a *AlibabaProvider) ListServersByName(ctx context.Context, name string) ([]server.Server, error) {
+	return a.listServers(ctx, map[string]string{"InstanceName": name})
+}
+
+func (a *AlibabaProvider) ListServersByTag(ctx context.Context, tag string) ([]server.Server, error) {
+	return a.listServers(ctx, map[string]string{"Tag.1.Key": tag})
+}
+
+// CreateKeyPair imports the key pair into every known region.
+func (a *AlibabaProvider) CreateKeyPair(ctx context.Context, name string, pubkey string) error {
+	fingerprint, err := sshutil.GetSSHPublicKeyFingerprintMD5(pubkey)
+	if err != nil {
+		return err
+	}
+	for _, region := range a.listRegions() {
+		if _, err := a.ecs.ImportKeyPair(ctx, region, name, pubkey, fingerprint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteKeyPair deletes the key pair from every known region.
+func (a *AlibabaProvider) DeleteKeyPair(ctx context.Context, name string, pubkey string) error {
+	for _, region := range a.listRegions() {
+		if err := a.ecs.DeleteKeyPair(ctx, region, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *AlibabaProvider) CreateServer(ctx context.Context, cso *api.CreateServerOptions) (server.Server, error) {
+	if len(cso.Volumes) > 0 {
+		return nil, api.ErrVolumesUnsupported
+	}
+	if len(cso.ReservedIPs) > 0 {
+		return nil, api.ErrReservedIPsUnsupported
+	}
+	if cso.HasFallback() {
+		return nil, api.ErrFallbackUnsupported
+	}
+	pubkey, err := os.ReadFile(cso.PublicKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	userData, err := cso.BuildUserData()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := cso.CreateContext(ctx)
+	defer cancel()
+	fingerprint, err := sshutil.GetSSHPublicKeyFingerprintMD5(string(pubkey))
+	if err != nil {
+		return nil, err
+	}
+	keyName := cso.PublicKeyName
+	if keyName == "" {
+		keyName = cso.Name
+	}
+	keyName, err = a.ecs.ImportKeyPair(ctx, cso.Region, keyName, string(pubkey), fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	a.addRegion(cso.Region)
+
+	log.Info("creating instance", "name", cso.Name, "region", cso.Region, "size", cso.Size, "image", cso.Image)
+	id, err := a.ecs.RunInstance(ctx, &RunInstanceRequest{
+		Region:                  cso.Region,
+		Name:                    cso.Name,
+		InstanceType:            cso.Size,
+		ImageID:                 cso.Image,
+		KeyPairName:             keyName,
+		Tag:                     cso.Tag,
+		SecurityGroupID:         a.securityGroupID,
+		VSwitchID:               a.vSwitchID,
+		InternetMaxBandwidthOut: a.internetMaxBandwidthOut,
+		UserData:                userData,
+	})
+	if err != nil {
+		log.Error("error occured while creating instance", "error", err.Error())
+		return nil, err
+	}
+	log.Info("instance created", "instance_id", id)
+	instance, err := a.ecs.WaitInstance(ctx, cso.Region, id)
+	if err != nil {
+		// Do not leave an instance stuck in provisioning behind
+		log.Error("instance did not start, destroying it", "instance_id", id, "error", err.Error())
+		cleanupCtx, cancel := api.CleanupContext(ctx)
+		defer cancel()
+		if deleteErr := a.ecs.DeleteInstance(cleanupCtx, cso.Region, id); deleteErr != nil {
+			log.Error("error occured when deleting instance", "instance_id", id, "error", deleteErr.Error())
+		}
+		return nil, err
+	}
+	return NewServer(*instance), nil
 }
 
-func (a *AlibabaProvider) CreateServer(cso *api.CreateServerOptions) (server.Server, error) {
-	panic("not implemented")
+func (a *AlibabaProvider) destroy(ctx context.Context, servers []server.Server, err error) error {
+	if err != nil {
+		return err
+	}
+	for _, s := range servers {
+		instance := s.(*Server).instance
+		log.Info("destroying instance", "instance_id", s.ID())
+		if err := a.ecs.DeleteInstance(ctx, instance.RegionId, instance.InstanceId); err != nil {
+			log.Error("error occured when deleting instance", "error", err.Error())
+			return fmt.Errorf("failed to delete instance %s: %w", instance.InstanceId, err)
+		}
+		log.Info("instance destroyed", "instance_id", s.ID())
+	}
+	return nil
 }
 
-func (a *AlibabaProvider) DestroyServerByName(name string) error {
-	panic("not implemented")
+func (a *AlibabaProvider) DestroyServerByName(ctx context.Context, name string) error {
+	servers, err := a.ListServersByName(ctx, name)
+	return a.destroy(ctx, servers, err)
 }
 
-func (a *AlibabaProvider) DestroyServerByTag(tag string) error {
-	panic("not implemented")
+func (a *AlibabaProvider) DestroyServerByTag(ctx context.Context, tag string) error {
+	servers, err := a.ListServersByTag(ctx, tag)
+	return a.destroy(ctx, servers, err)
 }
@@ -0,0 +1,289 @@
+package alibaba_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/alibaba"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/api"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/util/sshutil"
+)
+
+// fakeECS is a local stand-in for the ECS endpoints used by the provider
+type fakeECS struct {
+	mu sync.Mutex
+	// keyPairs maps the names of the key pairs to their fingerprints
+	keyPairs  map[string]string
+	instances map[string]*alibaba.Instance
+	describes map[string]int
+	// launches holds the parameters of every RunInstances call
+	launches []url.Values
+	nextID   int
+}
+
+func newFakeECS() *fakeECS {
+	return &fakeECS{
+		keyPairs:  make(map[string]string),
+		instances: make(map[string]*alibaba.Instance),
+		describes: make(map[string]int),
+	}
+}
+
+func (f *fakeECS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	q := r.URL.Query()
+	if q.Get("AccessKeyId") != "id" || q.Get("Signature") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"Code": "InvalidAccessKeyId.NotFound", "Message": "unknown key"})
+		return
+	}
+	switch q.Get("Action") {
+	case "DescribeKeyPairs":
+		keyPairs := []alibaba.KeyPair{}
+		if fingerprint, ok := f.keyPairs[q.Get("KeyPairName")]; ok {
+			keyPairs = append(keyPairs, alibaba.KeyPair{KeyPairName: q.Get("KeyPairName"), KeyPairFingerPrint: fingerprint})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"TotalCount": len(keyPairs),
+			"KeyPairs":   map[string]interface{}{"KeyPair": keyPairs},
+		})
+	case "ImportKeyPair":
+		if _, ok := f.keyPairs[q.Get("KeyPairName")]; ok {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"Code": "KeyPair.AlreadyExist", "Message": "key pair exists"})
+			return
+		}
+		f.keyPairs[q.Get("KeyPairName")], _ = sshutil.GetSSHPublicKeyFingerprintMD5(q.Get("PublicKeyBody"))
+		json.NewEncoder(w).Encode(map[string]string{"KeyPairName": q.Get("KeyPairName")})
+	case "DeleteKeyPairs":
+		names := []string{}
+		json.Unmarshal([]byte(q.Get("KeyPairNames")), &names)
+		for _, name := range names {
+			delete(f.keyPairs, name)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"RequestId": "1"})
+	case "RunInstances":
+		if q.Get("SecurityGroupId") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"Code": "MissingSecurityGroupId", "Message": "SecurityGroupId is mandatory for this action"})
+			return
+		}
+		if _, ok := f.keyPairs[q.Get("KeyPairName")]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"Code": "InvalidKeyPairName.NotFound", "Message": "key pair not found"})
+			return
+		}
+		f.launches = append(f.launches, q)
+		f.nextID++
+		id := fmt.Sprintf("i-%d", f.nextID)
+		instance := &alibaba.Instance{
+			InstanceId:   id,
+			InstanceName: q.Get("InstanceName"),
+			RegionId:     q.Get("RegionId"),
+			InstanceType: q.Get("InstanceType"),
+			ImageId:      q.Get("ImageId"),
+			Status:       "Pending",
+		}
+		instance.Tags.Tag = []alibaba.Tag{{TagKey: q.Get("Tag.1.Key")}}
+		f.instances[id] = instance
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"InstanceIdSets": map[string]interface{}{"InstanceIdSet": []string{id}},
+		})
+	case "DescribeInstances":
+		ids := []string{}
+		json.Unmarshal([]byte(q.Get("InstanceIds")), &ids)
+		instances := []alibaba.Instance{}
+		for id, instance := range f.instances {
+			if len(ids) > 0 && ids[0] != id {
+				continue
+			}
+			if q.Get("RegionId") != instance.RegionId {
+				continue
+			}
+			if q.Get("InstanceName") != "" && q.Get("InstanceName") != instance.InstanceName {
+				continue
+			}
+			if q.Get("Tag.1.Key") != "" && q.Get("Tag.1.Key") != instance.Tags.Tag[0].TagKey {
+				continue
+			}
+			// Instances are running with a public ip after the first poll
+			f.describes[id]++
+			if f.describes[id] > 1 {
+				instance.Status = "Running"
+				instance.PublicIpAddress.IpAddress = []string{"47.0.0.1"}
+			}
+			instances = append(instances, *instance)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"TotalCount": len(instances),
+			"Instances":  map[string]interface{}{"Instance": instances},
+		})
+	case "DeleteInstances":
+		delete(f.instances, q.Get("InstanceId.1"))
+		json.NewEncoder(w).Encode(map[string]string{"RequestId": "1"})
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"Code": "InvalidAction", "Message": q.Get("Action")})
+	}
+}
+
+func TestAlibabaProvider(t *testing.T) {
+	ctx := context.Background()
+	ecs := newFakeECS()
+	ts := httptest.NewServer(ecs)
+	defer ts.Close()
+
+	folder := t.TempDir()
+	if _, _, err := sshutil.CreateSSHKeyPair(folder, "id_rsa", 2048); err != nil {
+		t.Fatal(err)
+	}
+	p := alibaba.NewProvider("id:secret").WithEndpoint(ts.URL).WithRegion("cn-shanghai").WithSecurityGroupID("sg-1")
+	cso := api.NewCreateServerOptions().
+		WithName("zmap-0").
+		WithTag("zmap").
+		WithRegion("cn-shanghai").
+		WithSize("ecs.t6-c1m1.large").
+		WithImage("ubuntu_22_04_x64_20G_alibase_20240101.vhd").
+		WithPublicKeyName("zmap").
+		WithPublicKeyPath(filepath.Join(folder, "id_rsa.pub"))
+
+	s, err := p.CreateServer(ctx, cso)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ip, err := s.IPv4(); err != nil || ip != "47.0.0.1" {
+		t.Errorf("expected public ip to be waited for, got %q (%v)", ip, err)
+	}
+	if _, ok := ecs.keyPairs["zmap"]; !ok {
+		t.Errorf("expected key pair to be imported")
+	}
+	if n := len(list(t)(p.ListServersByTag(ctx, "zmap"))); n != 1 {
+		t.Errorf("expected 1 server with tag, got %d", n)
+	}
+	if n := len(list(t)(p.ListServersByTag(ctx, "other"))); n != 0 {
+		t.Errorf("expected no server with other tag, got %d", n)
+	}
+	if n := len(list(t)(p.ListServersByName(ctx, "zmap-0"))); n != 1 {
+		t.Errorf("expected 1 server with name, got %d", n)
+	}
+	if err := p.DestroyServerByTag(ctx, "zmap"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := len(list(t)(p.ListServers(ctx))); n != 0 {
+		t.Errorf("expected all servers to be destroyed, got %d", n)
+	}
+}
+
+func TestAlibabaProviderRegistered(t *testing.T) {
+	ctx := context.Background()
+	ecs := newFakeECS()
+	ts := httptest.NewServer(ecs)
+	defer ts.Close()
+
+	config := provider.Config{Token: "id:secret", Region: "cn-shanghai", Endpoint: ts.URL}
+	if _, err := provider.Use("alibaba", config); err == nil {
+		t.Fatalf("expected error without a security group")
+	}
+	config.SecurityGroupIDs = []string{"sg-1"}
+	config.SubnetID = "vsw-1"
+	p, err := provider.Use("alibaba", config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	folder := t.TempDir()
+	if _, _, err := sshutil.CreateSSHKeyPair(folder, "id_rsa", 2048); err != nil {
+		t.Fatal(err)
+	}
+	cso := api.NewCreateServerOptions().
+		WithName("zmap-0").
+		WithTag("zmap").
+		WithRegion("cn-shanghai").
+		WithPublicKeyName("zmap").
+		WithPublicKeyPath(filepath.Join(folder, "id_rsa.pub"))
+	if _, err := p.CreateServer(ctx, cso); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if launch := ecs.launches[0]; launch.Get("SecurityGroupId") != "sg-1" || launch.Get("VSwitchId") != "vsw-1" {
+		t.Errorf("expected the configured network, got %v", launch)
+	}
+}
+
+func TestAlibabaProviderReplacesStaleKeyPair(t *testing.T) {
+	ctx := context.Background()
+	ecs := newFakeECS()
+	ts := httptest.NewServer(ecs)
+	defer ts.Close()
+	// A previous run imported another key under the same name
+	ecs.keyPairs["zmap"] = "00:11:22:33:44:55:66:77:88:99:aa:bb:cc:dd:ee:ff"
+	_, pub, err := sshutil.GenerateSSHKeyPair(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := alibaba.NewProvider("id:secret").WithEndpoint(ts.URL)
+	if err := p.CreateKeyPair(ctx, "zmap", pub); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fingerprint, _ := sshutil.GetSSHPublicKeyFingerprintMD5(pub); ecs.keyPairs["zmap"] != fingerprint {
+		t.Errorf("expected the key pair to hold the new key, got %s", ecs.keyPairs["zmap"])
+	}
+}
+
+func TestAlibabaProviderRegions(t *testing.T) {
+	ctx := context.Background()
+	ecs := newFakeECS()
+	ts := httptest.NewServer(ecs)
+	defer ts.Close()
+	ecs.instances["i-1"] = &alibaba.Instance{InstanceId: "i-1", InstanceName: "zmap-0", RegionId: "cn-shanghai"}
+	ecs.instances["i-1"].Tags.Tag = []alibaba.Tag{{TagKey: "zmap"}}
+
+	// After a restart, only the configured regions are known
+	p := alibaba.NewProvider("id:secret").WithEndpoint(ts.URL)
+	if n := len(list(t)(p.ListServersByTag(ctx, "zmap"))); n != 0 {
+		t.Fatalf("expected no server in the default region, got %d", n)
+	}
+	p.WithRegions("cn-shanghai")
+	if n := len(list(t)(p.ListServersByTag(ctx, "zmap"))); n != 1 {
+		t.Fatalf("expected 1 server in the added region, got %d", n)
+	}
+	if err := p.DestroyServerByTag(ctx, "zmap"); err != nil || len(ecs.instances) != 0 {
+		t.Errorf("expected the server to be destroyed, got %v", err)
+	}
+}
+
+func TestAlibabaProviderReportsAPIErrors(t *testing.T) {
+	ctx := context.Background()
+	ts := httptest.NewServer(newFakeECS())
+	defer ts.Close()
+	p := alibaba.NewProvider("wrong:secret").WithEndpoint(ts.URL)
+	if err := p.CreateKeyPair(ctx, "zmap", ""); err == nil {
+		t.Errorf("expected error for invalid public key")
+	}
+	_, pub, err := sshutil.GenerateSSHKeyPair(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CreateKeyPair(ctx, "zmap", pub); err == nil {
+		t.Errorf("expected error for invalid access key")
+	}
+}
+
+// list fails the test if listing the servers failed
+func list(t *testing.T) func([]server.Server, error) []server.Server {
+	return func(servers []server.Server, err error) []server.Server {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return servers
+	}
+}
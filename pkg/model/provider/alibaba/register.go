@@ -0,0 +1,37 @@
+package alibaba
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider"
+)
+
+// The alibaba provider reads AccessKeyID, AccessKeySecret, Region, Regions,
+// Endpoint, SecurityGroupIDs, which must hold exactly one security group as
+// ECS requires, and SubnetID as the VSwitch. A Token of the form
+// "ACCESS_KEY_ID:ACCESS_KEY_SECRET" is accepted in place of the credentials.
+func init() {
+	provider.Register("alibaba", func(config provider.Config) (provider.CloudServiceProvider, error) {
+		accessKeyID, accessKeySecret := config.AccessKeyID, config.AccessKeySecret
+		if accessKeyID == "" {
+			accessKeyID, accessKeySecret, _ = strings.Cut(config.Token, ":")
+		}
+		if accessKeyID == "" || accessKeySecret == "" {
+			return nil, errors.New("alibaba: access key id and secret are required")
+		}
+		if len(config.SecurityGroupIDs) != 1 {
+			return nil, errors.New("alibaba: exactly one security group is required")
+		}
+		p := NewProvider(accessKeyID + ":" + accessKeySecret)
+		if config.Region != "" {
+			p.WithRegion(config.Region)
+		}
+		p.WithRegions(config.Regions...)
+		if config.Endpoint != "" {
+			p.WithEndpoint(config.Endpoint)
+		}
+		p.WithSecurityGroupID(config.SecurityGroupIDs[0]).WithVSwitchID(config.SubnetID)
+		return p, nil
+	})
+}
@@ -0,0 +1,98 @@
+package alibaba
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+)
+
+type Server struct {
+	instance Instance
+}
+
+func NewServer(instance Instance) *Server {
+	return &Server{
+		instance: instance,
+	}
+}
+
+func (s *Server) ID() string {
+	return s.instance.InstanceId
+}
+
+func (s *Server) Name() string {
+	return s.instance.InstanceName
+}
+
+func (s *Server) IPv4() (string, error) {
+	ip, err := s.instance.PublicIPv4()
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", server.ErrNoAddress, err)
+	}
+	return ip, nil
+}
+
+// IPv6 is not supported, classic ECS instances only have ipv4 addresses.
+func (s *Server) IPv6() (string, error) {
+	return "", fmt.Errorf("%w: instance %s has no ipv6 address", server.ErrNoAddress, s.instance.InstanceId)
+}
+
+func (s *Server) PrivateIPv4() (string, error) {
+	if ips := s.instance.VpcAttributes.PrivateIpAddress.IpAddress; len(ips) > 0 {
+		return ips[0], nil
+	}
+	return "", fmt.Errorf("%w: instance %s has no private ipv4 address", server.ErrNoAddress, s.instance.InstanceId)
+}
+
+func (s *Server) EgressIPv4() (string, error) {
+	return s.IPv4()
+}
+
+func (s *Server) Tags() []string {
+	tags := []string{}
+	for _, tag := range s.instance.Tags.Tag {
+		tags = append(tags, tag.TagKey)
+	}
+	return tags
+}
+
+func (s *Server) Region() string {
+	return s.instance.RegionId
+}
+
+// Size returns the instance type.
+func (s *Server) Size() string {
+	return s.instance.InstanceType
+}
+
+func (s *Server) Image() string {
+	return s.instance.ImageId
+}
+
+func (s *Server) Status() server.Status {
+	switch s.instance.Status {
+	case "Pending", "Starting":
+		return server.StatusProvisioning
+	case "Running":
+		return server.StatusRunning
+	case "Stopping", "Stopped":
+		return server.StatusStopped
+	}
+	return server.StatusUnknown
+}
+
+// CreatedAt parses the creation time, which ECS reports in minutes.
+func (s *Server) CreatedAt() time.Time {
+	created, _ := time.Parse("2006-01-02T15:04Z", s.instance.CreationTime)
+	return created
+}
+
+// HourlyPrice is unknown, ECS instances do not carry their price.
+func (s *Server) HourlyPrice() float64 {
+	return 0
+}
+
+func (s *Server) Provider() string {
+	return "alibaba"
+}
@@ -0,0 +1,21 @@
+package alibaba
+
+import "testing"
+
+// The example of the signature documentation of the ECS RPC API
+func TestSign(t *testing.T) {
+	params := map[string]string{
+		"Action":           "DescribeRegions",
+		"Format":           "XML",
+		"Version":          "2014-05-26",
+		"AccessKeyId":      "testid",
+		"SignatureMethod":  "HMAC-SHA1",
+		"Timestamp":        "2016-02-23T12:46:24Z",
+		"SignatureVersion": "1.0",
+		"SignatureNonce":   "3ee8c1b8-83d3-44af-a94f-4e0ad82fd6cf",
+	}
+	expected := "OLeaidS1JvxuMvnyHOwuJ+uX5qY="
+	if signature := sign("GET", params, "testsecret"); signature != expected {
+		t.Errorf("expected signature %s, got %s", expected, signature)
+	}
+}
@@ -0,0 +1,12 @@
+// Package all registers every built-in provider, import it for its side effects:
+//
+//	import _ "github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/all"
+package all
+
+import (
+	_ "github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/alibaba"
+	_ "github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/aws"
+	_ "github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/digitalocean"
+	_ "github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/hetzner"
+	_ "github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/static"
+)
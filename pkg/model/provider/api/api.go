@@ -1,5 +1,28 @@
 package api
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/util/cloudinit"
+)
+
+// ErrCapacity is returned when no preferred region has capacity for any
+// preferred size.
+var ErrCapacity = errors.New("no capacity for the preferred regions and sizes")
+
+// ErrReservedIPsUnsupported is returned by providers which can not assign
+// reserved IPs.
+var ErrReservedIPsUnsupported = errors.New("reserved IPs are not supported by the provider")
+
+// ErrFallbackUnsupported is returned by providers which can not fall back to
+// other regions or sizes.
+var ErrFallbackUnsupported = errors.New("region and size fallback is not supported by the provider")
+
 type CreateServerOptions struct {
 	Name           string
 	Tag            string
@@ -9,6 +32,29 @@ type CreateServerOptions struct {
 	PublicKeyName  string
 	PublicKeyPath  string
 	PrivateKeyPath string
+	// Regions and Sizes are in order of preference, providers supporting it
+	// fall back to the next ones when out of capacity. Region and Size are
+	// the most preferred ones.
+	Regions []string
+	Sizes   []string
+	// Spot requests interruptible spot capacity on providers supporting it
+	Spot bool
+	// SpotMaxPrice is the maximum hourly price, empty means the on-demand price
+	SpotMaxPrice string
+	// UserData is passed as is to cloud-init at first boot
+	UserData string
+	// CloudInitTemplate is a text/template rendered with the options, usually a #cloud-config document
+	CloudInitTemplate string
+	// ProvisioningScripts are paths of local scripts run by cloud-init at first boot
+	ProvisioningScripts []string
+	// CreateTimeout bounds the creation of a server until it is reachable, 0 means no limit
+	CreateTimeout time.Duration
+	// Volumes are attached to the server, on providers supporting them
+	Volumes []VolumeOptions
+	// ReservedIPs is a pool of pre-allocated reserved IPs, one of them is
+	// assigned to the server and its outbound traffic comes from it, on
+	// providers supporting them
+	ReservedIPs []string
 }
 
 func NewCreateServerOptions() *CreateServerOptions {
@@ -20,6 +66,7 @@ func NewCreateServerOptions() *CreateServerOptions {
 		Image:          "ubuntu-20-04-x64",
 		PublicKeyPath:  "id_rsa.pub",
 		PrivateKeyPath: "id_rsa",
+		CreateTimeout:  10 * time.Minute,
 	}
 }
 
@@ -35,14 +82,78 @@ func (cso *CreateServerOptions) WithTag(tag string) *CreateServerOptions {
 
 func (cso *CreateServerOptions) WithRegion(region string) *CreateServerOptions {
 	cso.Region = region
+	cso.Regions = nil
 	return cso
 }
 
 func (cso *CreateServerOptions) WithSize(size string) *CreateServerOptions {
 	cso.Size = size
+	cso.Sizes = nil
 	return cso
 }
 
+// WithRegions sets the regions in order of preference.
+func (cso *CreateServerOptions) WithRegions(regions ...string) *CreateServerOptions {
+	if len(regions) > 0 {
+		cso.Region = regions[0]
+	}
+	cso.Regions = regions
+	return cso
+}
+
+// WithSizes sets the sizes in order of preference.
+func (cso *CreateServerOptions) WithSizes(sizes ...string) *CreateServerOptions {
+	if len(sizes) > 0 {
+		cso.Size = sizes[0]
+	}
+	cso.Sizes = sizes
+	return cso
+}
+
+// Placement is a region and a size to create a server with.
+type Placement struct {
+	Region string
+	Size   string
+}
+
+// Placements returns every region and size to try, in order of preference.
+// The preferred size is tried in every region before falling back to the
+// next size.
+func (cso *CreateServerOptions) Placements() []Placement {
+	regions, sizes := preferences(cso.Region, cso.Regions), preferences(cso.Size, cso.Sizes)
+	placements := []Placement{}
+	for _, size := range sizes {
+		for _, region := range regions {
+			placements = append(placements, Placement{Region: region, Size: size})
+		}
+	}
+	return placements
+}
+
+// HasFallback reports whether there is more than one placement to try.
+func (cso *CreateServerOptions) HasFallback() bool {
+	return len(cso.Placements()) > 1
+}
+
+// PreferredRegions returns the regions in order of preference.
+func (cso *CreateServerOptions) PreferredRegions() []string {
+	return preferences(cso.Region, cso.Regions)
+}
+
+// preferences returns the preferred value followed by the others, without
+// duplicates.
+func preferences(preferred string, others []string) []string {
+	values := []string{}
+	seen := map[string]bool{}
+	for _, value := range append([]string{preferred}, others...) {
+		if !seen[value] {
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
 func (cso *CreateServerOptions) WithImage(image string) *CreateServerOptions {
 	cso.Image = image
 	return cso
@@ -62,3 +173,88 @@ func (cso *CreateServerOptions) WithPublicKeyPath(publicKeyPath string) *CreateS
 	cso.PublicKeyPath = publicKeyPath
 	return cso
 }
+
+func (cso *CreateServerOptions) WithSpot(spot bool) *CreateServerOptions {
+	cso.Spot = spot
+	return cso
+}
+
+func (cso *CreateServerOptions) WithSpotMaxPrice(spotMaxPrice string) *CreateServerOptions {
+	cso.SpotMaxPrice = spotMaxPrice
+	return cso
+}
+
+func (cso *CreateServerOptions) WithUserData(userData string) *CreateServerOptions {
+	cso.UserData = userData
+	return cso
+}
+
+func (cso *CreateServerOptions) WithCloudInitTemplate(cloudInitTemplate string) *CreateServerOptions {
+	cso.CloudInitTemplate = cloudInitTemplate
+	return cso
+}
+
+// WithReservedIPs sets the pool of reserved IPs to assign to the servers.
+func (cso *CreateServerOptions) WithReservedIPs(ips ...string) *CreateServerOptions {
+	cso.ReservedIPs = ips
+	return cso
+}
+
+func (cso *CreateServerOptions) WithProvisioningScripts(paths ...string) *CreateServerOptions {
+	cso.ProvisioningScripts = append(cso.ProvisioningScripts, paths...)
+	return cso
+}
+
+// BuildUserData assembles the parts added by the provider (e.g. MountParts),
+// the user data, the rendered cloud-init template and the provisioning
+// scripts, in this order, into a single user data. It returns an empty string
+// if none of them is set.
+func (cso *CreateServerOptions) BuildUserData(extra ...cloudinit.Part) (string, error) {
+	parts := append([]cloudinit.Part{}, extra...)
+	if cso.UserData != "" {
+		parts = append(parts, cloudinit.NewPart("user-data", cso.UserData))
+	}
+	if cso.CloudInitTemplate != "" {
+		rendered, err := cloudinit.Render(cso.CloudInitTemplate, cso)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, cloudinit.NewPart("cloud-config", rendered))
+	}
+	for _, path := range cso.ProvisioningScripts {
+		script, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read provisioning script: %w", err)
+		}
+		parts = append(parts, cloudinit.NewPart(filepath.Base(path), string(script)))
+	}
+	return cloudinit.Multipart(parts...)
+}
+
+// WithVolume attaches the volume to the servers, nil is ignored.
+func (cso *CreateServerOptions) WithVolume(vo *VolumeOptions) *CreateServerOptions {
+	if vo == nil {
+		return cso
+	}
+	cso.Volumes = append(cso.Volumes, *vo)
+	return cso
+}
+
+func (cso *CreateServerOptions) WithCreateTimeout(createTimeout time.Duration) *CreateServerOptions {
+	cso.CreateTimeout = createTimeout
+	return cso
+}
+
+// CreateContext returns a context bounded by the create timeout.
+func (cso *CreateServerOptions) CreateContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if cso.CreateTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, cso.CreateTimeout)
+}
+
+// CleanupContext returns a context to release resources left behind by a
+// failed creation, which outlives the cancellation of ctx.
+func CleanupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+}
@@ -0,0 +1,48 @@
+package api
+
+// Everyone are the sources of an inbound rule open to the internet.
+var Everyone = []string{"0.0.0.0/0", "::/0"}
+
+// InboundRule allows a protocol (tcp, udp or icmp) on a port or range of
+// ports (e.g. "80" or "8000-8080") from the sources (addresses or CIDRs).
+type InboundRule struct {
+	Protocol string
+	Ports    string
+	Sources  []string
+}
+
+// FirewallOptions describes the firewall in front of the servers of a tag.
+// Everything not allowed inbound is dropped, outbound traffic is allowed.
+type FirewallOptions struct {
+	// SSHSources may connect to port 22, the egress IP of the controller if empty
+	SSHSources   []string
+	InboundRules []InboundRule
+}
+
+func NewFirewallOptions() *FirewallOptions {
+	return &FirewallOptions{}
+}
+
+func (fo *FirewallOptions) WithSSHSources(sources ...string) *FirewallOptions {
+	fo.SSHSources = append(fo.SSHSources, sources...)
+	return fo
+}
+
+// WithInboundRule allows the protocol on the ports, from everyone if no
+// source is given.
+func (fo *FirewallOptions) WithInboundRule(protocol, ports string, sources ...string) *FirewallOptions {
+	if len(sources) == 0 {
+		sources = Everyone
+	}
+	fo.InboundRules = append(fo.InboundRules, InboundRule{
+		Protocol: protocol,
+		Ports:    ports,
+		Sources:  sources,
+	})
+	return fo
+}
+
+// Rules returns the inbound rules, SSH first.
+func (fo *FirewallOptions) Rules() []InboundRule {
+	return append([]InboundRule{{Protocol: "tcp", Ports: "22", Sources: fo.SSHSources}}, fo.InboundRules...)
+}
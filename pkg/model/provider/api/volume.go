@@ -0,0 +1,87 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/util/cloudinit"
+)
+
+// ErrVolumesUnsupported is returned by providers which can not attach volumes.
+var ErrVolumesUnsupported = errors.New("volumes are not supported by the provider")
+
+// VolumeOptions describes a block storage volume attached to a server,
+// formatted and mounted at first boot.
+type VolumeOptions struct {
+	// Name is unique among the volumes of a server
+	Name       string
+	SizeGB     int
+	Filesystem string
+	MountPoint string
+	// Keep leaves the volume behind when the servers are destroyed
+	Keep bool
+}
+
+// NewVolumeOptions returns an ext4 volume mounted at /mnt/<name>.
+func NewVolumeOptions(name string, sizeGB int) *VolumeOptions {
+	return &VolumeOptions{
+		Name:       name,
+		SizeGB:     sizeGB,
+		Filesystem: "ext4",
+		MountPoint: filepath.Join("/mnt", name),
+	}
+}
+
+func (vo *VolumeOptions) WithFilesystem(filesystem string) *VolumeOptions {
+	vo.Filesystem = filesystem
+	return vo
+}
+
+func (vo *VolumeOptions) WithMountPoint(mountPoint string) *VolumeOptions {
+	vo.MountPoint = mountPoint
+	return vo
+}
+
+func (vo *VolumeOptions) WithKeep(keep bool) *VolumeOptions {
+	vo.Keep = keep
+	return vo
+}
+
+// VolumeNames returns the names in the cloud of the volumes of the server,
+// by volume name. Kept volumes outlive the server, whose name is reused by the
+// next runs, so their names end with a random suffix.
+func (cso *CreateServerOptions) VolumeNames() (map[string]string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	names := map[string]string{}
+	for _, vo := range cso.Volumes {
+		name := cso.Name + "-" + vo.Name
+		if vo.Keep {
+			name += "-" + hex.EncodeToString(suffix)
+		}
+		names[vo.Name] = name
+	}
+	return names, nil
+}
+
+// MountParts returns the user data mounting the volumes, device returns the
+// path of the block device of a volume on the server. The script sorts before
+// the provisioning scripts, which cloud-init runs in the order of their names.
+func (cso *CreateServerOptions) MountParts(device func(VolumeOptions) string) []cloudinit.Part {
+	if len(cso.Volumes) == 0 {
+		return nil
+	}
+	mounts := []cloudinit.Mount{}
+	for _, vo := range cso.Volumes {
+		mounts = append(mounts, cloudinit.Mount{
+			Device:     device(vo),
+			Filesystem: vo.Filesystem,
+			MountPoint: vo.MountPoint,
+		})
+	}
+	return []cloudinit.Part{cloudinit.NewPart("00-mount-volumes.sh", cloudinit.MountScript(mounts...))}
+}
@@ -0,0 +1,266 @@
+package aws
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/util/ratelimit"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/util/sshutil"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"github.com/charmbracelet/log"
+)
+
+// spotTerminationCodes are the state reasons of spot instances reclaimed by EC2
+var spotTerminationCodes = map[string]bool{
+	"Server.SpotInstanceTermination": true,
+	"Server.SpotInstanceShutdown":    true,
+}
+
+// isInterrupted reports whether the instance is a spot instance which has
+// been reclaimed by EC2.
+func isInterrupted(instance types.Instance) bool {
+	if instance.InstanceLifecycle != types.InstanceLifecycleTypeSpot {
+		return false
+	}
+	if instance.StateReason == nil || instance.StateReason.Code == nil {
+		return false
+	}
+	return spotTerminationCodes[*instance.StateReason.Code]
+}
+
+func isErrorCode(err error, code string) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == code
+}
+
+// EC2 wraps the EC2 client, every call targets the given region.
+type EC2 struct {
+	client *ec2.Client
+}
+
+func newEC2(accessKeyID, secretAccessKey, endpoint string) *EC2 {
+	options := ec2.Options{
+		Region:     "us-east-1",
+		HTTPClient: ratelimit.NewClient(nil),
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{
+				AccessKeyID:     accessKeyID,
+				SecretAccessKey: secretAccessKey,
+				Source:          "dode",
+			}, nil
+		}),
+	}
+	if endpoint != "" {
+		options.BaseEndpoint = aws.String(endpoint)
+	}
+	return &EC2{
+		client: ec2.New(options),
+	}
+}
+
+func inRegion(region string) func(*ec2.Options) {
+	return func(o *ec2.Options) {
+		o.Region = region
+	}
+}
+
+// findKeyPair reports whether the region has a key pair named so, and whether
+// it holds the public key.
+func (e *EC2) findKeyPair(ctx context.Context, region, name, pubkey string) (bool, bool, error) {
+	output, err := e.client.DescribeKeyPairs(ctx, &ec2.DescribeKeyPairsInput{
+		KeyNames:         []string{name},
+		IncludePublicKey: aws.Bool(true),
+	}, inRegion(region))
+	if isErrorCode(err, "InvalidKeyPair.NotFound") {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	for _, keyPair := range output.KeyPairs {
+		if aws.ToString(keyPair.KeyName) == name {
+			return true, sshutil.SameKey(aws.ToString(keyPair.PublicKey), pubkey), nil
+		}
+	}
+	return false, false, nil
+}
+
+// ImportKeyPair makes sure the key pair named so holds the public key in the
+// region. Key pairs cannot be updated, so one holding another key is replaced.
+func (e *EC2) ImportKeyPair(ctx context.Context, region, name, pubkey string) error {
+	found, same, err := e.findKeyPair(ctx, region, name, pubkey)
+	if err != nil {
+		return err
+	}
+	if same {
+		log.Info("ssh key already exists", "name", name, "region", region)
+		return nil
+	}
+	if found {
+		log.Warn("ssh key holds another public key, replacing it", "name", name, "region", region)
+		if err := e.DeleteKeyPair(ctx, region, name); err != nil {
+			return fmt.Errorf("failed to replace key pair %s holding another public key: %w", name, err)
+		}
+	}
+	_, err = e.client.ImportKeyPair(ctx, &ec2.ImportKeyPairInput{
+		KeyName:           aws.String(name),
+		PublicKeyMaterial: []byte(pubkey),
+	}, inRegion(region))
+	if isErrorCode(err, "InvalidKeyPair.Duplicate") {
+		// Imported meanwhile, e.g. by another dispatcher
+		_, same, err := e.findKeyPair(ctx, region, name, pubkey)
+		if err == nil && !same {
+			err = fmt.Errorf("key pair %s holds another public key", name)
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	log.Info("ssh key imported", "name", name, "region", region)
+	return nil
+}
+
+// DeleteKeyPair deletes the key pair from the region, if it exists.
+func (e *EC2) DeleteKeyPair(ctx context.Context, region, name string) error {
+	_, err := e.client.DeleteKeyPair(ctx, &ec2.DeleteKeyPairInput{
+		KeyName: aws.String(name),
+	}, inRegion(region))
+	if err != nil && !isErrorCode(err, "InvalidKeyPair.NotFound") {
+		return err
+	}
+	log.Info("ssh key deleted", "name", name, "region", region)
+	return nil
+}
+
+type RunInstanceRequest struct {
+	Region           string
+	Name             string
+	InstanceType     string
+	ImageID          string
+	KeyName          string
+	Tag              string
+	SubnetID         string
+	SecurityGroupIDs []string
+	Spot             bool
+	SpotMaxPrice     string
+	UserData         string
+}
+
+func (e *EC2) RunInstance(ctx context.Context, r *RunInstanceRequest) (string, error) {
+	input := &ec2.RunInstancesInput{
+		ImageId:      aws.String(r.ImageID),
+		InstanceType: types.InstanceType(r.InstanceType),
+		KeyName:      aws.String(r.KeyName),
+		MinCount:     aws.Int32(1),
+		MaxCount:     aws.Int32(1),
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeInstance,
+				Tags: []types.Tag{
+					{Key: aws.String("Name"), Value: aws.String(r.Name)},
+					{Key: aws.String(r.Tag), Value: aws.String("")},
+				},
+			},
+		},
+	}
+	if r.SubnetID != "" {
+		input.SubnetId = aws.String(r.SubnetID)
+	}
+	if len(r.SecurityGroupIDs) > 0 {
+		input.SecurityGroupIds = r.SecurityGroupIDs
+	}
+	if r.UserData != "" {
+		input.UserData = aws.String(base64.StdEncoding.EncodeToString([]byte(r.UserData)))
+	}
+	if r.Spot {
+		spotOptions := &types.SpotMarketOptions{
+			SpotInstanceType:             types.SpotInstanceTypeOneTime,
+			InstanceInterruptionBehavior: types.InstanceInterruptionBehaviorTerminate,
+		}
+		if r.SpotMaxPrice != "" {
+			spotOptions.MaxPrice = aws.String(r.SpotMaxPrice)
+		}
+		input.InstanceMarketOptions = &types.InstanceMarketOptionsRequest{
+			MarketType:  types.MarketTypeSpot,
+			SpotOptions: spotOptions,
+		}
+	}
+	output, err := e.client.RunInstances(ctx, input, inRegion(r.Region))
+	if err != nil {
+		return "", err
+	}
+	if len(output.Instances) == 0 {
+		return "", fmt.Errorf("no instance created")
+	}
+	return aws.ToString(output.Instances[0].InstanceId), nil
+}
+
+// DescribeInstances lists the instances of the region matching the filters, walking every page.
+func (e *EC2) DescribeInstances(ctx context.Context, region string, filters []types.Filter, ids ...string) ([]types.Instance, error) {
+	instances := []types.Instance{}
+	paginator := ec2.NewDescribeInstancesPaginator(e.client, &ec2.DescribeInstancesInput{
+		Filters:     filters,
+		InstanceIds: ids,
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx, inRegion(region))
+		if err != nil {
+			return nil, err
+		}
+		for _, reservation := range output.Reservations {
+			instances = append(instances, reservation.Instances...)
+		}
+	}
+	return instances, nil
+}
+
+// WaitInstance polls the instance until it is running and has a public ip
+// address, or ctx is done.
+func (e *EC2) WaitInstance(ctx context.Context, region, id string) (*types.Instance, error) {
+	numTries := 0
+	for {
+		instances, err := e.DescribeInstances(ctx, region, nil, id)
+		if err != nil {
+			log.Error("error occured while getting instance", "error", err.Error())
+		} else if len(instances) > 0 {
+			instance := instances[0]
+			state := types.InstanceStateNamePending
+			if instance.State != nil {
+				state = instance.State.Name
+			}
+			log.Debug("waiting", "instance_id", id, "ip", aws.ToString(instance.PublicIpAddress), "status", state, "num_tries", numTries)
+			switch state {
+			case types.InstanceStateNameRunning:
+				if instance.PublicIpAddress != nil {
+					return &instance, nil
+				}
+			case types.InstanceStateNameShuttingDown, types.InstanceStateNameTerminated:
+				reason := ""
+				if instance.StateReason != nil {
+					reason = aws.ToString(instance.StateReason.Message)
+				}
+				return nil, fmt.Errorf("instance %s terminated while starting: %s", id, reason)
+			}
+		}
+		numTries++
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("instance %s is not running: %w", id, ctx.Err())
+		case <-time.After(1 * time.Second):
+		}
+	}
+}
+
+func (e *EC2) TerminateInstances(ctx context.Context, region string, ids []string) error {
+	_, err := e.client.TerminateInstances(ctx, &ec2.TerminateInstancesInput{
+		InstanceIds: ids,
+	}, inRegion(region))
+	return err
+}
@@ -0,0 +1,31 @@
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestIsInterrupted(t *testing.T) {
+	testcases := []struct {
+		lifecycle types.InstanceLifecycleType
+		reason    *types.StateReason
+		expected  bool
+	}{
+		{types.InstanceLifecycleTypeSpot, &types.StateReason{Code: aws.String("Server.SpotInstanceTermination")}, true},
+		{types.InstanceLifecycleTypeSpot, &types.StateReason{Code: aws.String("Server.SpotInstanceShutdown")}, true},
+		{types.InstanceLifecycleTypeSpot, &types.StateReason{Code: aws.String("Client.UserInitiatedShutdown")}, false},
+		{types.InstanceLifecycleTypeSpot, nil, false},
+		{"", &types.StateReason{Code: aws.String("Server.SpotInstanceTermination")}, false},
+	}
+	for _, testcase := range testcases {
+		instance := types.Instance{
+			InstanceLifecycle: testcase.lifecycle,
+			StateReason:       testcase.reason,
+		}
+		if actual := isInterrupted(instance); actual != testcase.expected {
+			t.Errorf("expected %v for %+v, got %v", testcase.expected, testcase.reason, actual)
+		}
+	}
+}
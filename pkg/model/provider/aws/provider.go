@@ -0,0 +1,283 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/api"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/charmbracelet/log"
+)
+
+// aliveStates are the states of instances which are (or will be) usable.
+// Stopped instances neither run tasks nor count towards the max concurrency.
+var aliveStates = []string{"pending", "running"}
+
+// defaultSSHUser is the login user of the Ubuntu AMIs, EC2 images refuse root
+// logins.
+const defaultSSHUser = "ubuntu"
+
+// Provider manages EC2 instances, optionally on spot capacity. Scheduler tags
+// are stored as EC2 tag keys. Servers are listed in the default region, in the
+// regions added with WithRegions and in every region a server has been
+// created in.
+type Provider struct {
+	ec2              *EC2
+	accessKeyID      string
+	secretAccessKey  string
+	subnetID         string
+	securityGroupIDs []string
+	sshUser          string
+
+	mu      sync.Mutex
+	regions map[string]bool
+}
+
+// NewProvider creates a provider from a token of the form
+// "ACCESS_KEY_ID:SECRET_ACCESS_KEY".
+func NewProvider(token string) *Provider {
+	accessKeyID, secretAccessKey, _ := strings.Cut(token, ":")
+	return &Provider{
+		ec2:             newEC2(accessKeyID, secretAccessKey, ""),
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		sshUser:         defaultSSHUser,
+		regions:         map[string]bool{"us-east-1": true},
+	}
+}
+
+// WithRegion replaces the default region used to list servers.
+func (p *Provider) WithRegion(region string) *Provider {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.regions = map[string]bool{region: true}
+	return p
+}
+
+// WithRegions adds regions to list servers in, e.g. the ones the servers of a
+// previous run have been created in.
+func (p *Provider) WithRegions(regions ...string) *Provider {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, region := range regions {
+		p.regions[region] = true
+	}
+	return p
+}
+
+func (p *Provider) WithEndpoint(endpoint string) *Provider {
+	p.ec2 = newEC2(p.accessKeyID, p.secretAccessKey, endpoint)
+	return p
+}
+
+func (p *Provider) WithSubnetID(subnetID string) *Provider {
+	p.subnetID = subnetID
+	return p
+}
+
+func (p *Provider) WithSecurityGroupIDs(securityGroupIDs ...string) *Provider {
+	p.securityGroupIDs = securityGroupIDs
+	return p
+}
+
+// WithSSHUser sets the user the servers are logged into, e.g. ec2-user for
+// Amazon Linux. It must be allowed to run docker.
+func (p *Provider) WithSSHUser(sshUser string) *Provider {
+	p.sshUser = sshUser
+	return p
+}
+
+func (p *Provider) newServer(instance types.Instance, region string) *Server {
+	return NewServer(instance, region).WithSSHUser(p.sshUser)
+}
+
+func (p *Provider) listRegions() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	regions := []string{}
+	for region := range p.regions {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	return regions
+}
+
+func (p *Provider) addRegion(region string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.regions[region] = true
+}
+
+func (p *Provider) listServers(ctx context.Context, filters ...types.Filter) ([]server.Server, error) {
+	filters = append(filters, types.Filter{
+		Name:   aws.String("instance-state-name"),
+		Values: aliveStates,
+	})
+	servers := []server.Server{}
+	for _, region := range p.listRegions() {
+		instances, err := p.ec2.DescribeInstances(ctx, region, filters)
+		if err != nil {
+			log.Error("error occured when listing instances", "region", region, "error", err.Error())
+			return nil, fmt.Errorf("failed to list instances in %s: %w", region, err)
+		}
+		for _, instance := range instances {
+			servers = append(servers, p.newServer(instance, region))
+		}
+	}
+	return servers, nil
+}
+
+func (p *Provider) ListServers(ctx context.Context) ([]server.Server, error) {
+	return p.listServers(ctx)
+}
+
+func (p *Provider) ListServersByName(ctx context.Context, name string) ([]server.Server, error) {
+	return p.listServers(ctx, types.Filter{
+		Name:   aws.String("tag:Name"),
+		Values: []string{name},
+	})
+}
+
+func (p *Provider) ListServersByTag(ctx context.Context, tag string) ([]server.Server, error) {
+	return p.listServers(ctx, types.Filter{
+		Name:   aws.String("tag-key"),
+		Values: []string{tag},
+	})
+}
+
+// ListInterruptedServersByTag lists the spot instances with the tag which
+// have been reclaimed by EC2. Terminated instances stay visible for about an
+// hour after they have been reclaimed.
+func (p *Provider) ListInterruptedServersByTag(ctx context.Context, tag string) ([]server.Server, error) {
+	servers := []server.Server{}
+	for _, region := range p.listRegions() {
+		instances, err := p.ec2.DescribeInstances(ctx, region, []types.Filter{
+			{Name: aws.String("tag-key"), Values: []string{tag}},
+			{Name: aws.String("instance-lifecycle"), Values: []string{"spot"}},
+		})
+		if err != nil {
+			log.Error("error occured when listing instances", "region", region, "error", err.Error())
+			return nil, fmt.Errorf("failed to list instances in %s: %w", region, err)
+		}
+		for _, instance := range instances {
+			if isInterrupted(instance) {
+				servers = append(servers, p.newServer(instance, region))
+			}
+		}
+	}
+	return servers, nil
+}
+
+// CreateKeyPair imports the key pair into every known region.
+func (p *Provider) CreateKeyPair(ctx context.Context, name string, pubkey string) error {
+	for _, region := range p.listRegions() {
+		if err := p.ec2.ImportKeyPair(ctx, region, name, pubkey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteKeyPair deletes the key pair from every known region.
+func (p *Provider) DeleteKeyPair(ctx context.Context, name string, pubkey string) error {
+	for _, region := range p.listRegions() {
+		if err := p.ec2.DeleteKeyPair(ctx, region, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Provider) CreateServer(ctx context.Context, cso *api.CreateServerOptions) (server.Server, error) {
+	if len(cso.Volumes) > 0 {
+		return nil, api.ErrVolumesUnsupported
+	}
+	if len(cso.ReservedIPs) > 0 {
+		return nil, api.ErrReservedIPsUnsupported
+	}
+	if cso.HasFallback() {
+		return nil, api.ErrFallbackUnsupported
+	}
+	pubkey, err := os.ReadFile(cso.PublicKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	userData, err := cso.BuildUserData()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := cso.CreateContext(ctx)
+	defer cancel()
+	keyName := cso.PublicKeyName
+	if keyName == "" {
+		keyName = cso.Name
+	}
+	if err := p.ec2.ImportKeyPair(ctx, cso.Region, keyName, string(pubkey)); err != nil {
+		return nil, err
+	}
+	p.addRegion(cso.Region)
+
+	log.Info("creating instance", "name", cso.Name, "region", cso.Region, "size", cso.Size, "image", cso.Image, "spot", cso.Spot)
+	id, err := p.ec2.RunInstance(ctx, &RunInstanceRequest{
+		Region:           cso.Region,
+		Name:             cso.Name,
+		InstanceType:     cso.Size,
+		ImageID:          cso.Image,
+		KeyName:          keyName,
+		Tag:              cso.Tag,
+		SubnetID:         p.subnetID,
+		SecurityGroupIDs: p.securityGroupIDs,
+		Spot:             cso.Spot,
+		SpotMaxPrice:     cso.SpotMaxPrice,
+		UserData:         userData,
+	})
+	if err != nil {
+		log.Error("error occured while creating instance", "error", err.Error())
+		return nil, err
+	}
+	log.Info("instance created", "instance_id", id)
+	instance, err := p.ec2.WaitInstance(ctx, cso.Region, id)
+	if err != nil {
+		// Do not leave an instance stuck in provisioning behind
+		log.Error("instance did not start, terminating it", "instance_id", id, "error", err.Error())
+		cleanupCtx, cancel := api.CleanupContext(ctx)
+		defer cancel()
+		if terminateErr := p.ec2.TerminateInstances(cleanupCtx, cso.Region, []string{id}); terminateErr != nil {
+			log.Error("error occured when terminating instance", "instance_id", id, "error", terminateErr.Error())
+		}
+		return nil, err
+	}
+	return p.newServer(*instance, cso.Region), nil
+}
+
+func (p *Provider) destroy(ctx context.Context, servers []server.Server, err error) error {
+	if err != nil {
+		return err
+	}
+	for _, s := range servers {
+		instance := s.(*Server)
+		log.Info("destroying instance", "instance_id", s.ID())
+		if err := p.ec2.TerminateInstances(ctx, instance.region, []string{instance.ID()}); err != nil {
+			log.Error("error occured when terminating instance", "error", err.Error())
+			return fmt.Errorf("failed to terminate instance %s: %w", instance.ID(), err)
+		}
+		log.Info("instance destroyed", "instance_id", s.ID())
+	}
+	return nil
+}
+
+func (p *Provider) DestroyServerByName(ctx context.Context, name string) error {
+	servers, err := p.ListServersByName(ctx, name)
+	return p.destroy(ctx, servers, err)
+}
+
+func (p *Provider) DestroyServerByTag(ctx context.Context, tag string) error {
+	servers, err := p.ListServersByTag(ctx, tag)
+	return p.destroy(ctx, servers, err)
+}
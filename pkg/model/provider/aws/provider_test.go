@@ -0,0 +1,314 @@
+package aws_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/api"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/aws"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/util/sshutil"
+)
+
+type fakeInstance struct {
+	id    string
+	name  string
+	tag   string
+	state string
+	ip    string
+}
+
+// fakeEC2 serves the EC2 query API calls used by the provider
+type fakeEC2 struct {
+	mu         sync.Mutex
+	instances  []*fakeInstance
+	terminated []string
+	// keyPairs maps the names of the key pairs to their public keys
+	keyPairs map[string]string
+	// launches holds the parameters of every RunInstances call
+	launches []url.Values
+}
+
+type xmlTag struct {
+	Key   string `xml:"key"`
+	Value string `xml:"value"`
+}
+
+type xmlInstance struct {
+	InstanceID   string   `xml:"instanceId"`
+	InstanceType string   `xml:"instanceType"`
+	IPAddress    string   `xml:"ipAddress,omitempty"`
+	StateName    string   `xml:"instanceState>name"`
+	Tags         []xmlTag `xml:"tagSet>item"`
+}
+
+type xmlKeyPair struct {
+	KeyName   string `xml:"keyName"`
+	PublicKey string `xml:"publicKey"`
+}
+
+type describeKeyPairsResponse struct {
+	XMLName  xml.Name     `xml:"DescribeKeyPairsResponse"`
+	KeyPairs []xmlKeyPair `xml:"keySet>item"`
+}
+
+type describeInstancesResponse struct {
+	XMLName      xml.Name      `xml:"DescribeInstancesResponse"`
+	Reservations []xmlInstance `xml:"reservationSet>item>instancesSet>item"`
+}
+
+// filters returns the values of the filters of the request by name
+func filters(form url.Values) map[string][]string {
+	result := map[string][]string{}
+	for i := 1; form.Get(fmt.Sprintf("Filter.%d.Name", i)) != ""; i++ {
+		name := form.Get(fmt.Sprintf("Filter.%d.Name", i))
+		for j := 1; form.Get(fmt.Sprintf("Filter.%d.Value.%d", i, j)) != ""; j++ {
+			result[name] = append(result[name], form.Get(fmt.Sprintf("Filter.%d.Value.%d", i, j)))
+		}
+	}
+	return result
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeEC2) matches(instance *fakeInstance, filters map[string][]string) bool {
+	for name, values := range filters {
+		switch name {
+		case "instance-state-name":
+			if !contains(values, instance.state) {
+				return false
+			}
+		case "tag-key":
+			if !contains(values, instance.tag) {
+				return false
+			}
+		case "tag:Name":
+			if !contains(values, instance.name) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (f *fakeEC2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	switch r.Form.Get("Action") {
+	case "DescribeInstances":
+		response := describeInstancesResponse{}
+		for _, instance := range f.instances {
+			if f.matches(instance, filters(r.Form)) {
+				response.Reservations = append(response.Reservations, xmlInstance{
+					InstanceID:   instance.id,
+					InstanceType: "t3.micro",
+					IPAddress:    instance.ip,
+					StateName:    instance.state,
+					Tags:         []xmlTag{{Key: "Name", Value: instance.name}, {Key: instance.tag}},
+				})
+			}
+		}
+		xml.NewEncoder(w).Encode(response)
+	case "RunInstances":
+		f.launches = append(f.launches, r.Form)
+		instance := &fakeInstance{
+			id:    fmt.Sprintf("i-%d", len(f.instances)+1),
+			name:  r.Form.Get("TagSpecification.1.Tag.1.Value"),
+			tag:   r.Form.Get("TagSpecification.1.Tag.2.Key"),
+			state: "running",
+			ip:    "192.0.2.10",
+		}
+		f.instances = append(f.instances, instance)
+		fmt.Fprintf(w, "<RunInstancesResponse><instancesSet><item><instanceId>%s</instanceId></item></instancesSet></RunInstancesResponse>", instance.id)
+	case "TerminateInstances":
+		for i := 1; r.Form.Get(fmt.Sprintf("InstanceId.%d", i)) != ""; i++ {
+			f.terminated = append(f.terminated, r.Form.Get(fmt.Sprintf("InstanceId.%d", i)))
+		}
+		fmt.Fprint(w, "<TerminateInstancesResponse></TerminateInstancesResponse>")
+	case "DescribeKeyPairs":
+		name := r.Form.Get("KeyName.1")
+		publicKey, ok := f.keyPairs[name]
+		if !ok {
+			f.fail(w, "InvalidKeyPair.NotFound", name)
+			return
+		}
+		xml.NewEncoder(w).Encode(describeKeyPairsResponse{KeyPairs: []xmlKeyPair{{KeyName: name, PublicKey: publicKey}}})
+	case "ImportKeyPair":
+		name := r.Form.Get("KeyName")
+		if _, ok := f.keyPairs[name]; ok {
+			f.fail(w, "InvalidKeyPair.Duplicate", name)
+			return
+		}
+		publicKey, _ := base64.StdEncoding.DecodeString(r.Form.Get("PublicKeyMaterial"))
+		f.keyPairs[name] = string(publicKey)
+		fmt.Fprintf(w, "<ImportKeyPairResponse><keyName>%s</keyName></ImportKeyPairResponse>", name)
+	case "DeleteKeyPair":
+		delete(f.keyPairs, r.Form.Get("KeyName"))
+		fmt.Fprint(w, "<DeleteKeyPairResponse><return>true</return></DeleteKeyPairResponse>")
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "<Response><Errors><Error><Code>InvalidAction</Code><Message>%s</Message></Error></Errors></Response>", r.Form.Get("Action"))
+	}
+}
+
+func (f *fakeEC2) fail(w http.ResponseWriter, code, message string) {
+	w.WriteHeader(http.StatusBadRequest)
+	fmt.Fprintf(w, "<Response><Errors><Error><Code>%s</Code><Message>%s</Message></Error></Errors></Response>", code, message)
+}
+
+func TestProviderListAndDestroyByTag(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeEC2{
+		instances: []*fakeInstance{
+			{id: "i-1", name: "zmap-0", tag: "zmap", state: "running", ip: "192.0.2.1"},
+			{id: "i-2", name: "zmap-1", tag: "zmap", state: "pending"},
+			{id: "i-3", name: "zmap-2", tag: "zmap", state: "stopped", ip: "192.0.2.3"},
+			{id: "i-4", name: "http-0", tag: "http", state: "running", ip: "192.0.2.4"},
+		},
+	}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	p := aws.NewProvider("id:secret").WithEndpoint(ts.URL).WithSSHUser("ec2-user")
+
+	servers := list(t)(p.ListServersByTag(ctx, "zmap"))
+	if len(servers) != 2 {
+		t.Fatalf("expected the stopped instance to be ignored, got %d servers", len(servers))
+	}
+	s := servers[0]
+	if s.Name() != "zmap-0" || s.ID() != "i-1" {
+		t.Errorf("unexpected server %s (%s)", s.Name(), s.ID())
+	}
+	if ip, err := s.IPv4(); err != nil || ip != "192.0.2.1" {
+		t.Errorf("unexpected ipv4 %s (%v)", ip, err)
+	}
+	if tags := s.Tags(); len(tags) != 1 || tags[0] != "zmap" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+	endpoint, ok := s.(server.SSHEndpoint)
+	if !ok || endpoint.SSHUser() != "ec2-user" || endpoint.SSHPort() != 22 {
+		t.Errorf("expected instances to be logged into as ec2-user")
+	}
+	if _, err := servers[1].IPv4(); err == nil {
+		t.Errorf("expected the pending instance to have no address")
+	}
+	if n := len(list(t)(p.ListServersByName(ctx, "http-0"))); n != 1 {
+		t.Errorf("expected 1 server named http-0, got %d", n)
+	}
+
+	if err := p.DestroyServerByTag(ctx, "zmap"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(fake.terminated, ",") != "i-1,i-2" {
+		t.Errorf("unexpected terminated instances %v", fake.terminated)
+	}
+}
+
+func TestProviderSurfacesErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, "<Response><Errors><Error><Code>AuthFailure</Code><Message>bad credentials</Message></Error></Errors></Response>")
+	}))
+	defer ts.Close()
+	p := aws.NewProvider("id:secret").WithEndpoint(ts.URL)
+	if _, err := p.ListServersByTag(context.Background(), "zmap"); err == nil || !strings.Contains(err.Error(), "AuthFailure") {
+		t.Errorf("expected the api error, got %v", err)
+	}
+}
+
+func TestProviderKeyPairs(t *testing.T) {
+	ctx := context.Background()
+	_, stale, err := sshutil.GenerateKeyPair(sshutil.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pub, err := sshutil.GenerateKeyPair(sshutil.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A previous run imported another key under the same name
+	fake := &fakeEC2{keyPairs: map[string]string{"zmap": stale}}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	p := aws.NewProvider("id:secret").WithEndpoint(ts.URL)
+
+	if err := p.CreateKeyPair(ctx, "zmap", pub); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !sshutil.SameKey(fake.keyPairs["zmap"], pub) {
+		t.Errorf("expected the key pair to hold the new key, got %s", fake.keyPairs["zmap"])
+	}
+	if err := p.CreateKeyPair(ctx, "zmap", pub); err != nil {
+		t.Errorf("expected the key pair to be reused, got %v", err)
+	}
+	if err := p.DeleteKeyPair(ctx, "zmap", pub); err != nil || len(fake.keyPairs) != 0 {
+		t.Errorf("expected the key pair to be deleted, got %v", err)
+	}
+}
+
+func TestProviderRegistered(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeEC2{keyPairs: map[string]string{}}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	p, err := provider.Use("aws", provider.Config{
+		Token:            "id:secret",
+		Region:           "eu-west-1",
+		Endpoint:         ts.URL,
+		SecurityGroupIDs: []string{"sg-1", "sg-2"},
+		SubnetID:         "subnet-1",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	folder := t.TempDir()
+	if _, _, err := sshutil.CreateSSHKeyPair(folder, "id_rsa", 2048); err != nil {
+		t.Fatal(err)
+	}
+	cso := api.NewCreateServerOptions().
+		WithName("zmap-0").
+		WithTag("zmap").
+		WithRegion("eu-west-1").
+		WithSize("t3.micro").
+		WithPublicKeyPath(filepath.Join(folder, "id_rsa.pub"))
+	if _, err := p.CreateServer(ctx, cso); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	launch := fake.launches[0]
+	if launch.Get("SecurityGroupId.1") != "sg-1" || launch.Get("SecurityGroupId.2") != "sg-2" || launch.Get("SubnetId") != "subnet-1" {
+		t.Errorf("expected the configured network, got %v", launch)
+	}
+}
+
+// list fails the test if listing the servers failed
+func list(t *testing.T) func([]server.Server, error) []server.Server {
+	return func(servers []server.Server, err error) []server.Server {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return servers
+	}
+}
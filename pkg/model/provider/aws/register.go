@@ -0,0 +1,43 @@
+package aws
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider"
+)
+
+// The aws provider reads AccessKeyID, AccessKeySecret, Region, Regions,
+// Endpoint, SSHUser, SecurityGroupIDs and SubnetID. Without security groups,
+// the instances get the default one, which has to allow SSH.
+// A Token of the form "ACCESS_KEY_ID:SECRET_ACCESS_KEY" is accepted in place
+// of the credentials.
+func init() {
+	provider.Register("aws", func(config provider.Config) (provider.CloudServiceProvider, error) {
+		accessKeyID, secretAccessKey := config.AccessKeyID, config.AccessKeySecret
+		if accessKeyID == "" {
+			accessKeyID, secretAccessKey, _ = strings.Cut(config.Token, ":")
+		}
+		if accessKeyID == "" || secretAccessKey == "" {
+			return nil, errors.New("aws: access key id and secret access key are required")
+		}
+		p := NewProvider(accessKeyID + ":" + secretAccessKey)
+		if config.Region != "" {
+			p.WithRegion(config.Region)
+		}
+		p.WithRegions(config.Regions...)
+		if config.Endpoint != "" {
+			p.WithEndpoint(config.Endpoint)
+		}
+		if config.SSHUser != "" {
+			p.WithSSHUser(config.SSHUser)
+		}
+		if len(config.SecurityGroupIDs) > 0 {
+			p.WithSecurityGroupIDs(config.SecurityGroupIDs...)
+		}
+		if config.SubnetID != "" {
+			p.WithSubnetID(config.SubnetID)
+		}
+		return p, nil
+	})
+}
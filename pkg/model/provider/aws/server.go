@@ -0,0 +1,140 @@
+package aws
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+type Server struct {
+	instance types.Instance
+	region   string
+	sshUser  string
+}
+
+func NewServer(instance types.Instance, region string) *Server {
+	return &Server{
+		instance: instance,
+		region:   region,
+		sshUser:  defaultSSHUser,
+	}
+}
+
+// WithSSHUser sets the user the server is logged into.
+func (s *Server) WithSSHUser(sshUser string) *Server {
+	s.sshUser = sshUser
+	return s
+}
+
+func (s *Server) ID() string {
+	return aws.ToString(s.instance.InstanceId)
+}
+
+func (s *Server) Name() string {
+	for _, tag := range s.instance.Tags {
+		if aws.ToString(tag.Key) == "Name" {
+			return aws.ToString(tag.Value)
+		}
+	}
+	return ""
+}
+
+// address returns the address unless it is empty.
+func (s *Server) address(kind string, ip *string) (string, error) {
+	if aws.ToString(ip) == "" {
+		return "", fmt.Errorf("%w: instance %s has no %s address", server.ErrNoAddress, s.ID(), kind)
+	}
+	return aws.ToString(ip), nil
+}
+
+func (s *Server) IPv4() (string, error) {
+	return s.address("public ipv4", s.instance.PublicIpAddress)
+}
+
+func (s *Server) IPv6() (string, error) {
+	return s.address("ipv6", s.instance.Ipv6Address)
+}
+
+func (s *Server) PrivateIPv4() (string, error) {
+	return s.address("private ipv4", s.instance.PrivateIpAddress)
+}
+
+func (s *Server) EgressIPv4() (string, error) {
+	return s.IPv4()
+}
+
+// Tags returns the keys of the instance tags, except the Name tag.
+func (s *Server) Tags() []string {
+	tags := []string{}
+	for _, tag := range s.instance.Tags {
+		if key := aws.ToString(tag.Key); key != "Name" {
+			tags = append(tags, key)
+		}
+	}
+	return tags
+}
+
+func (s *Server) SSHPort() int {
+	return 22
+}
+
+func (s *Server) SSHUser() string {
+	return s.sshUser
+}
+
+// SSHPrivateKeyPath is empty, the instances authorize the scheduler's key.
+func (s *Server) SSHPrivateKeyPath() string {
+	return ""
+}
+
+// Spot reports whether the server runs on spot capacity.
+func (s *Server) Spot() bool {
+	return s.instance.InstanceLifecycle == types.InstanceLifecycleTypeSpot
+}
+
+func (s *Server) Region() string {
+	return s.region
+}
+
+// Size returns the instance type.
+func (s *Server) Size() string {
+	return string(s.instance.InstanceType)
+}
+
+// Image returns the AMI ID.
+func (s *Server) Image() string {
+	return aws.ToString(s.instance.ImageId)
+}
+
+func (s *Server) Status() server.Status {
+	if s.instance.State == nil {
+		return server.StatusUnknown
+	}
+	switch s.instance.State.Name {
+	case types.InstanceStateNamePending:
+		return server.StatusProvisioning
+	case types.InstanceStateNameRunning:
+		return server.StatusRunning
+	case types.InstanceStateNameStopping, types.InstanceStateNameStopped:
+		return server.StatusStopped
+	case types.InstanceStateNameShuttingDown, types.InstanceStateNameTerminated:
+		return server.StatusTerminated
+	}
+	return server.StatusUnknown
+}
+
+func (s *Server) CreatedAt() time.Time {
+	return aws.ToTime(s.instance.LaunchTime)
+}
+
+// HourlyPrice is unknown, EC2 instances do not carry their price.
+func (s *Server) HourlyPrice() float64 {
+	return 0
+}
+
+func (s *Server) Provider() string {
+	return "aws"
+}
@@ -2,141 +2,552 @@ package digitalocean
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/api"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/util/ratelimit"
 	"github.com/WangYihang/digital-ocean-docker-executor/pkg/util/sshutil"
 	"github.com/charmbracelet/log"
 	"github.com/digitalocean/godo"
 )
 
 type DigitalOcean struct {
-	client *godo.Client
+	client  *godo.Client
+	watcher *watcher
 }
 
 func newDigitalOcean(token string) *DigitalOcean {
-	return &DigitalOcean{
-		client: godo.NewFromToken(token),
+	client := godo.NewFromToken(token)
+	// Share the rate limit of the account and retry throttled calls
+	client.HTTPClient.Transport = ratelimit.NewTransport(client.HTTPClient.Transport)
+	d := &DigitalOcean{
+		client: client,
 	}
+	d.watcher = newWatcher(d.ListDropletsByTag, time.Second)
+	return d
 }
 
-func (d *DigitalOcean) CreateSSHKeyPair(name string, pubkey string) (*godo.Key, error) {
-	fingerprint, err := sshutil.GetSSHPublicKeyFingerprintMD5(pubkey)
+// FindSSHKey returns the key of the account with the public key, nil if there
+// is none. Keys are compared by SHA256 fingerprint, DigitalOcean only
+// reports MD5 ones.
+func (d *DigitalOcean) FindSSHKey(ctx context.Context, pubkey string) (*godo.Key, error) {
+	keys, err := listAll("ssh keys", func(opt *godo.ListOptions) ([]godo.Key, *godo.Response, error) {
+		return d.client.Keys.List(ctx, opt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if sshutil.SameKey(key.PublicKey, pubkey) {
+			return &key, nil
+		}
+	}
+	return nil, nil
+}
+
+func (d *DigitalOcean) CreateSSHKeyPair(ctx context.Context, name string, pubkey string) (*godo.Key, error) {
+	fingerprint, err := sshutil.Fingerprint(pubkey)
 	if err != nil {
 		return nil, err
 	}
 	log.Info("public key fingerprint", "fingerprint", fingerprint)
 	// Check if key already exists
-	key, _, err := d.client.Keys.GetByFingerprint(context.Background(), fingerprint)
-	if err == nil {
-		log.Info("ssh key already exists", "name", key.Name, "fingerprint", key.Fingerprint)
+	key, err := d.FindSSHKey(ctx, pubkey)
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		log.Info("ssh key already exists", "name", key.Name, "fingerprint", fingerprint)
 		return key, nil
 	}
 	// If key does not exist, create it
-	key, _, err = d.client.Keys.Create(context.Background(), &godo.KeyCreateRequest{
+	key, _, err = d.client.Keys.Create(ctx, &godo.KeyCreateRequest{
 		Name:      name,
 		PublicKey: pubkey,
 	})
 	if err != nil {
 		return nil, err
 	}
-	log.Info("ssh key created", "name", key.Name, "fingerprint", key.Fingerprint)
+	log.Info("ssh key created", "name", key.Name, "fingerprint", fingerprint)
 	return key, nil
 }
 
-func (d *DigitalOcean) CreateDroplet(name, region, size, image, pubkey string, tag string) (*godo.Droplet, error) {
-	var err error
-	fingerprint, err := sshutil.GetSSHPublicKeyFingerprintMD5(pubkey)
-	if err != nil {
-		return nil, err
+// DeleteSSHKey deletes the key of the account with the public key if any.
+func (d *DigitalOcean) DeleteSSHKey(ctx context.Context, pubkey string) error {
+	key, err := d.FindSSHKey(ctx, pubkey)
+	if err != nil || key == nil {
+		return err
 	}
-	log.Info("retrieving ssh key", "fingerprint", fingerprint)
-	key, _, err := d.client.Keys.GetByFingerprint(context.Background(), fingerprint)
-	if err != nil {
-		log.Error("error occured while retrieving ssh key", "error", err.Error())
-		return nil, err
+	if _, err := d.client.Keys.DeleteByID(ctx, key.ID); err != nil {
+		log.Error("error occured when deleting ssh key", "name", key.Name, "error", err.Error())
+		return err
 	}
+	log.Info("ssh key deleted", "name", key.Name)
+	return nil
+}
+
+// CreateDroplet creates a droplet the SSH key with the ID is authorized on.
+func (d *DigitalOcean) CreateDroplet(ctx context.Context, name, region, size, image string, keyID int, tag, userData string, volumeIDs []string) (*godo.Droplet, error) {
+	var err error
 	log.Info("creating droplet", "name", name, "region", region, "size", size, "image", image)
+	volumes := []godo.DropletCreateVolume{}
+	for _, id := range volumeIDs {
+		volumes = append(volumes, godo.DropletCreateVolume{ID: id})
+	}
 	var gd *godo.Droplet
-	gd, _, err = d.client.Droplets.Create(context.Background(), &godo.DropletCreateRequest{
+	gd, _, err = d.client.Droplets.Create(ctx, &godo.DropletCreateRequest{
 		Name:   name,
 		Region: region,
 		Size:   size,
-		Image: godo.DropletCreateImage{
-			Slug: image,
-		},
+		Image:  dropletImage(image),
 		SSHKeys: []godo.DropletCreateSSHKey{
 			{
-				Fingerprint: key.Fingerprint,
+				ID: keyID,
 			},
 		},
 		Tags: []string{
 			tag,
 		},
-		IPv6: true,
+		IPv6:     true,
+		UserData: userData,
+		Volumes:  volumes,
 	})
 	if err != nil {
 		log.Error("error occured while creating droplet", "error", err.Error())
 		return nil, err
 	}
 	log.Info("droplet created", "droplet_id", gd.ID)
-	var status string = gd.Status
-	var numTries int = 0
-	for status != "active" {
-		gd, _, err = d.client.Droplets.Get(context.Background(), gd.ID)
+	active, err := d.WaitDroplet(ctx, tag, gd.ID)
+	if err != nil {
+		// Do not leave a droplet stuck in provisioning behind
+		log.Error("droplet did not become active, destroying it", "droplet_id", gd.ID, "error", err.Error())
+		cleanupCtx, cancel := api.CleanupContext(ctx)
+		defer cancel()
+		if _, deleteErr := d.client.Droplets.Delete(cleanupCtx, gd.ID); deleteErr != nil {
+			log.Error("error occured when deleting droplet", "droplet_id", gd.ID, "error", deleteErr.Error())
+		}
+		return nil, err
+	}
+	return active, nil
+}
+
+// capacityMessages are parts of the messages of the API when a region is out
+// of a size or a size is not offered
+var capacityMessages = []string{
+	"not available",
+	"unavailable",
+	"unable to provision",
+	"unable to fulfill",
+	"capacity",
+	"not supported",
+}
+
+// IsCapacityError reports whether the creation of a droplet failed because
+// the region or the size is unavailable, in which case another region or size
+// may succeed.
+func IsCapacityError(err error) bool {
+	var errResp *godo.ErrorResponse
+	if !errors.As(err, &errResp) || errResp.Response == nil {
+		return false
+	}
+	if errResp.Response.StatusCode != http.StatusUnprocessableEntity && errResp.Response.StatusCode != http.StatusServiceUnavailable {
+		return false
+	}
+	message := strings.ToLower(errResp.Message)
+	for _, capacityMessage := range capacityMessages {
+		if strings.Contains(message, capacityMessage) {
+			return true
+		}
+	}
+	return false
+}
+
+// WaitDroplet waits until the droplet is active or ctx is done. The droplets
+// of a tag are polled together.
+func (d *DigitalOcean) WaitDroplet(ctx context.Context, tag string, id int) (*godo.Droplet, error) {
+	droplet, err := d.watcher.Wait(ctx, tag, id)
+	if err != nil {
+		return nil, fmt.Errorf("droplet %d is not active: %w", id, err)
+	}
+	return droplet, nil
+}
+
+// dropletImage refers to a snapshot by its numeric ID, other images by slug.
+func dropletImage(image string) godo.DropletCreateImage {
+	if id, err := strconv.Atoi(image); err == nil {
+		return godo.DropletCreateImage{ID: id}
+	}
+	return godo.DropletCreateImage{Slug: image}
+}
+
+// listAll walks every page returned by list.
+func listAll[T any](what string, list func(*godo.ListOptions) ([]T, *godo.Response, error)) ([]T, error) {
+	items := []T{}
+	opt := &godo.ListOptions{PerPage: 200}
+	for {
+		page, resp, err := list(opt)
 		if err != nil {
-			log.Error("error occured while getting droplet", "error", err.Error())
-			continue
+			log.Error("error occured when listing "+what, "page", opt.Page, "error", err.Error())
+			return nil, err
+		}
+		items = append(items, page...)
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			return items, nil
 		}
-		ip, _ := gd.PublicIPv4()
-		status = gd.Status
-		log.Debug("waiting", "droplet_id", gd.ID, "ip", ip, "status", status, "num_tries", numTries)
-		time.Sleep(1 * time.Second)
-		numTries++
+		current, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, err
+		}
+		opt.Page = current + 1
 	}
-	return gd, nil
 }
 
-func (d *DigitalOcean) ListDroplets() []godo.Droplet {
-	droplets, _, err := d.client.Droplets.List(context.Background(), &godo.ListOptions{})
+func (d *DigitalOcean) ListDroplets(ctx context.Context) ([]godo.Droplet, error) {
+	return listAll("droplets", func(opt *godo.ListOptions) ([]godo.Droplet, *godo.Response, error) {
+		return d.client.Droplets.List(ctx, opt)
+	})
+}
+
+func (d *DigitalOcean) ListDropletsByTag(ctx context.Context, tag string) ([]godo.Droplet, error) {
+	return listAll("droplets", func(opt *godo.ListOptions) ([]godo.Droplet, *godo.Response, error) {
+		return d.client.Droplets.ListByTag(ctx, tag, opt)
+	})
+}
+
+// ListSizes returns every droplet size along with its price.
+func (d *DigitalOcean) ListSizes(ctx context.Context) ([]godo.Size, error) {
+	return listAll("sizes", func(opt *godo.ListOptions) ([]godo.Size, *godo.Response, error) {
+		return d.client.Sizes.List(ctx, opt)
+	})
+}
+
+func (d *DigitalOcean) ListFirewalls(ctx context.Context) ([]godo.Firewall, error) {
+	return listAll("firewalls", func(opt *godo.ListOptions) ([]godo.Firewall, *godo.Response, error) {
+		return d.client.Firewalls.List(ctx, opt)
+	})
+}
+
+// findFirewall returns the firewall named name, nil if there is none.
+func (d *DigitalOcean) findFirewall(ctx context.Context, name string) (*godo.Firewall, error) {
+	firewalls, err := d.ListFirewalls(ctx)
 	if err != nil {
-		log.Error("error occured when listing droplets", "error", err.Error())
-		return droplets
+		return nil, err
+	}
+	for _, firewall := range firewalls {
+		if firewall.Name == name {
+			return &firewall, nil
+		}
 	}
-	return droplets
+	return nil, nil
 }
 
-func (d *DigitalOcean) DestroyDropletByName(name string) error {
-	droplets := d.ListDroplets()
+// CreateFirewall creates the firewall of the droplets of the tag, or updates
+// its rules if it already exists. Outbound traffic is allowed.
+func (d *DigitalOcean) CreateFirewall(ctx context.Context, name, tag string, inboundRules []godo.InboundRule) (*godo.Firewall, error) {
+	everyone := &godo.Destinations{Addresses: []string{"0.0.0.0/0", "::/0"}}
+	request := &godo.FirewallRequest{
+		Name:         name,
+		InboundRules: inboundRules,
+		OutboundRules: []godo.OutboundRule{
+			{Protocol: "tcp", PortRange: "all", Destinations: everyone},
+			{Protocol: "udp", PortRange: "all", Destinations: everyone},
+			{Protocol: "icmp", Destinations: everyone},
+		},
+		Tags: []string{tag},
+	}
+	existing, err := d.findFirewall(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	var firewall *godo.Firewall
+	if existing != nil {
+		log.Info("updating firewall", "name", name, "tag", tag)
+		firewall, _, err = d.client.Firewalls.Update(ctx, existing.ID, request)
+	} else {
+		log.Info("creating firewall", "name", name, "tag", tag)
+		firewall, _, err = d.client.Firewalls.Create(ctx, request)
+	}
+	if err != nil {
+		log.Error("error occured while creating firewall", "name", name, "error", err.Error())
+		return nil, err
+	}
+	return firewall, nil
+}
+
+func (d *DigitalOcean) DestroyFirewall(ctx context.Context, name string) error {
+	firewall, err := d.findFirewall(ctx, name)
+	if err != nil || firewall == nil {
+		return err
+	}
+	log.Info("destroying firewall", "name", name)
+	if _, err := d.client.Firewalls.Delete(ctx, firewall.ID); err != nil {
+		log.Error("error occured when deleting firewall", "name", name, "error", err.Error())
+		return err
+	}
+	return nil
+}
+
+// CreateVolume creates a formatted volume. Volumes tagged like the droplets
+// are destroyed along with them.
+func (d *DigitalOcean) CreateVolume(ctx context.Context, name, region string, sizeGB int, filesystem string, tags []string) (*godo.Volume, error) {
+	log.Info("creating volume", "name", name, "region", region, "size_gb", sizeGB)
+	volume, _, err := d.client.Storage.CreateVolume(ctx, &godo.VolumeCreateRequest{
+		Name:           name,
+		Region:         region,
+		SizeGigaBytes:  int64(sizeGB),
+		FilesystemType: filesystem,
+		Tags:           tags,
+	})
+	if err != nil {
+		log.Error("error occured while creating volume", "name", name, "error", err.Error())
+		return nil, err
+	}
+	return volume, nil
+}
+
+func (d *DigitalOcean) ListVolumes(ctx context.Context) ([]godo.Volume, error) {
+	return listAll("volumes", func(opt *godo.ListOptions) ([]godo.Volume, *godo.Response, error) {
+		return d.client.Storage.ListVolumes(ctx, &godo.ListVolumeParams{ListOptions: opt})
+	})
+}
+
+// DeleteVolume deletes the volume, waiting for it to be detached from a
+// droplet being destroyed.
+func (d *DigitalOcean) DeleteVolume(ctx context.Context, id string) error {
+	for numTries := 0; ; numTries++ {
+		_, err := d.client.Storage.DeleteVolume(ctx, id)
+		if err == nil {
+			log.Info("volume destroyed", "volume_id", id)
+			return nil
+		}
+		log.Debug("waiting for the volume to be detached", "volume_id", id, "num_tries", numTries, "error", err.Error())
+		if numTries >= 60 {
+			log.Error("error occured when deleting volume", "volume_id", id, "error", err.Error())
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("volume %s is not deleted: %w", id, ctx.Err())
+		case <-time.After(d.watcher.interval):
+		}
+	}
+}
+
+// destroyVolumes deletes the volumes matching match.
+func (d *DigitalOcean) destroyVolumes(ctx context.Context, volumes []godo.Volume, match func(godo.Volume) bool) error {
+	errs := []error{}
+	for _, volume := range volumes {
+		if match(volume) {
+			errs = append(errs, d.DeleteVolume(ctx, volume.ID))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (d *DigitalOcean) DestroyDropletByName(ctx context.Context, name string) error {
+	droplets, err := d.ListDroplets(ctx)
+	if err != nil {
+		return err
+	}
+	var volumes []godo.Volume
 	for _, droplet := range droplets {
 		if droplet.Name == name {
+			if err := d.releaseReservedIPs(ctx, func(d godo.Droplet) bool { return d.ID == droplet.ID }); err != nil {
+				log.Warn("failed to release reserved ips", "droplet_id", droplet.ID, "error", err.Error())
+			}
+			if volumes == nil && len(droplet.VolumeIDs) > 0 {
+				if volumes, err = d.ListVolumes(ctx); err != nil {
+					return err
+				}
+			}
 			ip, _ := droplet.PublicIPv4()
 			log.Info("destroying droplet", "ip", ip)
-			_, err := d.client.Droplets.Delete(context.Background(), droplet.ID)
+			_, err := d.client.Droplets.Delete(ctx, droplet.ID)
 			if err != nil {
 				log.Error("error occured when deleting droplet", "error", err.Error())
 				return err
 			}
 			log.Info("droplet destroyed", "ip", ip)
+			// Destroy the volumes of the droplet which are not kept
+			err = d.destroyVolumes(ctx, volumes, func(volume godo.Volume) bool {
+				for _, tag := range droplet.Tags {
+					if slices.Contains(volume.Tags, tag) && slices.Contains(droplet.VolumeIDs, volume.ID) {
+						return true
+					}
+				}
+				return false
+			})
+			if err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
-func (d *DigitalOcean) DestroyDropletByTag(tag string) error {
-	droplets := d.ListDroplets()
-	for _, droplet := range droplets {
-		for _, t := range droplet.Tags {
-			if t == tag {
-				ip, _ := droplet.PublicIPv4()
-				log.Info("destroying droplet", "ip", ip)
-				_, err := d.client.Droplets.Delete(context.Background(), droplet.ID)
-				if err != nil {
-					log.Error("error occured when deleting droplet", "error", err.Error())
-					return err
-				}
-				log.Info("droplet destroyed", "ip", ip)
-			}
+func (d *DigitalOcean) DestroyDropletByTag(ctx context.Context, tag string) error {
+	log.Info("destroying droplets", "tag", tag)
+	err := d.releaseReservedIPs(ctx, func(d godo.Droplet) bool { return slices.Contains(d.Tags, tag) })
+	if err != nil {
+		log.Warn("failed to release reserved ips", "tag", tag, "error", err.Error())
+	}
+	_, err = d.client.Droplets.DeleteByTag(ctx, tag)
+	if err != nil {
+		log.Error("error occured when deleting droplets", "tag", tag, "error", err.Error())
+		return err
+	}
+	log.Info("droplets destroyed", "tag", tag)
+	volumes, err := d.ListVolumes(ctx)
+	if err != nil {
+		return err
+	}
+	return d.destroyVolumes(ctx, volumes, func(volume godo.Volume) bool {
+		return slices.Contains(volume.Tags, tag)
+	})
+}
+
+func (d *DigitalOcean) ListReservedIPs(ctx context.Context) ([]godo.ReservedIP, error) {
+	return listAll("reserved ips", func(opt *godo.ListOptions) ([]godo.ReservedIP, *godo.Response, error) {
+		return d.client.ReservedIPs.List(ctx, opt)
+	})
+}
+
+// AssignReservedIP assigns the reserved IP to the droplet, returning once it
+// is assigned.
+func (d *DigitalOcean) AssignReservedIP(ctx context.Context, ip string, dropletID int) error {
+	log.Info("assigning reserved ip", "ip", ip, "droplet_id", dropletID)
+	action, _, err := d.client.ReservedIPActions.Assign(ctx, ip, dropletID)
+	if err != nil {
+		log.Error("error occured when assigning reserved ip", "ip", ip, "droplet_id", dropletID, "error", err.Error())
+		return err
+	}
+	return d.waitReservedIPAction(ctx, ip, action.ID)
+}
+
+// UnassignReservedIP unassigns the reserved IP, which stays in the account.
+func (d *DigitalOcean) UnassignReservedIP(ctx context.Context, ip string) error {
+	log.Info("unassigning reserved ip", "ip", ip)
+	action, _, err := d.client.ReservedIPActions.Unassign(ctx, ip)
+	if err != nil {
+		log.Error("error occured when unassigning reserved ip", "ip", ip, "error", err.Error())
+		return err
+	}
+	return d.waitReservedIPAction(ctx, ip, action.ID)
+}
+
+// releaseReservedIPs unassigns the reserved IPs of the droplets matching
+// match. DigitalOcean unassigns them when the droplets are destroyed anyway,
+// releasing them first makes them free for new droplets at once.
+func (d *DigitalOcean) releaseReservedIPs(ctx context.Context, match func(godo.Droplet) bool) error {
+	reservedIPs, err := d.ListReservedIPs(ctx)
+	if err != nil {
+		return err
+	}
+	errs := []error{}
+	for _, reservedIP := range reservedIPs {
+		if reservedIP.Droplet != nil && match(*reservedIP.Droplet) {
+			errs = append(errs, d.UnassignReservedIP(ctx, reservedIP.IP))
 		}
 	}
-	return nil
+	return errors.Join(errs...)
+}
+
+// SnapshotDroplet powers the droplet off and snapshots it, returning the
+// snapshot once it is available.
+func (d *DigitalOcean) SnapshotDroplet(ctx context.Context, id int, name string) (*godo.Image, error) {
+	log.Info("powering droplet off", "droplet_id", id)
+	action, _, err := d.client.DropletActions.PowerOff(ctx, id)
+	if err != nil {
+		log.Error("error occured when powering droplet off", "droplet_id", id, "error", err.Error())
+		return nil, err
+	}
+	if err := d.waitDropletAction(ctx, id, action.ID); err != nil {
+		return nil, err
+	}
+	log.Info("snapshotting droplet", "droplet_id", id, "name", name)
+	action, _, err = d.client.DropletActions.Snapshot(ctx, id, name)
+	if err != nil {
+		log.Error("error occured when snapshotting droplet", "droplet_id", id, "error", err.Error())
+		return nil, err
+	}
+	if err := d.waitDropletAction(ctx, id, action.ID); err != nil {
+		return nil, err
+	}
+	image, err := d.FindSnapshot(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if image == nil {
+		return nil, fmt.Errorf("snapshot %s of droplet %d not found", name, id)
+	}
+	return image, nil
+}
+
+// TransferImage copies the image to the region, returning once it is available there.
+func (d *DigitalOcean) TransferImage(ctx context.Context, id int, region string) error {
+	log.Info("transferring image", "image_id", id, "region", region)
+	action, _, err := d.client.ImageActions.Transfer(ctx, id, &godo.ActionRequest{"type": "transfer", "region": region})
+	if err != nil {
+		log.Error("error occured when transferring image", "image_id", id, "region", region, "error", err.Error())
+		return err
+	}
+	return d.waitAction(ctx, fmt.Sprintf("image %d", id), func() (*godo.Action, *godo.Response, error) {
+		return d.client.ImageActions.Get(ctx, id, action.ID)
+	})
+}
+
+func (d *DigitalOcean) waitReservedIPAction(ctx context.Context, ip string, actionID int) error {
+	return d.waitAction(ctx, "reserved ip "+ip, func() (*godo.Action, *godo.Response, error) {
+		return d.client.ReservedIPActions.Get(ctx, ip, actionID)
+	})
+}
+
+func (d *DigitalOcean) waitDropletAction(ctx context.Context, dropletID, actionID int) error {
+	return d.waitAction(ctx, fmt.Sprintf("droplet %d", dropletID), func() (*godo.Action, *godo.Response, error) {
+		return d.client.DropletActions.Get(ctx, dropletID, actionID)
+	})
+}
+
+// waitAction polls the action until it is completed or ctx is done.
+func (d *DigitalOcean) waitAction(ctx context.Context, what string, get func() (*godo.Action, *godo.Response, error)) error {
+	for numTries := 0; ; numTries++ {
+		action, _, err := get()
+		switch {
+		case err != nil:
+			log.Error("error occured while getting action", "of", what, "error", err.Error())
+		case action.Status == godo.ActionCompleted:
+			return nil
+		case action.Status == "errored":
+			return fmt.Errorf("%s action %s errored", what, action.Type)
+		default:
+			log.Debug("waiting", "of", what, "action", action.Type, "status", action.Status, "num_tries", numTries)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s action is not completed: %w", what, ctx.Err())
+		case <-time.After(d.watcher.interval):
+		}
+	}
+}
+
+// FindSnapshot returns the snapshot named name, nil if there is none.
+func (d *DigitalOcean) FindSnapshot(ctx context.Context, name string) (*godo.Image, error) {
+	images, err := listAll("images", func(opt *godo.ListOptions) ([]godo.Image, *godo.Response, error) {
+		return d.client.Images.ListUser(ctx, opt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, image := range images {
+		if image.Name == name && image.Type == "snapshot" {
+			return &image, nil
+		}
+	}
+	return nil, nil
 }
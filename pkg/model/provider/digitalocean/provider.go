@@ -1,90 +1,386 @@
 package digitalocean
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
 	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/api"
 	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/util/cloudinit"
+	"github.com/charmbracelet/log"
+	"github.com/digitalocean/godo"
 )
 
 type Provider struct {
-	do *DigitalOcean
+	do     *DigitalOcean
+	mu     sync.Mutex
+	prices map[string]float64 // size slug -> hourly price
+	// reservedMu serializes the claims of reserved IPs, claimed holds the ones
+	// being assigned. assigned caches the reserved IPs of the droplets by
+	// droplet ID, it is loaded once and then kept up to date by the provider.
+	reservedMu sync.Mutex
+	claimed    map[string]bool
+	assigned   map[int]string
 }
 
 func NewProvider(token string) *Provider {
 	return &Provider{
-		do: newDigitalOcean(token),
+		do:      newDigitalOcean(token),
+		claimed: make(map[string]bool),
 	}
 }
 
-func (p *Provider) ListServers() []server.Server {
+// WithEndpoint overrides the API base URL, e.g. to talk to a local stand-in.
+func (p *Provider) WithEndpoint(endpoint string) *Provider {
+	if err := godo.SetBaseURL(endpoint)(p.do.client); err != nil {
+		log.Error("invalid endpoint", "endpoint", endpoint, "error", err.Error())
+	}
+	return p
+}
+
+// WithPollInterval sets the delay between two polls of droplets being created.
+func (p *Provider) WithPollInterval(pollInterval time.Duration) *Provider {
+	p.do.watcher.interval = pollInterval
+	return p
+}
+
+// toServers returns the droplets along with the reserved IPs assigned to
+// them.
+func (p *Provider) toServers(ctx context.Context, droplets []godo.Droplet, err error) ([]server.Server, error) {
+	if err != nil {
+		return nil, err
+	}
+	reservedIPs := map[int]string{}
+	if len(droplets) > 0 {
+		reservedIPs, err = p.assignedReservedIPs(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
 	servers := []server.Server{}
-	remoteServers := p.do.ListDroplets()
-	for _, remoteServer := range remoteServers {
-		servers = append(servers, NewServer(remoteServer))
+	for _, droplet := range droplets {
+		servers = append(servers, NewServer(droplet).WithReservedIP(reservedIPs[droplet.ID]))
 	}
-	return servers
+	return servers, nil
 }
 
-func (p *Provider) ListServersByName(name string) []server.Server {
+// assignedReservedIPs returns the reserved IPs by droplet ID. They are listed
+// once, rather than every time the droplets are polled.
+func (p *Provider) assignedReservedIPs(ctx context.Context) (map[int]string, error) {
+	p.reservedMu.Lock()
+	defer p.reservedMu.Unlock()
+	if p.assigned == nil {
+		reservedIPs, err := p.do.ListReservedIPs(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list reserved ips: %w", err)
+		}
+		p.cacheReservedIPs(reservedIPs)
+	}
+	return maps.Clone(p.assigned), nil
+}
+
+// cacheReservedIPs replaces the cached assignments with the listed ones.
+func (p *Provider) cacheReservedIPs(reservedIPs []godo.ReservedIP) {
+	p.assigned = map[int]string{}
+	for _, reservedIP := range reservedIPs {
+		if reservedIP.Droplet != nil {
+			p.assigned[reservedIP.Droplet.ID] = reservedIP.IP
+		}
+	}
+}
+
+func (p *Provider) ListServers(ctx context.Context) ([]server.Server, error) {
+	droplets, err := p.do.ListDroplets(ctx)
+	return p.toServers(ctx, droplets, err)
+}
+
+func (p *Provider) ListServersByName(ctx context.Context, name string) ([]server.Server, error) {
+	remoteServers, err := p.ListServers(ctx)
+	if err != nil {
+		return nil, err
+	}
 	servers := []server.Server{}
-	remoteServers := p.ListServers()
 	for _, remoteServer := range remoteServers {
 		if remoteServer.Name() == name {
 			servers = append(servers, remoteServer)
 		}
 	}
-	return servers
+	return servers, nil
 }
 
-func (p *Provider) ListServersByTag(tag string) []server.Server {
-	servers := []server.Server{}
-	remoteServers := p.ListServers()
-	for _, remoteServer := range remoteServers {
-		for _, remoteServerTag := range remoteServer.Tags() {
-			if remoteServerTag == tag {
-				servers = append(servers, remoteServer)
-			}
+func (p *Provider) ListServersByTag(ctx context.Context, tag string) ([]server.Server, error) {
+	droplets, err := p.do.ListDropletsByTag(ctx, tag)
+	return p.toServers(ctx, droplets, err)
+}
+
+// HourlyPrice returns the hourly price of a droplet size from the sizes API,
+// which is fetched once. Sizes cost the same in every region.
+func (p *Provider) HourlyPrice(ctx context.Context, region, size string) (float64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.prices == nil {
+		sizes, err := p.do.ListSizes(ctx)
+		if err != nil {
+			return 0, err
+		}
+		p.prices = make(map[string]float64)
+		for _, s := range sizes {
+			p.prices[s.Slug] = s.PriceHourly
+		}
+	}
+	price, ok := p.prices[size]
+	if !ok {
+		return 0, fmt.Errorf("unknown droplet size %s", size)
+	}
+	return price, nil
+}
+
+// firewallName returns the name of the firewall of the tag.
+func firewallName(tag string) string {
+	return "dode-" + tag
+}
+
+// CreateFirewall creates a cloud firewall applied to the droplets of the tag.
+func (p *Provider) CreateFirewall(ctx context.Context, tag string, fo *api.FirewallOptions) error {
+	inboundRules := []godo.InboundRule{}
+	for _, rule := range fo.Rules() {
+		if len(rule.Sources) == 0 {
+			return fmt.Errorf("no source allowed on %s/%s", rule.Protocol, rule.Ports)
 		}
+		inboundRules = append(inboundRules, godo.InboundRule{
+			Protocol:  rule.Protocol,
+			PortRange: rule.Ports,
+			Sources:   &godo.Sources{Addresses: rule.Sources},
+		})
 	}
-	return servers
+	_, err := p.do.CreateFirewall(ctx, firewallName(tag), tag, inboundRules)
+	return err
+}
+
+func (p *Provider) DestroyFirewall(ctx context.Context, tag string) error {
+	return p.do.DestroyFirewall(ctx, firewallName(tag))
 }
 
-func (p *Provider) CreateKeyPair(name string, pubkey string) error {
-	_, err := p.do.CreateSSHKeyPair(name, pubkey)
+func (p *Provider) CreateKeyPair(ctx context.Context, name string, pubkey string) error {
+	_, err := p.do.CreateSSHKeyPair(ctx, name, pubkey)
 	return err
 }
 
-func (p *Provider) CreateServer(cso *api.CreateServerOptions) (server.Server, error) {
+func (p *Provider) DeleteKeyPair(ctx context.Context, name string, pubkey string) error {
+	return p.do.DeleteSSHKey(ctx, pubkey)
+}
+
+func (p *Provider) CreateServer(ctx context.Context, cso *api.CreateServerOptions) (server.Server, error) {
 	pubkey, err := os.ReadFile(cso.PublicKeyPath)
 	if err != nil {
 		return nil, err
 	}
+	volumeNames, err := cso.VolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	parts := cso.MountParts(func(vo api.VolumeOptions) string {
+		return "/dev/disk/by-id/scsi-0DO_Volume_" + volumeNames[vo.Name]
+	})
+	if len(cso.ReservedIPs) > 0 {
+		parts = append(parts, cloudinit.NewPart("01-route-reserved-ip.sh", reservedIPRouteScript))
+	}
+	userData, err := cso.BuildUserData(parts...)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := cso.CreateContext(ctx)
+	defer cancel()
 
-	err = p.CreateKeyPair(cso.Name, string(pubkey))
+	key, err := p.do.CreateSSHKeyPair(ctx, cso.Name, string(pubkey))
 	if err != nil {
 		return nil, err
 	}
 
+	// Fall back to the next region or size when out of capacity
+	errs := []error{}
+	for _, placement := range cso.Placements() {
+		reservedIP := ""
+		if len(cso.ReservedIPs) > 0 {
+			reservedIP, err = p.claimReservedIP(ctx, cso.ReservedIPs, placement.Region)
+			if err != nil {
+				return nil, err
+			}
+			if reservedIP == "" {
+				log.Warn("no free reserved ip, trying the next placement", "region", placement.Region)
+				errs = append(errs, fmt.Errorf("%s/%s: no free reserved ip in %s", placement.Region, placement.Size, placement.Region))
+				continue
+			}
+		}
+		droplet, err := p.createDroplet(ctx, cso, placement, key.ID, userData, volumeNames)
+		if err == nil && reservedIP != "" {
+			err = p.assignReservedIP(ctx, reservedIP, droplet)
+		}
+		p.unclaimReservedIP(reservedIP)
+		if err == nil {
+			server := NewServer(*droplet).WithReservedIP(reservedIP)
+			log.Info("droplet placed", "name", cso.Name, "region", server.Region(), "size", server.Size(), "reserved_ip", reservedIP)
+			return server, nil
+		}
+		if !IsCapacityError(err) {
+			return nil, err
+		}
+		log.Warn("no capacity, trying the next placement", "region", placement.Region, "size", placement.Size, "error", err.Error())
+		errs = append(errs, fmt.Errorf("%s/%s: %w", placement.Region, placement.Size, err))
+	}
+	return nil, fmt.Errorf("%w: %w", api.ErrCapacity, errors.Join(errs...))
+}
+
+// createDroplet creates the volumes and the droplet they are attached to,
+// the volumes are destroyed if the droplet can not be created.
+func (p *Provider) createDroplet(ctx context.Context, cso *api.CreateServerOptions, placement api.Placement, keyID int, userData string, volumeNames map[string]string) (*godo.Droplet, error) {
+	volumeIDs := []string{}
+	cleanup := func() {
+		cleanupCtx, cancel := api.CleanupContext(ctx)
+		defer cancel()
+		for _, id := range volumeIDs {
+			if err := p.do.DeleteVolume(cleanupCtx, id); err != nil {
+				log.Error("error occured when deleting volume", "volume_id", id, "error", err.Error())
+			}
+		}
+	}
+	for _, vo := range cso.Volumes {
+		tags := []string{cso.Tag}
+		if vo.Keep {
+			tags = nil
+		}
+		volume, err := p.do.CreateVolume(ctx, volumeNames[vo.Name], placement.Region, vo.SizeGB, vo.Filesystem, tags)
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+		volumeIDs = append(volumeIDs, volume.ID)
+	}
 	droplet, err := p.do.CreateDroplet(
+		ctx,
 		cso.Name,
-		cso.Region,
-		cso.Size,
+		placement.Region,
+		placement.Size,
 		cso.Image,
-		string(pubkey),
+		keyID,
 		cso.Tag,
+		userData,
+		volumeIDs,
 	)
 	if err != nil {
+		cleanup()
 		return nil, err
 	}
-	server := NewServer(*droplet)
-	return server, nil
+	return droplet, nil
 }
 
-func (p *Provider) DestroyServerByName(name string) error {
-	return p.do.DestroyDropletByName(name)
+// reservedIPRouteScript waits for the reserved IP to be assigned, then routes
+// the outbound traffic through the anchor IP of the droplet so that it comes
+// from the reserved IP. The later provisioning scripts wait for it.
+const reservedIPRouteScript = `#!/bin/sh
+metadata=http://169.254.169.254/metadata/v1
+for i in $(seq 1 120); do
+	if [ "$(curl -sf $metadata/reserved_ip/ipv4/active)" = "true" ]; then
+		gateway=$(curl -sf $metadata/interfaces/public/0/anchor_ipv4/gateway)
+		ip route replace default via "$gateway" dev eth0
+		exit 0
+	fi
+	sleep 5
+done
+echo "reserved ip not assigned" >&2
+exit 1
+`
+
+// claimReservedIP returns a reserved IP of the pool in the region which is
+// neither assigned nor being assigned to another droplet, empty if there is
+// none. It has to be unclaimed once assigned.
+func (p *Provider) claimReservedIP(ctx context.Context, pool []string, region string) (string, error) {
+	p.reservedMu.Lock()
+	defer p.reservedMu.Unlock()
+	reservedIPs, err := p.do.ListReservedIPs(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to list reserved ips: %w", err)
+	}
+	p.cacheReservedIPs(reservedIPs)
+	for _, reservedIP := range reservedIPs {
+		if !slices.Contains(pool, reservedIP.IP) || reservedIP.Droplet != nil || p.claimed[reservedIP.IP] {
+			continue
+		}
+		if reservedIP.Region == nil || reservedIP.Region.Slug != region {
+			continue
+		}
+		p.claimed[reservedIP.IP] = true
+		return reservedIP.IP, nil
+	}
+	return "", nil
+}
+
+func (p *Provider) unclaimReservedIP(ip string) {
+	p.reservedMu.Lock()
+	defer p.reservedMu.Unlock()
+	delete(p.claimed, ip)
+}
+
+// assignReservedIP assigns the reserved IP to the droplet, which is destroyed
+// if it fails so that no droplet egresses from an unexpected address.
+func (p *Provider) assignReservedIP(ctx context.Context, ip string, droplet *godo.Droplet) error {
+	err := p.do.AssignReservedIP(ctx, ip, droplet.ID)
+	if err == nil {
+		p.reservedMu.Lock()
+		defer p.reservedMu.Unlock()
+		if p.assigned != nil {
+			p.assigned[droplet.ID] = ip
+		}
+		return nil
+	}
+	cleanupCtx, cancel := api.CleanupContext(ctx)
+	defer cancel()
+	if _, deleteErr := p.do.client.Droplets.Delete(cleanupCtx, droplet.ID); deleteErr != nil {
+		log.Error("error occured when deleting droplet", "droplet_id", droplet.ID, "error", deleteErr.Error())
+	}
+	return fmt.Errorf("failed to assign reserved ip %s: %w", ip, err)
+}
+
+func (p *Provider) DestroyServerByName(ctx context.Context, name string) error {
+	return p.do.DestroyDropletByName(ctx, name)
+}
+
+func (p *Provider) DestroyServerByTag(ctx context.Context, tag string) error {
+	return p.do.DestroyDropletByTag(ctx, tag)
 }
 
-func (p *Provider) DestroyServerByTag(tag string) error {
-	return p.do.DestroyDropletByTag(tag)
+// SnapshotServer snapshots the droplet and transfers the snapshot to the
+// other regions, as droplets can only be created from a snapshot in the
+// regions it is available in.
+func (p *Provider) SnapshotServer(ctx context.Context, s server.Server, name string, regions []string) (string, error) {
+	droplet := s.(*Server).droplet
+	image, err := p.do.SnapshotDroplet(ctx, droplet.ID, name)
+	if err != nil {
+		return "", err
+	}
+	for _, region := range regions {
+		if slices.Contains(image.Regions, region) {
+			continue
+		}
+		if err := p.do.TransferImage(ctx, image.ID, region); err != nil {
+			return "", err
+		}
+	}
+	return strconv.Itoa(image.ID), nil
+}
+
+func (p *Provider) FindImage(ctx context.Context, name string) (string, error) {
+	image, err := p.do.FindSnapshot(ctx, name)
+	if err != nil || image == nil {
+		return "", err
+	}
+	return strconv.Itoa(image.ID), nil
 }
@@ -0,0 +1,858 @@
+package digitalocean_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/dodetest"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/api"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/digitalocean"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+	"github.com/digitalocean/godo"
+)
+
+// fakeDigitalOcean serves the droplet endpoints used by the provider
+type fakeDigitalOcean struct {
+	mu       sync.Mutex
+	droplets []godo.Droplet
+	deleted  []string
+	token    string
+	userData string
+	// sizeRequests counts the calls to the sizes API
+	sizeRequests int
+	// unavailable lists the "region/size" out of capacity
+	unavailable map[string]bool
+	// active makes new droplets active at once instead of never leaving provisioning
+	active bool
+	// activateAfter makes new droplets active once listed this many times
+	activateAfter int
+	listed        map[int]int
+	// lists and gets count the calls listing droplets and getting one droplet
+	lists, gets int
+	firewalls   []godo.Firewall
+	volumes     []godo.Volume
+	// deletedVolumes lists the names of the deleted volumes
+	deletedVolumes []string
+	images         []godo.Image
+	// actions lists the droplet and image actions, e.g. "power_off" or "transfer:sfo3"
+	actions []string
+	keys    []godo.Key
+	// keyIDs lists the keys authorized on the created droplets
+	keyIDs      []int
+	reservedIPs []godo.ReservedIP
+	// reservedIPLists counts the calls listing reserved IPs, which fail with
+	// reservedIPsForbidden
+	reservedIPLists      int
+	reservedIPsForbidden bool
+}
+
+func newFakeDigitalOcean(n int) *fakeDigitalOcean {
+	f := &fakeDigitalOcean{token: "token", listed: map[int]int{}}
+	for i := 0; i < n; i++ {
+		tag := "zmap"
+		if i%3 == 0 {
+			tag = "http"
+		}
+		f.droplets = append(f.droplets, godo.Droplet{
+			ID:     i + 1,
+			Name:   fmt.Sprintf("%s-%d", tag, i),
+			Status: "active",
+			Tags:   []string{tag},
+		})
+	}
+	return f
+}
+
+func (f *fakeDigitalOcean) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer "+f.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"id": "unauthorized", "message": "Unable to authenticate you"})
+		return
+	}
+	q := r.URL.Query()
+	tag := q.Get("tag_name")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v2/droplets":
+		f.lists++
+		droplets := []godo.Droplet{}
+		for i, d := range f.droplets {
+			if tag == "" || d.Tags[0] == tag {
+				if f.activateAfter > 0 && d.Status == "new" {
+					f.listed[d.ID]++
+					if f.listed[d.ID] >= f.activateAfter {
+						f.droplets[i].Status = "active"
+						d.Status = "active"
+					}
+				}
+				droplets = append(droplets, d)
+			}
+		}
+		page, _ := strconv.Atoi(q.Get("page"))
+		if page == 0 {
+			page = 1
+		}
+		perPage, _ := strconv.Atoi(q.Get("per_page"))
+		start, end := (page-1)*perPage, page*perPage
+		if end > len(droplets) {
+			end = len(droplets)
+		}
+		pages := &godo.Pages{}
+		if page > 1 {
+			pages.Prev = fmt.Sprintf("http://%s/v2/droplets?page=%d&per_page=%d", r.Host, page-1, perPage)
+		}
+		if end < len(droplets) {
+			pages.Next = fmt.Sprintf("http://%s/v2/droplets?page=%d&per_page=%d", r.Host, page+1, perPage)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"droplets": droplets[start:end],
+			"links":    map[string]interface{}{"pages": pages},
+			"meta":     map[string]int{"total": len(droplets)},
+		})
+	case r.Method == http.MethodGet && r.URL.Path == "/v2/firewalls":
+		json.NewEncoder(w).Encode(map[string]interface{}{"firewalls": f.firewalls, "links": map[string]interface{}{}})
+	case (r.Method == http.MethodPost && r.URL.Path == "/v2/firewalls") || (r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/v2/firewalls/")):
+		var req godo.FirewallRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		firewall := godo.Firewall{
+			ID:            strings.TrimPrefix(r.URL.Path, "/v2/firewalls/"),
+			Name:          req.Name,
+			InboundRules:  req.InboundRules,
+			OutboundRules: req.OutboundRules,
+			Tags:          req.Tags,
+		}
+		if r.Method == http.MethodPost {
+			firewall.ID = fmt.Sprintf("fw-%d", len(f.firewalls)+1)
+			f.firewalls = append(f.firewalls, firewall)
+		}
+		for i := range f.firewalls {
+			if f.firewalls[i].ID == firewall.ID {
+				f.firewalls[i] = firewall
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]godo.Firewall{"firewall": firewall})
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/v2/firewalls/"):
+		remaining := []godo.Firewall{}
+		for _, firewall := range f.firewalls {
+			if firewall.ID != strings.TrimPrefix(r.URL.Path, "/v2/firewalls/") {
+				remaining = append(remaining, firewall)
+			}
+		}
+		f.firewalls = remaining
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && r.URL.Path == "/v2/volumes":
+		json.NewEncoder(w).Encode(map[string]interface{}{"volumes": f.volumes, "links": map[string]interface{}{}})
+	case r.Method == http.MethodPost && r.URL.Path == "/v2/volumes":
+		var req godo.VolumeCreateRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		volume := godo.Volume{
+			ID:             fmt.Sprintf("vol-%d", len(f.volumes)+len(f.deletedVolumes)+1),
+			Name:           req.Name,
+			Region:         &godo.Region{Slug: req.Region},
+			SizeGigaBytes:  req.SizeGigaBytes,
+			FilesystemType: req.FilesystemType,
+			Tags:           req.Tags,
+		}
+		f.volumes = append(f.volumes, volume)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]godo.Volume{"volume": volume})
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/v2/volumes/"):
+		id := strings.TrimPrefix(r.URL.Path, "/v2/volumes/")
+		for _, d := range f.droplets {
+			if slices.Contains(d.VolumeIDs, id) {
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(map[string]string{"id": "conflict", "message": "Volume is attached"})
+				return
+			}
+		}
+		remaining := []godo.Volume{}
+		for _, volume := range f.volumes {
+			if volume.ID == id {
+				f.deletedVolumes = append(f.deletedVolumes, volume.Name)
+			} else {
+				remaining = append(remaining, volume)
+			}
+		}
+		f.volumes = remaining
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && r.URL.Path == "/v2/images":
+		json.NewEncoder(w).Encode(map[string]interface{}{"images": f.images, "links": map[string]interface{}{}})
+	case r.Method == http.MethodGet && r.URL.Path == "/v2/reserved_ips":
+		f.reservedIPLists++
+		if f.reservedIPsForbidden {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"id": "forbidden", "message": "missing scope"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"reserved_ips": f.reservedIPs, "links": map[string]interface{}{}})
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/v2/reserved_ips/"):
+		var req godo.ActionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		ip := strings.Split(r.URL.Path, "/")[3]
+		action := fmt.Sprint(req["type"])
+		for i := range f.reservedIPs {
+			if f.reservedIPs[i].IP != ip {
+				continue
+			}
+			f.reservedIPs[i].Droplet = nil
+			for _, d := range f.droplets {
+				if action == "assign" && float64(d.ID) == req["droplet_id"] {
+					f.reservedIPs[i].Droplet = &d
+				}
+			}
+		}
+		f.actions = append(f.actions, action+":"+ip)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]godo.Action{"action": {ID: len(f.actions), Type: action, Status: "in-progress"}})
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/actions"):
+		var req godo.ActionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		action := fmt.Sprint(req["type"])
+		id, _ := strconv.Atoi(strings.Split(r.URL.Path, "/")[3])
+		switch action {
+		case "snapshot":
+			for _, d := range f.droplets {
+				if d.ID == id {
+					f.images = append(f.images, godo.Image{ID: 5000 + len(f.images), Name: fmt.Sprint(req["name"]), Type: "snapshot", Regions: []string{d.Region.Slug}})
+				}
+			}
+		case "transfer":
+			action += ":" + fmt.Sprint(req["region"])
+			for i := range f.images {
+				if f.images[i].ID == id {
+					f.images[i].Regions = append(f.images[i].Regions, fmt.Sprint(req["region"]))
+				}
+			}
+		}
+		f.actions = append(f.actions, action)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]godo.Action{"action": {ID: len(f.actions), Type: action, Status: "in-progress"}})
+	case r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/actions/"):
+		id, _ := strconv.Atoi(r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+		json.NewEncoder(w).Encode(map[string]godo.Action{"action": {ID: id, Status: godo.ActionCompleted}})
+	case r.Method == http.MethodGet && r.URL.Path == "/v2/sizes":
+		f.sizeRequests++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"sizes": []godo.Size{
+				{Slug: "s-1vcpu-1gb", PriceHourly: 0.00893},
+				{Slug: "s-2vcpu-4gb", PriceHourly: 0.03571},
+			},
+			"links": map[string]interface{}{},
+		})
+	case r.Method == http.MethodGet && r.URL.Path == "/v2/account/keys":
+		json.NewEncoder(w).Encode(map[string]interface{}{"ssh_keys": f.keys, "links": map[string]interface{}{}})
+	case r.Method == http.MethodPost && r.URL.Path == "/v2/account/keys":
+		var req godo.KeyCreateRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		key := godo.Key{ID: len(f.keys) + 100, Name: req.Name, PublicKey: req.PublicKey}
+		f.keys = append(f.keys, key)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]godo.Key{"ssh_key": key})
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/v2/account/keys/"):
+		id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/v2/account/keys/"))
+		f.keys = slices.DeleteFunc(f.keys, func(k godo.Key) bool { return k.ID == id })
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && r.URL.Path == "/v2/droplets":
+		var req godo.DropletCreateRequest
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &req)
+		// The image is either a slug or the ID of a snapshot, the keys are IDs
+		var raw struct {
+			Image   json.RawMessage `json:"image"`
+			SSHKeys []int           `json:"ssh_keys"`
+		}
+		json.Unmarshal(body, &raw)
+		if err := json.Unmarshal(raw.Image, &req.Image.ID); err != nil {
+			json.Unmarshal(raw.Image, &req.Image.Slug)
+		}
+		if f.unavailable[req.Region+"/"+req.Size] {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(map[string]string{"id": "unprocessable_entity", "message": "Size is not available in this region."})
+			return
+		}
+		f.userData = req.UserData
+		f.keyIDs = append(f.keyIDs, raw.SSHKeys...)
+		status := "new"
+		if f.active {
+			status = "active"
+		}
+		droplet := godo.Droplet{
+			ID:       len(f.droplets) + 1000,
+			Name:     req.Name,
+			Status:   status,
+			Tags:     req.Tags,
+			Region:   &godo.Region{Slug: req.Region},
+			SizeSlug: req.Size,
+			Image:    &godo.Image{ID: req.Image.ID, Slug: req.Image.Slug},
+		}
+		for _, volume := range req.Volumes {
+			droplet.VolumeIDs = append(droplet.VolumeIDs, volume.ID)
+		}
+		f.droplets = append(f.droplets, droplet)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]godo.Droplet{"droplet": droplet})
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v2/droplets/"):
+		f.gets++
+		id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/v2/droplets/"))
+		for _, d := range f.droplets {
+			if d.ID == id {
+				json.NewEncoder(w).Encode(map[string]godo.Droplet{"droplet": d})
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"id": "not_found", "message": r.URL.Path})
+	case r.Method == http.MethodDelete && r.URL.Path == "/v2/droplets" && tag != "":
+		remaining := []godo.Droplet{}
+		for _, d := range f.droplets {
+			if d.Tags[0] == tag {
+				f.deleted = append(f.deleted, d.Name)
+			} else {
+				remaining = append(remaining, d)
+			}
+		}
+		f.droplets = remaining
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/v2/droplets/"):
+		id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/v2/droplets/"))
+		remaining := []godo.Droplet{}
+		for _, d := range f.droplets {
+			if d.ID == id {
+				f.deleted = append(f.deleted, d.Name)
+			} else {
+				remaining = append(remaining, d)
+			}
+		}
+		f.droplets = remaining
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"id": "not_found", "message": r.URL.Path})
+	}
+}
+
+func TestProviderListsEveryPage(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeDigitalOcean(450)
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	p := digitalocean.NewProvider("token").WithEndpoint(ts.URL)
+
+	testcases := []struct {
+		name     string
+		list     func() int
+		expected int
+	}{
+		{"all", func() int { return len(list(t)(p.ListServers(ctx))) }, 450},
+		{"by tag", func() int { return len(list(t)(p.ListServersByTag(ctx, "zmap"))) }, 300},
+		{"by name", func() int { return len(list(t)(p.ListServersByName(ctx, "zmap-449"))) }, 1},
+	}
+	for _, tc := range testcases {
+		if n := tc.list(); n != tc.expected {
+			t.Errorf("%s: expected %d servers, got %d", tc.name, tc.expected, n)
+		}
+	}
+}
+
+func TestProviderDestroy(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeDigitalOcean(450)
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	p := digitalocean.NewProvider("token").WithEndpoint(ts.URL)
+
+	// The droplet is on the last page
+	if err := p.DestroyServerByName(ctx, "http-447"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(fake.deleted) != 1 || fake.deleted[0] != "http-447" {
+		t.Fatalf("unexpected deleted droplets %v", fake.deleted)
+	}
+	if err := p.DestroyServerByTag(ctx, "zmap"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := len(list(t)(p.ListServers(ctx))); n != 149 {
+		t.Errorf("expected 149 servers left, got %d", n)
+	}
+}
+
+func TestProviderSurfacesErrors(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeDigitalOcean(10)
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	p := digitalocean.NewProvider("invalid").WithEndpoint(ts.URL)
+
+	if _, err := p.ListServersByTag(ctx, "zmap"); err == nil {
+		t.Errorf("expected error when listing fails")
+	}
+	if err := p.DestroyServerByName(ctx, "zmap-1"); err == nil {
+		t.Errorf("expected error when listing fails")
+	}
+	if err := p.DestroyServerByTag(ctx, "zmap"); err == nil {
+		t.Errorf("expected error when deleting fails")
+	}
+	if len(fake.deleted) != 0 {
+		t.Errorf("unexpected deleted droplets %v", fake.deleted)
+	}
+}
+
+func TestProviderDestroysStuckDroplet(t *testing.T) {
+	fake := newFakeDigitalOcean(0)
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	p := digitalocean.NewProvider("token").WithEndpoint(ts.URL)
+	key, err := dodetest.WritePrivateKey(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cso := api.NewCreateServerOptions().
+		WithName("zmap-0").
+		WithTag("zmap").
+		WithPublicKeyPath(key + ".pub").
+		WithUserData("#cloud-config\npackages: [jq]\n").
+		WithCreateTimeout(100 * time.Millisecond)
+
+	start := time.Now()
+	if _, err := p.CreateServer(context.Background(), cso); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("create did not honor the timeout, took %s", elapsed)
+	}
+	if fake.userData != cso.UserData {
+		t.Errorf("unexpected user data %q", fake.userData)
+	}
+	if len(fake.deleted) != 1 || fake.deleted[0] != "zmap-0" {
+		t.Errorf("expected the stuck droplet to be destroyed, got %v", fake.deleted)
+	}
+}
+
+func TestProviderFallsBackOnCapacityErrors(t *testing.T) {
+	key, err := dodetest.WritePrivateKey(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testcases := []struct {
+		name        string
+		unavailable []string
+		region      string
+		size        string
+	}{
+		{"preferred", nil, "nyc1", "s-2vcpu-4gb"},
+		{"next region", []string{"nyc1/s-2vcpu-4gb"}, "sfo3", "s-2vcpu-4gb"},
+		{"next size", []string{"nyc1/s-2vcpu-4gb", "sfo3/s-2vcpu-4gb"}, "nyc1", "s-1vcpu-2gb"},
+		{"none", []string{"nyc1/s-2vcpu-4gb", "sfo3/s-2vcpu-4gb", "nyc1/s-1vcpu-2gb", "sfo3/s-1vcpu-2gb"}, "", ""},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := newFakeDigitalOcean(0)
+			fake.active = true
+			fake.unavailable = map[string]bool{}
+			for _, placement := range tc.unavailable {
+				fake.unavailable[placement] = true
+			}
+			ts := httptest.NewServer(fake)
+			defer ts.Close()
+			p := digitalocean.NewProvider("token").WithEndpoint(ts.URL).WithPollInterval(10 * time.Millisecond)
+			cso := api.NewCreateServerOptions().
+				WithName("zmap-0").
+				WithTag("zmap").
+				WithPublicKeyPath(key+".pub").
+				WithRegions("nyc1", "sfo3").
+				WithSizes("s-2vcpu-4gb", "s-1vcpu-2gb")
+
+			s, err := p.CreateServer(context.Background(), cso)
+			if tc.region == "" {
+				if !errors.Is(err, api.ErrCapacity) {
+					t.Fatalf("expected capacity error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if s.Region() != tc.region || s.Size() != tc.size {
+				t.Errorf("expected %s/%s, got %s/%s", tc.region, tc.size, s.Region(), s.Size())
+			}
+		})
+	}
+}
+
+func TestProviderPollsDropletsTogether(t *testing.T) {
+	key, err := dodetest.WritePrivateKey(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := newFakeDigitalOcean(0)
+	fake.activateAfter = 3
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	p := digitalocean.NewProvider("token").WithEndpoint(ts.URL).WithPollInterval(20 * time.Millisecond)
+
+	const numDroplets = 10
+	wg := sync.WaitGroup{}
+	errs := make(chan error, numDroplets)
+	for i := 0; i < numDroplets; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cso := api.NewCreateServerOptions().
+				WithName(fmt.Sprintf("zmap-%d", i)).
+				WithTag("zmap").
+				WithPublicKeyPath(key + ".pub")
+			_, err := p.CreateServer(context.Background(), cso)
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if fake.gets != 0 {
+		t.Errorf("expected no droplet to be polled alone, got %d calls", fake.gets)
+	}
+	// Each droplet needs 3 polls, shared by the droplets created meanwhile
+	if fake.lists >= numDroplets*fake.activateAfter {
+		t.Errorf("expected the polls to be batched, got %d list calls", fake.lists)
+	}
+}
+
+func TestProviderFirewall(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeDigitalOcean(0)
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	p := digitalocean.NewProvider("token").WithEndpoint(ts.URL)
+
+	fo := api.NewFirewallOptions().WithSSHSources("198.51.100.7/32")
+	if err := p.CreateFirewall(ctx, "zmap", fo); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Creating it again updates the rules
+	if err := p.CreateFirewall(ctx, "zmap", fo.WithInboundRule("tcp", "80")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(fake.firewalls) != 1 {
+		t.Fatalf("expected 1 firewall, got %d", len(fake.firewalls))
+	}
+	firewall := fake.firewalls[0]
+	if len(firewall.Tags) != 1 || firewall.Tags[0] != "zmap" {
+		t.Errorf("expected the firewall to be bound to the tag, got %v", firewall.Tags)
+	}
+	rules := firewall.InboundRules
+	if len(rules) != 2 || rules[0].PortRange != "22" || rules[0].Sources.Addresses[0] != "198.51.100.7/32" {
+		t.Fatalf("expected ssh from the controller only, got %+v", rules)
+	}
+	if rules[1].PortRange != "80" || len(rules[1].Sources.Addresses) != 2 {
+		t.Errorf("expected port 80 open to everyone, got %+v", rules[1])
+	}
+
+	if err := p.CreateFirewall(ctx, "http", api.NewFirewallOptions()); err == nil {
+		t.Errorf("expected error when ssh is allowed from nowhere")
+	}
+	if err := p.DestroyFirewall(ctx, "zmap"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(fake.firewalls) != 0 {
+		t.Errorf("expected the firewall to be destroyed, got %v", fake.firewalls)
+	}
+	if err := p.DestroyFirewall(ctx, "zmap"); err != nil {
+		t.Errorf("expected destroying a missing firewall to succeed, got %v", err)
+	}
+}
+
+func TestProviderVolumes(t *testing.T) {
+	ctx := context.Background()
+	key, err := dodetest.WritePrivateKey(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := newFakeDigitalOcean(0)
+	fake.active = true
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	p := digitalocean.NewProvider("token").WithEndpoint(ts.URL).WithPollInterval(10 * time.Millisecond)
+	cso := api.NewCreateServerOptions().
+		WithName("zmap-0").
+		WithTag("zmap").
+		WithRegion("nyc1").
+		WithPublicKeyPath(key + ".pub").
+		WithVolume(api.NewVolumeOptions("data", 100).WithMountPoint("/mnt/data")).
+		WithVolume(api.NewVolumeOptions("archive", 500).WithFilesystem("xfs").WithKeep(true))
+
+	if _, err := p.CreateServer(ctx, cso); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(fake.volumes) != 2 {
+		t.Fatalf("expected 2 volumes, got %d", len(fake.volumes))
+	}
+	data, archive := fake.volumes[0], fake.volumes[1]
+	if data.Name != "zmap-0-data" || data.Region.Slug != "nyc1" || data.SizeGigaBytes != 100 || data.FilesystemType != "ext4" {
+		t.Errorf("unexpected volume %+v", data)
+	}
+	if len(data.Tags) != 1 || data.Tags[0] != "zmap" {
+		t.Errorf("expected the volume to be tagged like the droplet, got %v", data.Tags)
+	}
+	if len(archive.Tags) != 0 || archive.FilesystemType != "xfs" {
+		t.Errorf("expected the kept volume to be untagged, got %+v", archive)
+	}
+	if !strings.HasPrefix(archive.Name, "zmap-0-archive-") || !strings.Contains(fake.userData, "scsi-0DO_Volume_"+archive.Name) {
+		t.Errorf("expected the kept volume to be mounted under a unique name, got %s", archive.Name)
+	}
+	if ids := fake.droplets[0].VolumeIDs; len(ids) != 2 || ids[0] != data.ID || ids[1] != archive.ID {
+		t.Errorf("expected the volumes to be attached, got %v", ids)
+	}
+	if !strings.Contains(fake.userData, `mount_volume "/dev/disk/by-id/scsi-0DO_Volume_zmap-0-data" "ext4" "/mnt/data"`) {
+		t.Errorf("expected the volume to be mounted, got %q", fake.userData)
+	}
+
+	if err := p.DestroyServerByName(ctx, "zmap-0"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(fake.deletedVolumes) != 1 || fake.deletedVolumes[0] != "zmap-0-data" {
+		t.Errorf("expected only the volume not kept to be destroyed, got %v", fake.deletedVolumes)
+	}
+
+	// The volumes of a droplet which can not be created are destroyed
+	fake.unavailable = map[string]bool{"nyc1/s-1vcpu-1gb": true}
+	if _, err := p.CreateServer(ctx, cso.WithName("zmap-1").WithSize("s-1vcpu-1gb")); err == nil {
+		t.Fatalf("expected error when there is no capacity")
+	}
+	if len(fake.volumes) != 1 || fake.volumes[0].Name != archive.Name {
+		t.Errorf("expected the volumes of zmap-1 to be destroyed, got %v", fake.volumes)
+	}
+
+	// A server of the next run gets another kept volume
+	fake.unavailable = nil
+	if _, err := p.CreateServer(ctx, cso.WithName("zmap-0")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if next := fake.volumes[len(fake.volumes)-1]; !strings.HasPrefix(next.Name, "zmap-0-archive-") || next.Name == archive.Name {
+		t.Errorf("expected a kept volume with a new name, got %s and %s", archive.Name, next.Name)
+	}
+}
+
+func TestProviderKeyPairs(t *testing.T) {
+	ctx := context.Background()
+	key, err := dodetest.WritePrivateKey(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubkey, err := os.ReadFile(key + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := newFakeDigitalOcean(0)
+	fake.active = true
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	p := digitalocean.NewProvider("token").WithEndpoint(ts.URL).WithPollInterval(10 * time.Millisecond)
+
+	// The same key with another comment is registered once
+	if err := p.CreateKeyPair(ctx, "zmap", string(pubkey)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := p.CreateKeyPair(ctx, "zmap-again", strings.TrimSpace(string(pubkey))+" someone@laptop"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(fake.keys) != 1 {
+		t.Fatalf("expected 1 key, got %d", len(fake.keys))
+	}
+	cso := api.NewCreateServerOptions().WithName("zmap-0").WithTag("zmap").WithPublicKeyPath(key + ".pub")
+	if _, err := p.CreateServer(ctx, cso); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(fake.keys) != 1 || len(fake.keyIDs) != 1 || fake.keyIDs[0] != fake.keys[0].ID {
+		t.Errorf("expected the registered key to be authorized, got %v", fake.keyIDs)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := p.DeleteKeyPair(ctx, "zmap", string(pubkey)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(fake.keys) != 0 {
+		t.Errorf("expected the key to be deleted, got %d", len(fake.keys))
+	}
+}
+
+func TestProviderReservedIPs(t *testing.T) {
+	ctx := context.Background()
+	key, err := dodetest.WritePrivateKey(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := newFakeDigitalOcean(1)
+	fake.active = true
+	sfo3, nyc1 := &godo.Region{Slug: "sfo3"}, &godo.Region{Slug: "nyc1"}
+	fake.reservedIPs = []godo.ReservedIP{
+		{IP: "203.0.113.1", Region: sfo3, Droplet: &fake.droplets[0]},
+		{IP: "203.0.113.2", Region: nyc1},
+		{IP: "203.0.113.3", Region: sfo3},
+		{IP: "203.0.113.4", Region: sfo3},
+	}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	p := digitalocean.NewProvider("token").WithEndpoint(ts.URL).WithPollInterval(10 * time.Millisecond)
+	cso := api.NewCreateServerOptions().
+		WithName("zmap-0").
+		WithTag("zmap").
+		WithRegion("sfo3").
+		WithPublicKeyPath(key+".pub").
+		WithReservedIPs("203.0.113.1", "203.0.113.2", "203.0.113.3")
+
+	// Only the free reserved IP of the pool in the region is assigned
+	s, err := p.CreateServer(ctx, cso)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ip, err := s.EgressIPv4(); err != nil || ip != "203.0.113.3" {
+		t.Errorf("expected to egress from 203.0.113.3, got %s (%v)", ip, err)
+	}
+	if !strings.Contains(fake.userData, "anchor_ipv4/gateway") {
+		t.Errorf("expected the outbound traffic to be routed through the anchor ip, got %q", fake.userData)
+	}
+	servers := list(t)(p.ListServersByTag(ctx, "zmap"))
+	if ip, _ := servers[0].EgressIPv4(); len(servers) != 1 || ip != "203.0.113.3" {
+		t.Errorf("expected the listed server to egress from 203.0.113.3, got %s", ip)
+	}
+	if ip, _ := list(t)(p.ListServersByTag(ctx, "http"))[0].EgressIPv4(); ip != "203.0.113.1" {
+		t.Errorf("expected the existing droplet to egress from 203.0.113.1, got %s", ip)
+	}
+	// The reserved IPs listed to claim one are kept for the listed droplets
+	if fake.reservedIPLists != 1 {
+		t.Errorf("expected the reserved ips of the listed droplets to be cached, got %d lists", fake.reservedIPLists)
+	}
+
+	// The pool is exhausted
+	if _, err := p.CreateServer(ctx, cso.WithName("zmap-1")); !errors.Is(err, api.ErrCapacity) {
+		t.Errorf("expected a capacity error when no reserved IP is free, got %v", err)
+	}
+	if len(fake.droplets) != 2 {
+		t.Errorf("expected no droplet to be created without a reserved ip, got %d", len(fake.droplets))
+	}
+
+	if err := p.DestroyServerByTag(ctx, "zmap"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Contains(fake.actions, "unassign:203.0.113.3") || fake.reservedIPs[2].Droplet != nil {
+		t.Errorf("expected the reserved ip to be released, got %v", fake.actions)
+	}
+	if fake.reservedIPs[0].Droplet == nil {
+		t.Errorf("expected the reserved ip of another tag to be kept")
+	}
+}
+
+func TestProviderReservedIPsErrors(t *testing.T) {
+	fake := newFakeDigitalOcean(1)
+	fake.reservedIPsForbidden = true
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	p := digitalocean.NewProvider("token").WithEndpoint(ts.URL)
+	// The droplets would egress from an unknown address
+	if _, err := p.ListServersByTag(context.Background(), "http"); err == nil {
+		t.Errorf("expected the reserved ips error to be returned")
+	}
+}
+
+func TestProviderSnapshot(t *testing.T) {
+	ctx := context.Background()
+	key, err := dodetest.WritePrivateKey(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := newFakeDigitalOcean(0)
+	fake.active = true
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	p := digitalocean.NewProvider("token").WithEndpoint(ts.URL).WithPollInterval(10 * time.Millisecond)
+	cso := api.NewCreateServerOptions().
+		WithName("zmap-v1").
+		WithTag("zmap-v1").
+		WithRegions("nyc1", "sfo3").
+		WithPublicKeyPath(key + ".pub")
+
+	if id, err := p.FindImage(ctx, "zmap-v1"); err != nil || id != "" {
+		t.Fatalf("expected no image, got %q: %v", id, err)
+	}
+	s, err := p.CreateServer(ctx, cso)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	id, err := p.SnapshotServer(ctx, s, "zmap-v1", cso.PreferredRegions())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(fake.actions, ",") != "power_off,snapshot,transfer:sfo3" {
+		t.Errorf("unexpected actions %v", fake.actions)
+	}
+	if found, err := p.FindImage(ctx, "zmap-v1"); err != nil || found != id {
+		t.Errorf("expected image %s, got %q: %v", id, found, err)
+	}
+
+	// Droplets boot from the snapshot by ID
+	if _, err := p.CreateServer(ctx, cso.WithName("zmap-0").WithImage(id)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if image := fake.droplets[1].Image; strconv.Itoa(image.ID) != id || image.Slug != "" {
+		t.Errorf("expected the droplet to boot from %s, got %+v", id, image)
+	}
+}
+
+func TestProviderHourlyPrice(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeDigitalOcean(0)
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	p := digitalocean.NewProvider("token").WithEndpoint(ts.URL)
+
+	testcases := []struct {
+		size     string
+		expected float64
+		fails    bool
+	}{
+		{"s-1vcpu-1gb", 0.00893, false},
+		{"s-2vcpu-4gb", 0.03571, false},
+		{"s-64vcpu-256gb", 0, true},
+	}
+	for _, tc := range testcases {
+		price, err := p.HourlyPrice(ctx, "nyc1", tc.size)
+		if (err != nil) != tc.fails {
+			t.Errorf("%s: unexpected error %v", tc.size, err)
+		}
+		if price != tc.expected {
+			t.Errorf("%s: expected %f, got %f", tc.size, tc.expected, price)
+		}
+	}
+	if fake.sizeRequests != 1 {
+		t.Errorf("expected the sizes to be fetched once, got %d", fake.sizeRequests)
+	}
+}
+
+// list fails the test if listing the servers failed
+func list(t *testing.T) func([]server.Server, error) []server.Server {
+	return func(servers []server.Server, err error) []server.Server {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return servers
+	}
+}
@@ -2,13 +2,17 @@ package digitalocean
 
 import (
 	"fmt"
-	"log/slog"
+	"strconv"
+	"time"
 
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/resources"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
 	"github.com/digitalocean/godo"
 )
 
 type Server struct {
-	droplet godo.Droplet
+	droplet    godo.Droplet
+	reservedIP string
 }
 
 func NewServer(droplet godo.Droplet) *Server {
@@ -17,6 +21,12 @@ func NewServer(droplet godo.Droplet) *Server {
 	}
 }
 
+// WithReservedIP sets the reserved IP assigned to the droplet.
+func (s *Server) WithReservedIP(ip string) *Server {
+	s.reservedIP = ip
+	return s
+}
+
 func (s *Server) ID() string {
 	return fmt.Sprintf("%d", s.droplet.ID)
 }
@@ -25,22 +35,108 @@ func (s *Server) Name() string {
 	return s.droplet.Name
 }
 
-func (s *Server) IPv4() string {
-	ip, err := s.droplet.PublicIPv4()
+// address returns the address unless the droplet has none yet, e.g. while
+// it is being provisioned.
+func (s *Server) address(kind string, get func() (string, error)) (string, error) {
+	ip, err := get()
 	if err != nil {
-		slog.Error("error occured while getting public ipv4", slog.String("error", err.Error()))
+		return "", fmt.Errorf("%w: droplet %d: %w", server.ErrNoAddress, s.droplet.ID, err)
+	}
+	if ip == "" {
+		return "", fmt.Errorf("%w: droplet %d has no %s address", server.ErrNoAddress, s.droplet.ID, kind)
 	}
-	return ip
+	return ip, nil
 }
 
-func (s *Server) IPv6() string {
-	ip, err := s.droplet.PublicIPv6()
-	if err != nil {
-		slog.Error("error occured while getting public ipv6", slog.String("error", err.Error()))
+func (s *Server) IPv4() (string, error) {
+	return s.address("public ipv4", s.droplet.PublicIPv4)
+}
+
+func (s *Server) IPv6() (string, error) {
+	return s.address("public ipv6", s.droplet.PublicIPv6)
+}
+
+func (s *Server) PrivateIPv4() (string, error) {
+	return s.address("private ipv4", s.droplet.PrivateIPv4)
+}
+
+// EgressIPv4 returns the reserved IP assigned to the droplet if any, its
+// outbound traffic is routed through it at first boot.
+func (s *Server) EgressIPv4() (string, error) {
+	if s.reservedIP != "" {
+		return s.reservedIP, nil
 	}
-	return ip
+	return s.IPv4()
+}
+
+// ReservedIP returns the reserved IP assigned to the droplet, empty if none.
+func (s *Server) ReservedIP() string {
+	return s.reservedIP
 }
 
 func (s *Server) Tags() []string {
 	return s.droplet.Tags
 }
+
+// Region returns the region the droplet was created in.
+func (s *Server) Region() string {
+	if s.droplet.Region == nil {
+		return ""
+	}
+	return s.droplet.Region.Slug
+}
+
+func (s *Server) Size() string {
+	return s.droplet.SizeSlug
+}
+
+// Capacity returns the vCPUs and memory of the droplet.
+func (s *Server) Capacity() resources.Resources {
+	return resources.Resources{
+		CPUs:     float64(s.droplet.Vcpus),
+		MemoryMB: s.droplet.Memory,
+	}
+}
+
+// Image returns the slug of the image, or its ID for snapshots.
+func (s *Server) Image() string {
+	switch {
+	case s.droplet.Image == nil:
+		return ""
+	case s.droplet.Image.Slug != "":
+		return s.droplet.Image.Slug
+	case s.droplet.Image.ID != 0:
+		return strconv.Itoa(s.droplet.Image.ID)
+	}
+	return ""
+}
+
+func (s *Server) Status() server.Status {
+	switch s.droplet.Status {
+	case "new":
+		return server.StatusProvisioning
+	case "active":
+		return server.StatusRunning
+	case "off":
+		return server.StatusStopped
+	case "archive":
+		return server.StatusTerminated
+	}
+	return server.StatusUnknown
+}
+
+func (s *Server) CreatedAt() time.Time {
+	created, _ := time.Parse(time.RFC3339, s.droplet.Created)
+	return created
+}
+
+func (s *Server) HourlyPrice() float64 {
+	if s.droplet.Size == nil {
+		return 0
+	}
+	return s.droplet.Size.PriceHourly
+}
+
+func (s *Server) Provider() string {
+	return "digitalocean"
+}
@@ -0,0 +1,115 @@
+package digitalocean_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/digitalocean"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/resources"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+	"github.com/digitalocean/godo"
+)
+
+func TestServer(t *testing.T) {
+	networks := &godo.Networks{
+		V4: []godo.NetworkV4{
+			{IPAddress: "203.0.113.1", Type: "public"},
+			{IPAddress: "10.0.0.1", Type: "private"},
+		},
+	}
+	testcases := []struct {
+		name    string
+		droplet godo.Droplet
+		status  server.Status
+		ipv4    string
+		private string
+		image   string
+		price   float64
+	}{
+		{
+			name: "active",
+			droplet: godo.Droplet{
+				ID:       1,
+				Status:   "active",
+				Networks: networks,
+				Image:    &godo.Image{ID: 42, Slug: "docker-20-04"},
+				Size:     &godo.Size{Slug: "s-1vcpu-1gb", PriceHourly: 0.00893},
+			},
+			status:  server.StatusRunning,
+			ipv4:    "203.0.113.1",
+			private: "10.0.0.1",
+			image:   "docker-20-04",
+			price:   0.00893,
+		},
+		{
+			name:    "provisioning",
+			droplet: godo.Droplet{ID: 2, Status: "new"},
+			status:  server.StatusProvisioning,
+		},
+		{
+			name:    "snapshot",
+			droplet: godo.Droplet{ID: 3, Status: "off", Networks: networks, Image: &godo.Image{ID: 42}},
+			status:  server.StatusStopped,
+			ipv4:    "203.0.113.1",
+			private: "10.0.0.1",
+			image:   "42",
+		},
+		{
+			name:    "archived",
+			droplet: godo.Droplet{ID: 4, Status: "archive"},
+			status:  server.StatusTerminated,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := digitalocean.NewServer(tc.droplet)
+			if s.Status() != tc.status {
+				t.Errorf("expected status %s, got %s", tc.status, s.Status())
+			}
+			ip, err := s.IPv4()
+			if tc.ipv4 == "" {
+				if !errors.Is(err, server.ErrNoAddress) {
+					t.Errorf("expected no address, got %q (%v)", ip, err)
+				}
+			} else if err != nil || ip != tc.ipv4 {
+				t.Errorf("expected ipv4 %s, got %q (%v)", tc.ipv4, ip, err)
+			}
+			if ip, _ := s.PrivateIPv4(); ip != tc.private {
+				t.Errorf("expected private ipv4 %q, got %q", tc.private, ip)
+			}
+			if _, err := s.IPv6(); !errors.Is(err, server.ErrNoAddress) {
+				t.Errorf("expected no ipv6 address, got %v", err)
+			}
+			if s.Image() != tc.image {
+				t.Errorf("expected image %q, got %q", tc.image, s.Image())
+			}
+			if s.HourlyPrice() != tc.price {
+				t.Errorf("expected price %v, got %v", tc.price, s.HourlyPrice())
+			}
+			if s.Provider() != "digitalocean" {
+				t.Errorf("unexpected provider %s", s.Provider())
+			}
+		})
+	}
+}
+
+func TestServerPlacement(t *testing.T) {
+	s := digitalocean.NewServer(godo.Droplet{
+		ID:       1,
+		Region:   &godo.Region{Slug: "sfo3"},
+		SizeSlug: "s-2vcpu-4gb",
+		Vcpus:    2,
+		Memory:   4096,
+		Created:  "2024-03-01T12:00:00Z",
+	})
+	if s.Region() != "sfo3" || s.Size() != "s-2vcpu-4gb" {
+		t.Errorf("unexpected placement %s/%s", s.Region(), s.Size())
+	}
+	if want := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC); !s.CreatedAt().Equal(want) {
+		t.Errorf("expected creation time %s, got %s", want, s.CreatedAt())
+	}
+	if want := (resources.Resources{CPUs: 2, MemoryMB: 4096}); s.Capacity() != want {
+		t.Errorf("expected capacity %s, got %s", want, s.Capacity())
+	}
+}
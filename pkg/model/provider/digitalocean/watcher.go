@@ -0,0 +1,85 @@
+package digitalocean
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/digitalocean/godo"
+)
+
+// watcher waits for droplets to become active. Droplets being created with
+// the same tag are polled together with one list call per interval rather
+// than one call per droplet, which keeps large fleets under the rate limit.
+type watcher struct {
+	list     func(ctx context.Context, tag string) ([]godo.Droplet, error)
+	interval time.Duration
+	mu       sync.Mutex
+	waiters  map[string]map[int]chan godo.Droplet // tag -> droplet ID -> waiter
+}
+
+func newWatcher(list func(ctx context.Context, tag string) ([]godo.Droplet, error), interval time.Duration) *watcher {
+	return &watcher{
+		list:     list,
+		interval: interval,
+		waiters:  make(map[string]map[int]chan godo.Droplet),
+	}
+}
+
+// Wait blocks until the droplet is active or ctx is done.
+func (w *watcher) Wait(ctx context.Context, tag string, id int) (*godo.Droplet, error) {
+	active := make(chan godo.Droplet, 1)
+	w.mu.Lock()
+	if w.waiters[tag] == nil {
+		w.waiters[tag] = make(map[int]chan godo.Droplet)
+		go w.poll(tag)
+	}
+	w.waiters[tag][id] = active
+	w.mu.Unlock()
+	select {
+	case droplet := <-active:
+		return &droplet, nil
+	case <-ctx.Done():
+		w.mu.Lock()
+		delete(w.waiters[tag], id)
+		w.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// poll lists the droplets of the tag until nobody waits for them anymore.
+func (w *watcher) poll(tag string) {
+	numTries := 0
+	for {
+		time.Sleep(w.interval)
+		w.mu.Lock()
+		if len(w.waiters[tag]) == 0 {
+			delete(w.waiters, tag)
+			w.mu.Unlock()
+			return
+		}
+		w.mu.Unlock()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		droplets, err := w.list(ctx, tag)
+		cancel()
+		numTries++
+		if err != nil {
+			log.Error("error occured while polling droplets", "tag", tag, "error", err.Error())
+			continue
+		}
+		w.mu.Lock()
+		for _, droplet := range droplets {
+			active, ok := w.waiters[tag][droplet.ID]
+			if !ok {
+				continue
+			}
+			log.Debug("waiting", "droplet_id", droplet.ID, "status", droplet.Status, "num_tries", numTries)
+			if droplet.Status == "active" {
+				active <- droplet
+				delete(w.waiters[tag], droplet.ID)
+			}
+		}
+		w.mu.Unlock()
+	}
+}
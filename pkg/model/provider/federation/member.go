@@ -0,0 +1,86 @@
+package federation
+
+import (
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/api"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+)
+
+// Member is one provider of a federation, optionally pinned to a region, size
+// and image which override the ones of the scheduler.
+type Member struct {
+	Name     string
+	Provider provider.CloudServiceProvider
+	Region   string
+	Size     string
+	Image    string
+	// Weight is the share of servers created on this member relative to the others
+	Weight int
+	// Quota is the maximum number of servers alive at once, 0 means unlimited
+	Quota int
+}
+
+func NewMember(name string, p provider.CloudServiceProvider) *Member {
+	return &Member{
+		Name:     name,
+		Provider: p,
+		Weight:   1,
+	}
+}
+
+func (m *Member) WithRegion(region string) *Member {
+	m.Region = region
+	return m
+}
+
+func (m *Member) WithSize(size string) *Member {
+	m.Size = size
+	return m
+}
+
+func (m *Member) WithImage(image string) *Member {
+	m.Image = image
+	return m
+}
+
+func (m *Member) WithWeight(weight int) *Member {
+	m.Weight = weight
+	return m
+}
+
+func (m *Member) WithQuota(quota int) *Member {
+	m.Quota = quota
+	return m
+}
+
+// options returns a copy of cso with the member's overrides applied.
+func (m *Member) options(cso *api.CreateServerOptions) *api.CreateServerOptions {
+	options := *cso
+	if m.Region != "" {
+		options.Region = m.Region
+		options.Regions = nil
+	}
+	if m.Size != "" {
+		options.Size = m.Size
+		options.Sizes = nil
+	}
+	if m.Image != "" {
+		options.Image = m.Image
+	}
+	return &options
+}
+
+// own returns the servers of the member, the ones in its region if it is
+// pinned to one.
+func (m *Member) own(servers []server.Server) []server.Server {
+	if m.Region == "" {
+		return servers
+	}
+	owned := []server.Server{}
+	for _, s := range servers {
+		if s.Region() == m.Region {
+			owned = append(owned, s)
+		}
+	}
+	return owned
+}
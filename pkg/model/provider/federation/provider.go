@@ -0,0 +1,248 @@
+package federation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/api"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+	"github.com/charmbracelet/log"
+)
+
+// Provider spreads servers over several member providers according to their
+// weights and quotas. Listing and destroying fan out to every member. Members
+// pinned to a region only see the servers of their region, so that several
+// members may share an account.
+type Provider struct {
+	members []*Member
+	mu      sync.Mutex
+	// pending counts the servers being created on each member
+	pending map[string]int
+}
+
+func NewProvider(members ...*Member) *Provider {
+	return &Provider{
+		members: members,
+		pending: make(map[string]int),
+	}
+}
+
+func (p *Provider) WithMember(member *Member) *Provider {
+	p.members = append(p.members, member)
+	return p
+}
+
+func (p *Provider) Members() []*Member {
+	return p.members
+}
+
+// counts returns the number of servers with the tag of every member which may
+// host a new server. It calls the APIs of the members, so it runs unlocked.
+func (p *Provider) counts(ctx context.Context, tag string) map[string]int {
+	counts := map[string]int{}
+	for _, m := range p.members {
+		if m.Weight <= 0 {
+			continue
+		}
+		servers, err := m.Provider.ListServersByTag(ctx, tag)
+		if err != nil {
+			log.Error("error occured when listing servers of federation member", "member", m.Name, "error", err.Error())
+			continue
+		}
+		counts[m.Name] = len(m.own(servers))
+	}
+	return counts
+}
+
+// pick returns the most under-provisioned member (relative to its weight)
+// which has not been tried yet, nil if none has capacity left. The server is
+// pending on the member until release is called.
+func (p *Provider) pick(counts map[string]int, tried map[string]bool) *Member {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var picked *Member
+	pickedLoad := 0.0
+	for _, m := range p.members {
+		n, ok := counts[m.Name]
+		if !ok || tried[m.Name] {
+			continue
+		}
+		count := n + p.pending[m.Name]
+		if m.Quota > 0 && count >= m.Quota {
+			log.Debug("member quota reached", "member", m.Name, "quota", m.Quota)
+			continue
+		}
+		load := float64(count+1) / float64(m.Weight)
+		if picked == nil || load < pickedLoad {
+			picked, pickedLoad = m, load
+		}
+	}
+	if picked != nil {
+		p.pending[picked.Name]++
+	}
+	return picked
+}
+
+func (p *Provider) release(m *Member) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending[m.Name]--
+}
+
+func (p *Provider) CreateKeyPair(ctx context.Context, name string, pubkey string) error {
+	errs := []error{}
+	for _, m := range p.members {
+		if err := m.Provider.CreateKeyPair(ctx, name, pubkey); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", m.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DeleteKeyPair deletes the key pair from every member which can delete key
+// pairs.
+func (p *Provider) DeleteKeyPair(ctx context.Context, name string, pubkey string) error {
+	errs := []error{}
+	for _, m := range p.members {
+		deleter, ok := m.Provider.(provider.KeyPairDeleter)
+		if !ok {
+			continue
+		}
+		if err := deleter.DeleteKeyPair(ctx, name, pubkey); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", m.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CreateServer creates the server on the member with the lowest load, falling
+// back to the next one if the creation fails (e.g. when an account limit is hit).
+func (p *Provider) CreateServer(ctx context.Context, cso *api.CreateServerOptions) (server.Server, error) {
+	counts := p.counts(ctx, cso.Tag)
+	tried := map[string]bool{}
+	errs := []error{}
+	for ctx.Err() == nil {
+		m := p.pick(counts, tried)
+		if m == nil {
+			break
+		}
+		tried[m.Name] = true
+		log.Info("creating server on federation member", "member", m.Name, "name", cso.Name)
+		s, err := m.Provider.CreateServer(ctx, m.options(cso))
+		p.release(m)
+		if err == nil {
+			return NewServer(m.Name, s), nil
+		}
+		log.Error("error occured while creating server on federation member", "member", m.Name, "error", err.Error())
+		errs = append(errs, fmt.Errorf("%s: %w", m.Name, err))
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no federation member has capacity left for %s", cso.Name)
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (p *Provider) collect(list func(provider.CloudServiceProvider) ([]server.Server, error)) ([]server.Server, error) {
+	servers := []server.Server{}
+	errs := []error{}
+	for _, m := range p.members {
+		memberServers, err := list(m.Provider)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", m.Name, err))
+			continue
+		}
+		for _, s := range m.own(memberServers) {
+			servers = append(servers, NewServer(m.Name, s))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return servers, nil
+}
+
+func (p *Provider) ListServers(ctx context.Context) ([]server.Server, error) {
+	return p.collect(func(cp provider.CloudServiceProvider) ([]server.Server, error) {
+		return cp.ListServers(ctx)
+	})
+}
+
+func (p *Provider) ListServersByName(ctx context.Context, name string) ([]server.Server, error) {
+	return p.collect(func(cp provider.CloudServiceProvider) ([]server.Server, error) {
+		return cp.ListServersByName(ctx, name)
+	})
+}
+
+func (p *Provider) ListServersByTag(ctx context.Context, tag string) ([]server.Server, error) {
+	return p.collect(func(cp provider.CloudServiceProvider) ([]server.Server, error) {
+		return cp.ListServersByTag(ctx, tag)
+	})
+}
+
+// ListInterruptedServersByTag lists the interrupted servers of the members
+// implementing provider.Interruptible.
+func (p *Provider) ListInterruptedServersByTag(ctx context.Context, tag string) ([]server.Server, error) {
+	return p.collect(func(cp provider.CloudServiceProvider) ([]server.Server, error) {
+		if interruptible, ok := cp.(provider.Interruptible); ok {
+			return interruptible.ListInterruptedServersByTag(ctx, tag)
+		}
+		return nil, nil
+	})
+}
+
+// LeasesHosts reports whether any member leases hosts it did not create.
+func (p *Provider) LeasesHosts() bool {
+	for _, m := range p.members {
+		if leaser, ok := m.Provider.(provider.HostLeaser); ok && leaser.LeasesHosts() {
+			return true
+		}
+	}
+	return false
+}
+
+// each calls f on every member, joining their errors.
+func (p *Provider) each(f func(provider.CloudServiceProvider) error) error {
+	errs := []error{}
+	for _, m := range p.members {
+		if err := f(m.Provider); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", m.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *Provider) DestroyServerByName(ctx context.Context, name string) error {
+	return p.each(func(cp provider.CloudServiceProvider) error {
+		return cp.DestroyServerByName(ctx, name)
+	})
+}
+
+func (p *Provider) DestroyServerByTag(ctx context.Context, tag string) error {
+	return p.each(func(cp provider.CloudServiceProvider) error {
+		return cp.DestroyServerByTag(ctx, tag)
+	})
+}
+
+// CreateFirewall creates the firewall on every member, failing for members
+// which do not manage firewalls since their servers would be left open.
+func (p *Provider) CreateFirewall(ctx context.Context, tag string, fo *api.FirewallOptions) error {
+	return p.each(func(cp provider.CloudServiceProvider) error {
+		manager, ok := cp.(provider.FirewallManager)
+		if !ok {
+			return errors.New("firewalls are not supported")
+		}
+		return manager.CreateFirewall(ctx, tag, fo)
+	})
+}
+
+func (p *Provider) DestroyFirewall(ctx context.Context, tag string) error {
+	return p.each(func(cp provider.CloudServiceProvider) error {
+		if manager, ok := cp.(provider.FirewallManager); ok {
+			return manager.DestroyFirewall(ctx, tag)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,200 @@
+package federation_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/api"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/federation"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+)
+
+type stubServer struct {
+	id, name, region, tag string
+}
+
+func (s *stubServer) ID() string                   { return s.id }
+func (s *stubServer) Name() string                 { return s.name }
+func (s *stubServer) IPv4() (string, error)        { return "192.0.2.1", nil }
+func (s *stubServer) IPv6() (string, error)        { return "", server.ErrNoAddress }
+func (s *stubServer) PrivateIPv4() (string, error) { return "", server.ErrNoAddress }
+func (s *stubServer) EgressIPv4() (string, error)  { return "", server.ErrNoAddress }
+func (s *stubServer) Tags() []string               { return []string{s.tag} }
+func (s *stubServer) Region() string               { return s.region }
+func (s *stubServer) Size() string                 { return "" }
+func (s *stubServer) Image() string                { return "" }
+func (s *stubServer) Status() server.Status        { return server.StatusRunning }
+func (s *stubServer) CreatedAt() time.Time         { return time.Time{} }
+func (s *stubServer) HourlyPrice() float64         { return 0 }
+func (s *stubServer) Provider() string             { return "stub" }
+
+// stubProvider keeps its servers in memory, failing creations past limit
+type stubProvider struct {
+	servers   []*stubServer
+	limit     int
+	keyPairs  int
+	destroyed int
+}
+
+func (p *stubProvider) CreateKeyPair(ctx context.Context, name string, pub string) error {
+	p.keyPairs++
+	return nil
+}
+
+func (p *stubProvider) CreateServer(ctx context.Context, cso *api.CreateServerOptions) (server.Server, error) {
+	if p.limit > 0 && len(p.servers) >= p.limit {
+		return nil, errors.New("droplet limit exceeded")
+	}
+	s := &stubServer{id: fmt.Sprintf("%d", len(p.servers)+1), name: cso.Name, region: cso.Region, tag: cso.Tag}
+	p.servers = append(p.servers, s)
+	return s, nil
+}
+
+func (p *stubProvider) filter(match func(*stubServer) bool) []server.Server {
+	servers := []server.Server{}
+	for _, s := range p.servers {
+		if match(s) {
+			servers = append(servers, s)
+		}
+	}
+	return servers
+}
+
+func (p *stubProvider) ListServers(ctx context.Context) ([]server.Server, error) {
+	return p.filter(func(s *stubServer) bool { return true }), nil
+}
+
+func (p *stubProvider) ListServersByName(ctx context.Context, name string) ([]server.Server, error) {
+	return p.filter(func(s *stubServer) bool { return s.name == name }), nil
+}
+
+func (p *stubProvider) ListServersByTag(ctx context.Context, tag string) ([]server.Server, error) {
+	return p.filter(func(s *stubServer) bool { return s.tag == tag }), nil
+}
+
+func (p *stubProvider) DestroyServerByName(ctx context.Context, name string) error {
+	return errors.New("not implemented")
+}
+
+func (p *stubProvider) DestroyServerByTag(ctx context.Context, tag string) error {
+	remaining := []*stubServer{}
+	for _, s := range p.servers {
+		if s.tag == tag {
+			p.destroyed++
+		} else {
+			remaining = append(remaining, s)
+		}
+	}
+	p.servers = remaining
+	return nil
+}
+
+func create(t *testing.T, p *federation.Provider, n int) []server.Server {
+	ctx := context.Background()
+	servers := []server.Server{}
+	for i := 0; i < n; i++ {
+		s, err := p.CreateServer(ctx, api.NewCreateServerOptions().WithName(fmt.Sprintf("scan-%d", i)).WithTag("scan"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		servers = append(servers, s)
+	}
+	return servers
+}
+
+func TestProviderWeights(t *testing.T) {
+	ctx := context.Background()
+	do, hetzner := &stubProvider{}, &stubProvider{}
+	p := federation.NewProvider(
+		federation.NewMember("do", do).WithRegion("sfo2").WithWeight(60),
+		federation.NewMember("hetzner", hetzner).WithRegion("fsn1").WithSize("cx22").WithWeight(40),
+	)
+	servers := create(t, p, 10)
+
+	if len(do.servers) != 6 || len(hetzner.servers) != 4 {
+		t.Fatalf("expected 6/4 servers, got %d/%d", len(do.servers), len(hetzner.servers))
+	}
+	if do.servers[0].region != "sfo2" || hetzner.servers[0].region != "fsn1" {
+		t.Errorf("member regions were not applied")
+	}
+	ids := map[string]bool{}
+	for _, s := range servers {
+		ids[s.ID()] = true
+	}
+	if len(ids) != 10 {
+		t.Errorf("expected unique server ids, got %v", ids)
+	}
+	if n := len(list(t)(p.ListServersByTag(ctx, "scan"))); n != 10 {
+		t.Errorf("expected 10 servers, got %d", n)
+	}
+	if err := p.CreateKeyPair(ctx, "key", "ssh-ed25519 AAAA"); err != nil || do.keyPairs != 1 || hetzner.keyPairs != 1 {
+		t.Errorf("expected key pair on every member, got %v", err)
+	}
+	if err := p.DestroyServerByTag(ctx, "scan"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if do.destroyed != 6 || hetzner.destroyed != 4 {
+		t.Errorf("expected every server to be destroyed, got %d/%d", do.destroyed, hetzner.destroyed)
+	}
+}
+
+func TestProviderQuotaAndFallback(t *testing.T) {
+	ctx := context.Background()
+	// The first member is preferred, but its account is limited to one server
+	first, second, third := &stubProvider{limit: 1}, &stubProvider{}, &stubProvider{}
+	p := federation.NewProvider(
+		federation.NewMember("first", first).WithWeight(100),
+		federation.NewMember("second", second).WithWeight(1).WithQuota(1),
+	)
+	create(t, p, 2)
+	if len(first.servers) != 1 || len(second.servers) != 1 {
+		t.Fatalf("expected 1/1 servers, got %d/%d", len(first.servers), len(second.servers))
+	}
+	if _, err := p.CreateServer(ctx, api.NewCreateServerOptions().WithTag("scan")); err == nil {
+		t.Fatalf("expected error when every member is full")
+	}
+
+	p.WithMember(federation.NewMember("third", third))
+	create(t, p, 1)
+	if len(third.servers) != 1 {
+		t.Errorf("expected the new member to be used, got %d servers", len(third.servers))
+	}
+	if err := p.DestroyServerByName(ctx, "scan-0"); err == nil {
+		t.Errorf("expected member errors to be reported")
+	}
+}
+
+func TestProviderSharedAccount(t *testing.T) {
+	ctx := context.Background()
+	// Both members create servers in the same account
+	account := &stubProvider{}
+	p := federation.NewProvider(
+		federation.NewMember("sfo2", account).WithRegion("sfo2"),
+		federation.NewMember("nyc3", account).WithRegion("nyc3"),
+	)
+	create(t, p, 4)
+	regions := map[string]int{}
+	for _, s := range account.servers {
+		regions[s.region]++
+	}
+	if regions["sfo2"] != 2 || regions["nyc3"] != 2 {
+		t.Errorf("expected 2 servers per region, got %v", regions)
+	}
+	if n := len(list(t)(p.ListServersByTag(ctx, "scan"))); n != 4 {
+		t.Errorf("expected every server to be listed once, got %d", n)
+	}
+}
+
+// list fails the test if listing the servers failed
+func list(t *testing.T) func([]server.Server, error) []server.Server {
+	return func(servers []server.Server, err error) []server.Server {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return servers
+	}
+}
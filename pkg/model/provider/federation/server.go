@@ -0,0 +1,62 @@
+package federation
+
+import (
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/resources"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+)
+
+// Server is a server of a federation member. Its ID is prefixed with the
+// member name because IDs of different providers may collide.
+type Server struct {
+	server.Server
+	member string
+}
+
+func NewServer(member string, s server.Server) *Server {
+	return &Server{
+		Server: s,
+		member: member,
+	}
+}
+
+func (s *Server) ID() string {
+	return s.member + "/" + s.Server.ID()
+}
+
+// Member returns the name of the member the server belongs to.
+func (s *Server) Member() string {
+	return s.member
+}
+
+// Unwrap returns the server of the member provider.
+func (s *Server) Unwrap() server.Server {
+	return s.Server
+}
+
+func (s *Server) Capacity() resources.Resources {
+	if sized, ok := s.Server.(server.Sized); ok {
+		return sized.Capacity()
+	}
+	return resources.Resources{}
+}
+
+func (s *Server) SSHPort() int {
+	if endpoint, ok := s.Server.(server.SSHEndpoint); ok {
+		return endpoint.SSHPort()
+	}
+	return 22
+}
+
+func (s *Server) SSHUser() string {
+	if endpoint, ok := s.Server.(server.SSHEndpoint); ok {
+		return endpoint.SSHUser()
+	}
+	return "root"
+}
+
+func (s *Server) SSHPrivateKeyPath() string {
+	if endpoint, ok := s.Server.(server.SSHEndpoint); ok {
+		return endpoint.SSHPrivateKeyPath()
+	}
+	return ""
+}
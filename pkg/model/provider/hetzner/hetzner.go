@@ -0,0 +1,272 @@
+package hetzner
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/api"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/util/ratelimit"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/util/sshutil"
+	"github.com/charmbracelet/log"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+type Hetzner struct {
+	client *hcloud.Client
+}
+
+func newHetzner(options ...hcloud.ClientOption) *Hetzner {
+	return &Hetzner{
+		// Share the rate limit of the project and retry throttled calls
+		client: hcloud.NewClient(append([]hcloud.ClientOption{hcloud.WithHTTPClient(ratelimit.NewClient(nil))}, options...)...),
+	}
+}
+
+// FindSSHKey returns the key of the project with the public key, nil if there
+// is none. Keys are compared by SHA256 fingerprint, Hetzner only reports MD5
+// ones.
+func (h *Hetzner) FindSSHKey(ctx context.Context, pubkey string) (*hcloud.SSHKey, error) {
+	keys, err := h.client.SSHKey.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if sshutil.SameKey(key.PublicKey, pubkey) {
+			return key, nil
+		}
+	}
+	return nil, nil
+}
+
+func (h *Hetzner) CreateSSHKey(ctx context.Context, name string, pubkey string) (*hcloud.SSHKey, error) {
+	fingerprint, err := sshutil.Fingerprint(pubkey)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("public key fingerprint", "fingerprint", fingerprint)
+	// Check if key already exists
+	key, err := h.FindSSHKey(ctx, pubkey)
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		log.Info("ssh key already exists", "name", key.Name, "fingerprint", fingerprint)
+		return key, nil
+	}
+	// If key does not exist, create it
+	key, _, err = h.client.SSHKey.Create(ctx, hcloud.SSHKeyCreateOpts{
+		Name:      name,
+		PublicKey: pubkey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Info("ssh key created", "name", key.Name, "fingerprint", fingerprint)
+	return key, nil
+}
+
+// DeleteSSHKey deletes the key of the project with the public key if any.
+func (h *Hetzner) DeleteSSHKey(ctx context.Context, pubkey string) error {
+	key, err := h.FindSSHKey(ctx, pubkey)
+	if err != nil || key == nil {
+		return err
+	}
+	if _, err := h.client.SSHKey.Delete(ctx, key); err != nil {
+		log.Error("error occured when deleting ssh key", "name", key.Name, "error", err.Error())
+		return err
+	}
+	log.Info("ssh key deleted", "name", key.Name)
+	return nil
+}
+
+func (h *Hetzner) CreateServer(ctx context.Context, name, location, serverType, image, pubkey, tag, userData string, volumes []*hcloud.Volume) (*hcloud.Server, error) {
+	key, err := h.CreateSSHKey(ctx, name, pubkey)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("creating server", "name", name, "location", location, "type", serverType, "image", image)
+	result, _, err := h.client.Server.Create(ctx, hcloud.ServerCreateOpts{
+		Name:       name,
+		ServerType: &hcloud.ServerType{Name: serverType},
+		Image:      serverImage(image),
+		Location:   &hcloud.Location{Name: location},
+		SSHKeys:    []*hcloud.SSHKey{key},
+		UserData:   userData,
+		Volumes:    volumes,
+		Automount:  hcloud.Ptr(false),
+		Labels: map[string]string{
+			tag: "",
+		},
+		PublicNet: &hcloud.ServerCreatePublicNet{
+			EnableIPv4: true,
+			EnableIPv6: true,
+		},
+	})
+	if err != nil {
+		log.Error("error occured while creating server", "error", err.Error())
+		return nil, err
+	}
+	log.Info("server created", "server_id", result.Server.ID)
+	s, err := h.WaitServer(ctx, result.Server.ID)
+	if err != nil {
+		// Do not leave a server stuck in provisioning behind
+		log.Error("server did not start, destroying it", "server_id", result.Server.ID, "error", err.Error())
+		cleanupCtx, cancel := api.CleanupContext(ctx)
+		defer cancel()
+		if _, _, deleteErr := h.client.Server.DeleteWithResult(cleanupCtx, result.Server); deleteErr != nil {
+			log.Error("error occured when deleting server", "server_id", result.Server.ID, "error", deleteErr.Error())
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// serverImage refers to a snapshot by its numeric ID, other images by name.
+func serverImage(image string) *hcloud.Image {
+	if id, err := strconv.ParseInt(image, 10, 64); err == nil {
+		return &hcloud.Image{ID: id}
+	}
+	return &hcloud.Image{Name: image}
+}
+
+// WaitServer polls the server until it is running or ctx is done.
+func (h *Hetzner) WaitServer(ctx context.Context, id int64) (*hcloud.Server, error) {
+	numTries := 0
+	for {
+		s, _, err := h.client.Server.GetByID(ctx, id)
+		switch {
+		case err != nil:
+			log.Error("error occured while getting server", "error", err.Error())
+		case s == nil:
+			return nil, fmt.Errorf("server %d disappeared while starting", id)
+		default:
+			log.Debug("waiting", "server_id", s.ID, "ip", s.PublicNet.IPv4.IP, "status", s.Status, "num_tries", numTries)
+			if s.Status == hcloud.ServerStatusRunning {
+				return s, nil
+			}
+		}
+		numTries++
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("server %d is not running: %w", id, ctx.Err())
+		case <-time.After(1 * time.Second):
+		}
+	}
+}
+
+// ListServers lists the servers matching the label selector, walking every page.
+func (h *Hetzner) ListServers(ctx context.Context, labelSelector string) ([]*hcloud.Server, error) {
+	return h.client.Server.AllWithOpts(ctx, hcloud.ServerListOpts{
+		ListOpts: hcloud.ListOpts{
+			PerPage:       50,
+			LabelSelector: labelSelector,
+		},
+	})
+}
+
+func (h *Hetzner) DeleteServer(ctx context.Context, s *hcloud.Server) error {
+	log.Info("destroying server", "ip", s.PublicNet.IPv4.IP)
+	_, _, err := h.client.Server.DeleteWithResult(ctx, s)
+	if err != nil {
+		log.Error("error occured when deleting server", "error", err.Error())
+		return err
+	}
+	log.Info("server destroyed", "ip", s.PublicNet.IPv4.IP)
+	return nil
+}
+
+// CreateVolume creates a formatted volume in the location. Volumes labeled
+// like the servers are destroyed along with them.
+func (h *Hetzner) CreateVolume(ctx context.Context, name, location string, sizeGB int, filesystem string, labels map[string]string) (*hcloud.Volume, error) {
+	log.Info("creating volume", "name", name, "location", location, "size_gb", sizeGB)
+	result, _, err := h.client.Volume.Create(ctx, hcloud.VolumeCreateOpts{
+		Name:     name,
+		Size:     sizeGB,
+		Location: &hcloud.Location{Name: location},
+		Labels:   labels,
+		Format:   hcloud.Ptr(filesystem),
+	})
+	if err != nil {
+		log.Error("error occured while creating volume", "name", name, "error", err.Error())
+		return nil, err
+	}
+	return result.Volume, nil
+}
+
+// ListVolumes lists the volumes matching the label selector, walking every page.
+func (h *Hetzner) ListVolumes(ctx context.Context, labelSelector string) ([]*hcloud.Volume, error) {
+	return h.client.Volume.AllWithOpts(ctx, hcloud.VolumeListOpts{
+		ListOpts: hcloud.ListOpts{
+			PerPage:       50,
+			LabelSelector: labelSelector,
+		},
+	})
+}
+
+// DeleteVolume deletes the volume, waiting for it to be detached from a
+// server being destroyed.
+func (h *Hetzner) DeleteVolume(ctx context.Context, v *hcloud.Volume) error {
+	for numTries := 0; ; numTries++ {
+		_, err := h.client.Volume.Delete(ctx, v)
+		if err == nil {
+			log.Info("volume destroyed", "volume_id", v.ID)
+			return nil
+		}
+		log.Debug("waiting for the volume to be detached", "volume_id", v.ID, "num_tries", numTries, "error", err.Error())
+		if numTries >= 60 {
+			log.Error("error occured when deleting volume", "volume_id", v.ID, "error", err.Error())
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("volume %d is not deleted: %w", v.ID, ctx.Err())
+		case <-time.After(1 * time.Second):
+		}
+	}
+}
+
+// SnapshotServer powers the server off and snapshots it, the description of
+// the snapshot is its name.
+func (h *Hetzner) SnapshotServer(ctx context.Context, s *hcloud.Server, name string) (*hcloud.Image, error) {
+	log.Info("powering server off", "server_id", s.ID)
+	action, _, err := h.client.Server.Poweroff(ctx, s)
+	if err != nil {
+		log.Error("error occured when powering server off", "server_id", s.ID, "error", err.Error())
+		return nil, err
+	}
+	if err := h.client.Action.WaitFor(ctx, action); err != nil {
+		return nil, err
+	}
+	log.Info("snapshotting server", "server_id", s.ID, "name", name)
+	result, _, err := h.client.Server.CreateImage(ctx, s, &hcloud.ServerCreateImageOpts{
+		Type:        hcloud.ImageTypeSnapshot,
+		Description: hcloud.Ptr(name),
+	})
+	if err != nil {
+		log.Error("error occured when snapshotting server", "server_id", s.ID, "error", err.Error())
+		return nil, err
+	}
+	if err := h.client.Action.WaitFor(ctx, result.Action); err != nil {
+		return nil, err
+	}
+	return result.Image, nil
+}
+
+// FindSnapshot returns the snapshot described as name, nil if there is none.
+func (h *Hetzner) FindSnapshot(ctx context.Context, name string) (*hcloud.Image, error) {
+	images, err := h.client.Image.AllWithOpts(ctx, hcloud.ImageListOpts{
+		ListOpts: hcloud.ListOpts{PerPage: 50},
+		Type:     []hcloud.ImageType{hcloud.ImageTypeSnapshot},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, image := range images {
+		if image.Description == name {
+			return image, nil
+		}
+	}
+	return nil, nil
+}
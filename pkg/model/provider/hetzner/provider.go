@@ -0,0 +1,222 @@
+package hetzner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/api"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+	"github.com/charmbracelet/log"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+// Provider manages Hetzner Cloud servers. Scheduler tags are stored as label
+// keys, the region is a location (e.g. fsn1) and the size a server type (e.g. cx22).
+type Provider struct {
+	token   string
+	hetzner *Hetzner
+}
+
+func NewProvider(token string) *Provider {
+	return &Provider{
+		token:   token,
+		hetzner: newHetzner(hcloud.WithToken(token)),
+	}
+}
+
+func (p *Provider) WithEndpoint(endpoint string) *Provider {
+	p.hetzner = newHetzner(hcloud.WithToken(p.token), hcloud.WithEndpoint(endpoint))
+	return p
+}
+
+func (p *Provider) listServers(ctx context.Context, labelSelector string) ([]server.Server, error) {
+	remoteServers, err := p.hetzner.ListServers(ctx, labelSelector)
+	if err != nil {
+		log.Error("error occured when listing servers", "error", err.Error())
+		return nil, err
+	}
+	servers := []server.Server{}
+	for _, remoteServer := range remoteServers {
+		servers = append(servers, NewServer(remoteServer))
+	}
+	return servers, nil
+}
+
+func (p *Provider) ListServers(ctx context.Context) ([]server.Server, error) {
+	return p.listServers(ctx, "")
+}
+
+func (p *Provider) ListServersByName(ctx context.Context, name string) ([]server.Server, error) {
+	remoteServers, err := p.ListServers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	servers := []server.Server{}
+	for _, s := range remoteServers {
+		if s.Name() == name {
+			servers = append(servers, s)
+		}
+	}
+	return servers, nil
+}
+
+func (p *Provider) ListServersByTag(ctx context.Context, tag string) ([]server.Server, error) {
+	return p.listServers(ctx, tag)
+}
+
+func (p *Provider) CreateKeyPair(ctx context.Context, name string, pubkey string) error {
+	_, err := p.hetzner.CreateSSHKey(ctx, name, pubkey)
+	return err
+}
+
+func (p *Provider) DeleteKeyPair(ctx context.Context, name string, pubkey string) error {
+	return p.hetzner.DeleteSSHKey(ctx, pubkey)
+}
+
+func (p *Provider) CreateServer(ctx context.Context, cso *api.CreateServerOptions) (server.Server, error) {
+	if len(cso.ReservedIPs) > 0 {
+		return nil, api.ErrReservedIPsUnsupported
+	}
+	if cso.HasFallback() {
+		return nil, api.ErrFallbackUnsupported
+	}
+	pubkey, err := os.ReadFile(cso.PublicKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := cso.CreateContext(ctx)
+	defer cancel()
+	volumes := []*hcloud.Volume{}
+	cleanup := func() {
+		cleanupCtx, cancel := api.CleanupContext(ctx)
+		defer cancel()
+		for _, v := range volumes {
+			if err := p.hetzner.DeleteVolume(cleanupCtx, v); err != nil {
+				log.Error("error occured when deleting volume", "volume_id", v.ID, "error", err.Error())
+			}
+		}
+	}
+	volumeNames, err := cso.VolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	// The device of a volume is only known once it is created
+	devices := map[string]string{}
+	for _, vo := range cso.Volumes {
+		labels := map[string]string{cso.Tag: ""}
+		if vo.Keep {
+			labels = nil
+		}
+		v, err := p.hetzner.CreateVolume(ctx, volumeNames[vo.Name], cso.Region, vo.SizeGB, vo.Filesystem, labels)
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+		volumes = append(volumes, v)
+		devices[vo.Name] = v.LinuxDevice
+	}
+	userData, err := cso.BuildUserData(cso.MountParts(func(vo api.VolumeOptions) string {
+		return devices[vo.Name]
+	})...)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	s, err := p.hetzner.CreateServer(
+		ctx,
+		cso.Name,
+		cso.Region,
+		cso.Size,
+		cso.Image,
+		string(pubkey),
+		cso.Tag,
+		userData,
+		volumes,
+	)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	return NewServer(s), nil
+}
+
+func (p *Provider) destroy(ctx context.Context, servers []server.Server, err error) error {
+	if err != nil {
+		return err
+	}
+	for _, s := range servers {
+		if err := p.hetzner.DeleteServer(ctx, s.(*Server).server); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// destroyVolumes deletes the volumes matching the label selector and match.
+func (p *Provider) destroyVolumes(ctx context.Context, labelSelector string, match func(*hcloud.Volume) bool) error {
+	volumes, err := p.hetzner.ListVolumes(ctx, labelSelector)
+	if err != nil {
+		log.Error("error occured when listing volumes", "error", err.Error())
+		return err
+	}
+	errs := []error{}
+	for _, v := range volumes {
+		if match(v) {
+			errs = append(errs, p.hetzner.DeleteVolume(ctx, v))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *Provider) DestroyServerByName(ctx context.Context, name string) error {
+	servers, err := p.ListServersByName(ctx, name)
+	if err := p.destroy(ctx, servers, err); err != nil {
+		return err
+	}
+	// Destroy the volumes of the servers which are not kept
+	attached := map[int64]map[string]string{}
+	for _, s := range servers {
+		for _, v := range s.(*Server).server.Volumes {
+			attached[v.ID] = s.(*Server).server.Labels
+		}
+	}
+	if len(attached) == 0 {
+		return nil
+	}
+	return p.destroyVolumes(ctx, "", func(v *hcloud.Volume) bool {
+		for label := range v.Labels {
+			if _, ok := attached[v.ID][label]; ok {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (p *Provider) DestroyServerByTag(ctx context.Context, tag string) error {
+	servers, err := p.ListServersByTag(ctx, tag)
+	if err := p.destroy(ctx, servers, err); err != nil {
+		return err
+	}
+	return p.destroyVolumes(ctx, tag, func(*hcloud.Volume) bool { return true })
+}
+
+// SnapshotServer snapshots the server, Hetzner snapshots are available in
+// every location.
+func (p *Provider) SnapshotServer(ctx context.Context, s server.Server, name string, regions []string) (string, error) {
+	image, err := p.hetzner.SnapshotServer(ctx, s.(*Server).server, name)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(image.ID, 10), nil
+}
+
+func (p *Provider) FindImage(ctx context.Context, name string) (string, error) {
+	image, err := p.hetzner.FindSnapshot(ctx, name)
+	if err != nil || image == nil {
+		return "", err
+	}
+	return strconv.FormatInt(image.ID, 10), nil
+}
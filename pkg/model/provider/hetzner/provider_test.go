@@ -0,0 +1,127 @@
+package hetzner_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/hetzner"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+)
+
+// fakeHetzner serves the server and volume endpoints used by the provider
+type fakeHetzner struct {
+	mu             sync.Mutex
+	servers        []schema.Server
+	deleted        []string
+	volumes        []schema.Volume
+	deletedVolumes []string
+}
+
+func (f *fakeHetzner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/servers":
+		selector := r.URL.Query().Get("label_selector")
+		servers := []schema.Server{}
+		for _, s := range f.servers {
+			if _, ok := s.Labels[selector]; selector == "" || ok {
+				servers = append(servers, s)
+			}
+		}
+		json.NewEncoder(w).Encode(schema.ServerListResponse{Servers: servers})
+	case r.Method == http.MethodGet && r.URL.Path == "/volumes":
+		selector := r.URL.Query().Get("label_selector")
+		volumes := []schema.Volume{}
+		for _, v := range f.volumes {
+			if _, ok := v.Labels[selector]; selector == "" || ok {
+				volumes = append(volumes, v)
+			}
+		}
+		json.NewEncoder(w).Encode(schema.VolumeListResponse{Volumes: volumes})
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/volumes/"):
+		f.deletedVolumes = append(f.deletedVolumes, strings.TrimPrefix(r.URL.Path, "/volumes/"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/servers/"):
+		f.deleted = append(f.deleted, strings.TrimPrefix(r.URL.Path, "/servers/"))
+		json.NewEncoder(w).Encode(schema.ServerDeleteResponse{Action: schema.Action{ID: 1, Status: "running"}})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(schema.ErrorResponse{Error: schema.Error{Code: "not_found", Message: r.URL.Path}})
+	}
+}
+
+func newServer(id int64, name, ipv4, ipv6 string, labels map[string]string) schema.Server {
+	s := schema.Server{ID: id, Name: name, Status: "running", Labels: labels}
+	s.PublicNet.IPv4.IP = ipv4
+	s.PublicNet.IPv6.IP = ipv6
+	return s
+}
+
+func TestProviderListAndDestroyByTag(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeHetzner{
+		servers: []schema.Server{
+			newServer(1, "zmap-0", "192.0.2.1", "2001:db8:1::/64", map[string]string{"zmap": ""}),
+			newServer(2, "zmap-1", "192.0.2.2", "2001:db8:2::/64", map[string]string{"zmap": ""}),
+			newServer(3, "http-0", "192.0.2.3", "2001:db8:3::/64", map[string]string{"http": ""}),
+		},
+		volumes: []schema.Volume{
+			{ID: 10, Name: "zmap-0-data", Labels: map[string]string{"zmap": ""}},
+			// Kept volumes carry no label
+			{ID: 11, Name: "zmap-1-data", Labels: map[string]string{}},
+			{ID: 12, Name: "http-0-data", Labels: map[string]string{"http": ""}},
+		},
+	}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	p := hetzner.NewProvider("token").WithEndpoint(ts.URL)
+
+	if n := len(list(t)(p.ListServers(ctx))); n != 3 {
+		t.Fatalf("expected 3 servers, got %d", n)
+	}
+	servers := list(t)(p.ListServersByTag(ctx, "zmap"))
+	if len(servers) != 2 {
+		t.Fatalf("expected 2 servers, got %d", len(servers))
+	}
+	s := servers[0]
+	if ip, err := s.IPv4(); err != nil || ip != "192.0.2.1" {
+		t.Errorf("unexpected ipv4 %s (%v)", ip, err)
+	}
+	if ip, err := s.IPv6(); err != nil || ip != "2001:db8:1::1" {
+		t.Errorf("unexpected ipv6 %s (%v)", ip, err)
+	}
+	if tags := s.Tags(); len(tags) != 1 || tags[0] != "zmap" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+	if n := len(list(t)(p.ListServersByName(ctx, "http-0"))); n != 1 {
+		t.Errorf("expected 1 server named http-0, got %d", n)
+	}
+
+	if err := p.DestroyServerByTag(ctx, "zmap"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(fake.deleted, ",") != "1,2" {
+		t.Errorf("unexpected deleted servers %v", fake.deleted)
+	}
+	if strings.Join(fake.deletedVolumes, ",") != "10" {
+		t.Errorf("unexpected deleted volumes %v", fake.deletedVolumes)
+	}
+}
+
+// list fails the test if listing the servers failed
+func list(t *testing.T) func([]server.Server, error) []server.Server {
+	return func(servers []server.Server, err error) []server.Server {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return servers
+	}
+}
@@ -0,0 +1,21 @@
+package hetzner
+
+import (
+	"errors"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider"
+)
+
+// The hetzner provider reads Token and Endpoint.
+func init() {
+	provider.Register("hetzner", func(config provider.Config) (provider.CloudServiceProvider, error) {
+		if config.Token == "" {
+			return nil, errors.New("hetzner: token is required")
+		}
+		p := NewProvider(config.Token)
+		if config.Endpoint != "" {
+			p.WithEndpoint(config.Endpoint)
+		}
+		return p, nil
+	})
+}
@@ -0,0 +1,145 @@
+package hetzner
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/resources"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+type Server struct {
+	server *hcloud.Server
+}
+
+func NewServer(server *hcloud.Server) *Server {
+	return &Server{
+		server: server,
+	}
+}
+
+func (s *Server) ID() string {
+	return fmt.Sprintf("%d", s.server.ID)
+}
+
+func (s *Server) Name() string {
+	return s.server.Name
+}
+
+func (s *Server) IPv4() (string, error) {
+	ip := s.server.PublicNet.IPv4.IP
+	if ip == nil || ip.IsUnspecified() {
+		return "", fmt.Errorf("%w: server %d has no public ipv4 address", server.ErrNoAddress, s.server.ID)
+	}
+	return ip.String(), nil
+}
+
+// IPv6 returns the first address (::1) of the /64 network assigned to the server.
+func (s *Server) IPv6() (string, error) {
+	network := s.server.PublicNet.IPv6.IP
+	if network == nil || network.IsUnspecified() {
+		return "", fmt.Errorf("%w: server %d has no public ipv6 network", server.ErrNoAddress, s.server.ID)
+	}
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, network.To16())
+	ip[net.IPv6len-1] |= 1
+	return ip.String(), nil
+}
+
+// PrivateIPv4 returns the address of the server in its first private network.
+func (s *Server) PrivateIPv4() (string, error) {
+	if len(s.server.PrivateNet) == 0 || s.server.PrivateNet[0].IP == nil {
+		return "", fmt.Errorf("%w: server %d is in no private network", server.ErrNoAddress, s.server.ID)
+	}
+	return s.server.PrivateNet[0].IP.String(), nil
+}
+
+func (s *Server) EgressIPv4() (string, error) {
+	return s.IPv4()
+}
+
+func (s *Server) Tags() []string {
+	tags := []string{}
+	for key := range s.server.Labels {
+		tags = append(tags, key)
+	}
+	return tags
+}
+
+// Region returns the location of the server.
+func (s *Server) Region() string {
+	if s.server.Datacenter == nil || s.server.Datacenter.Location == nil {
+		return ""
+	}
+	return s.server.Datacenter.Location.Name
+}
+
+// Size returns the server type.
+func (s *Server) Size() string {
+	if s.server.ServerType == nil {
+		return ""
+	}
+	return s.server.ServerType.Name
+}
+
+// Capacity returns the cores and memory of the server type.
+func (s *Server) Capacity() resources.Resources {
+	if s.server.ServerType == nil {
+		return resources.Resources{}
+	}
+	return resources.Resources{
+		CPUs:     float64(s.server.ServerType.Cores),
+		MemoryMB: int(s.server.ServerType.Memory * 1024),
+	}
+}
+
+// Image returns the name of the image, or its ID for snapshots.
+func (s *Server) Image() string {
+	switch {
+	case s.server.Image == nil:
+		return ""
+	case s.server.Image.Name != "":
+		return s.server.Image.Name
+	}
+	return strconv.FormatInt(s.server.Image.ID, 10)
+}
+
+func (s *Server) Status() server.Status {
+	switch s.server.Status {
+	case hcloud.ServerStatusInitializing, hcloud.ServerStatusStarting:
+		return server.StatusProvisioning
+	case hcloud.ServerStatusRunning:
+		return server.StatusRunning
+	case hcloud.ServerStatusOff, hcloud.ServerStatusStopping:
+		return server.StatusStopped
+	case hcloud.ServerStatusDeleting:
+		return server.StatusTerminated
+	}
+	return server.StatusUnknown
+}
+
+func (s *Server) CreatedAt() time.Time {
+	return s.server.Created
+}
+
+// HourlyPrice returns the gross price in euros of the server type in the
+// location of the server.
+func (s *Server) HourlyPrice() float64 {
+	if s.server.ServerType == nil {
+		return 0
+	}
+	for _, pricing := range s.server.ServerType.Pricings {
+		if pricing.Location != nil && pricing.Location.Name == s.Region() {
+			price, _ := strconv.ParseFloat(pricing.Hourly.Gross, 64)
+			return price
+		}
+	}
+	return 0
+}
+
+func (s *Server) Provider() string {
+	return "hetzner"
+}
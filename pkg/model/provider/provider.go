@@ -1,33 +1,65 @@
 package provider
 
 import (
-	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/alibaba"
+	"context"
+
 	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/api"
-	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/digitalocean"
 	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
 )
 
+// CloudServiceProvider manages the servers of a cloud. Every call honors the
+// deadline and cancellation of its context.
 type CloudServiceProvider interface {
-	CreateKeyPair(name string, pub string) error
-	CreateServer(*api.CreateServerOptions) (server.Server, error)
-	ListServers() []server.Server
-	ListServersByName(name string) []server.Server
-	ListServersByTag(tag string) []server.Server
-	DestroyServerByName(name string) error
-	DestroyServerByTag(tag string) error
+	CreateKeyPair(ctx context.Context, name string, pub string) error
+	// CreateServer returns once the server is reachable. A server which does
+	// not become ready before the create timeout (see api.CreateServerOptions)
+	// or the cancellation of ctx is destroyed.
+	CreateServer(ctx context.Context, cso *api.CreateServerOptions) (server.Server, error)
+	ListServers(ctx context.Context) ([]server.Server, error)
+	ListServersByName(ctx context.Context, name string) ([]server.Server, error)
+	ListServersByTag(ctx context.Context, tag string) ([]server.Server, error)
+	DestroyServerByName(ctx context.Context, name string) error
+	DestroyServerByTag(ctx context.Context, tag string) error
+}
+
+// KeyPairDeleter is implemented by providers which can delete a key pair
+// registered with CreateKeyPair from the account, e.g. at the end of a run
+// using a key of its own.
+type KeyPairDeleter interface {
+	// DeleteKeyPair deletes the key pair named name or with the public key,
+	// it succeeds if there is none.
+	DeleteKeyPair(ctx context.Context, name string, pub string) error
+}
+
+// HostLeaser is implemented by providers which lease hosts they did not
+// create, e.g. from an inventory. The scheduler never replaces their hosts
+// when unhealthy.
+type HostLeaser interface {
+	LeasesHosts() bool
+}
+
+// Interruptible is implemented by providers whose servers can be reclaimed by
+// the cloud at any time (e.g. spot instances), so that the tasks running on
+// them can be rescheduled.
+type Interruptible interface {
+	ListInterruptedServersByTag(ctx context.Context, tag string) ([]server.Server, error)
+}
+
+// FirewallManager is implemented by providers managing a cloud firewall bound
+// to the servers of a tag, including the ones created later.
+type FirewallManager interface {
+	// CreateFirewall creates or updates the firewall of the tag.
+	CreateFirewall(ctx context.Context, tag string, fo *api.FirewallOptions) error
+	// DestroyFirewall destroys the firewall of the tag if any.
+	DestroyFirewall(ctx context.Context, tag string) error
 }
 
-func Use(name, token string) CloudServiceProvider {
-	providers := map[string]func(string) CloudServiceProvider{
-		"alibaba": func(token string) CloudServiceProvider {
-			return alibaba.NewProvider(token)
-		},
-		"digitalocean": func(token string) CloudServiceProvider {
-			return digitalocean.NewProvider(token)
-		},
-	}
-	if provider, ok := providers[name]; ok {
-		return provider(token)
-	}
-	panic("unsupported cloud service provider")
+// ImageBaker is implemented by providers which can snapshot a server into an
+// image new servers boot from (see api.CreateServerOptions.Image).
+type ImageBaker interface {
+	// SnapshotServer powers the server off and snapshots it into an image
+	// named name, available in the regions, returning the ID of the image.
+	SnapshotServer(ctx context.Context, s server.Server, name string, regions []string) (string, error)
+	// FindImage returns the ID of the image named name, empty if there is none.
+	FindImage(ctx context.Context, name string) (string, error)
 }
@@ -0,0 +1,85 @@
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Config holds the settings passed to provider factories. Each provider only
+// reads the fields it needs and documents them where it registers itself.
+type Config struct {
+	// Token is the API token (digitalocean, hetzner)
+	Token string
+	// AccessKeyID and AccessKeySecret are the API credentials (alibaba, aws)
+	AccessKeyID     string
+	AccessKeySecret string
+	// Region is the default region used to list servers (alibaba, aws)
+	Region string
+	// Regions are listed too, so that servers created in other regions by a
+	// previous run are found (alibaba, aws)
+	Regions []string
+	// Endpoint overrides the API base URL, e.g. for a local stand-in
+	Endpoint string
+	// InventoryPath is the path of the inventory file (static)
+	InventoryPath string
+	// SSHUser is the user the servers are logged into (aws)
+	SSHUser string
+	// SecurityGroupIDs are the security groups of the servers (alibaba takes
+	// exactly one, aws)
+	SecurityGroupIDs []string
+	// SubnetID is the network the servers are created in, the VSwitch on
+	// alibaba or the subnet on aws
+	SubnetID string
+	// Options holds settings of providers registered outside of this module
+	Options map[string]string
+}
+
+// Factory creates a provider from its configuration.
+type Factory func(Config) (CloudServiceProvider, error)
+
+var (
+	mu        sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+// Register makes a provider available by name, it is meant to be called from
+// the init function of the package implementing the provider. Like
+// database/sql, it panics if the name is registered twice or factory is nil.
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if factory == nil {
+		panic("provider: Register factory is nil")
+	}
+	if _, dup := factories[name]; dup {
+		panic("provider: Register called twice for provider " + name)
+	}
+	factories[name] = factory
+}
+
+// Providers returns the sorted names of the registered providers.
+func Providers() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Use creates the provider registered as name. The built-in providers are
+// registered by importing their packages, or all of them at once with
+//
+//	import _ "github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/all"
+func Use(name string, config Config) (CloudServiceProvider, error) {
+	mu.RLock()
+	factory, ok := factories[name]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported cloud service provider %q (available: %v)", name, Providers())
+	}
+	return factory(config)
+}
@@ -0,0 +1,62 @@
+package provider_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/dodetest"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider"
+	_ "github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/all"
+)
+
+func TestRegister(t *testing.T) {
+	provider.Register("in-house", func(config provider.Config) (provider.CloudServiceProvider, error) {
+		if config.Options["zone"] == "" {
+			return nil, errors.New("zone is required")
+		}
+		return dodetest.NewProvider(), nil
+	})
+
+	names := strings.Join(provider.Providers(), ",")
+	if names != "alibaba,aws,digitalocean,hetzner,in-house,static" {
+		t.Errorf("unexpected providers %s", names)
+	}
+
+	testcases := []struct {
+		name   string
+		config provider.Config
+		valid  bool
+	}{
+		{"in-house", provider.Config{Options: map[string]string{"zone": "lab"}}, true},
+		{"in-house", provider.Config{}, false},
+		{"digitalocean", provider.Config{Token: "dop_v1_token"}, true},
+		{"digitalocean", provider.Config{}, false},
+		{"aws", provider.Config{Token: "id:secret", Region: "eu-west-1"}, true},
+		{"aws", provider.Config{AccessKeyID: "id"}, false},
+		{"alibaba", provider.Config{AccessKeyID: "id", AccessKeySecret: "secret", SecurityGroupIDs: []string{"sg-1"}}, true},
+		{"alibaba", provider.Config{AccessKeyID: "id", AccessKeySecret: "secret"}, false},
+		{"static", provider.Config{InventoryPath: "does-not-exist.yaml"}, false},
+		{"unknown", provider.Config{Token: "token"}, false},
+	}
+	for _, tc := range testcases {
+		p, err := provider.Use(tc.name, tc.config)
+		if tc.valid && (err != nil || p == nil) {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%s: expected error", tc.name)
+		}
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic when registering a provider twice")
+		}
+	}()
+	provider.Register("digitalocean", func(config provider.Config) (provider.CloudServiceProvider, error) {
+		return nil, nil
+	})
+}
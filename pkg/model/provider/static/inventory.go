@@ -0,0 +1,81 @@
+package static
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/resources"
+	"gopkg.in/yaml.v3"
+)
+
+// Host describes an existing machine that can be reached over SSH.
+type Host struct {
+	Name        string `json:"name" yaml:"name"`
+	IPv4        string `json:"ip" yaml:"ip"`
+	IPv6        string `json:"ipv6" yaml:"ipv6"`
+	PrivateIPv4 string `json:"private_ip" yaml:"private_ip"`
+	// EgressIPv4 is the address the host reaches the internet from, e.g. the
+	// one of a NAT gateway, IPv4 if empty
+	EgressIPv4     string   `json:"egress_ip" yaml:"egress_ip"`
+	Port           int      `json:"port" yaml:"port"`
+	User           string   `json:"user" yaml:"user"`
+	PrivateKeyPath string   `json:"private_key_path" yaml:"private_key_path"`
+	Tags           []string `json:"tags" yaml:"tags"`
+	// Capacity is what the host offers to the tasks, unknown if empty
+	Capacity resources.Resources `json:"capacity" yaml:"capacity"`
+}
+
+// Inventory is a fixed list of hosts, usually loaded from a YAML or JSON file.
+type Inventory struct {
+	Hosts []Host `json:"hosts" yaml:"hosts"`
+}
+
+// LoadInventory reads an inventory file. Files ending with .json are decoded
+// as JSON, everything else is decoded as YAML.
+func LoadInventory(path string) (*Inventory, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	inventory := &Inventory{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, inventory)
+	} else {
+		err = yaml.Unmarshal(data, inventory)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse inventory %s: %w", path, err)
+	}
+	if err := inventory.normalize(); err != nil {
+		return nil, err
+	}
+	return inventory, nil
+}
+
+// normalize fills in default values and checks that every host is usable.
+func (i *Inventory) normalize() error {
+	names := map[string]bool{}
+	for index := range i.Hosts {
+		host := &i.Hosts[index]
+		if host.IPv4 == "" {
+			return fmt.Errorf("host #%d has no ip", index)
+		}
+		if host.Name == "" {
+			host.Name = host.IPv4
+		}
+		if names[host.Name] {
+			return fmt.Errorf("duplicate host name %s", host.Name)
+		}
+		names[host.Name] = true
+		if host.Port == 0 {
+			host.Port = 22
+		}
+		if host.User == "" {
+			host.User = "root"
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package static
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/api"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+	"github.com/charmbracelet/log"
+)
+
+// Provider hands out hosts of a fixed inventory instead of calling a cloud API.
+// Creating a server leases an unused host, destroying it releases the lease.
+// Nothing is ever created or deleted on the hosts themselves.
+type Provider struct {
+	mu     sync.Mutex
+	hosts  []Host
+	leases map[string]string // host name -> tag
+}
+
+func NewProvider(inventory *Inventory) *Provider {
+	return &Provider{
+		hosts:  inventory.Hosts,
+		leases: make(map[string]string),
+	}
+}
+
+func (p *Provider) listServers() []server.Server {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	servers := []server.Server{}
+	for _, host := range p.hosts {
+		servers = append(servers, NewServer(host, p.leases[host.Name]))
+	}
+	return servers
+}
+
+func (p *Provider) ListServers(ctx context.Context) ([]server.Server, error) {
+	return p.listServers(), nil
+}
+
+func (p *Provider) ListServersByName(ctx context.Context, name string) ([]server.Server, error) {
+	servers := []server.Server{}
+	for _, s := range p.listServers() {
+		if s.Name() == name {
+			servers = append(servers, s)
+		}
+	}
+	return servers, nil
+}
+
+func (p *Provider) ListServersByTag(ctx context.Context, tag string) ([]server.Server, error) {
+	servers := []server.Server{}
+	for _, s := range p.listServers() {
+		for _, t := range s.Tags() {
+			if t == tag {
+				servers = append(servers, s)
+				break
+			}
+		}
+	}
+	return servers, nil
+}
+
+// LeasesHosts reports that the inventory hosts are leased, not created.
+func (p *Provider) LeasesHosts() bool {
+	return true
+}
+
+// CreateKeyPair is a no-op, the keys of inventory hosts are managed out of band.
+func (p *Provider) CreateKeyPair(ctx context.Context, name string, pubkey string) error {
+	return nil
+}
+
+// DeleteKeyPair is a no-op, as CreateKeyPair.
+func (p *Provider) DeleteKeyPair(ctx context.Context, name string, pubkey string) error {
+	return nil
+}
+
+func (p *Provider) CreateServer(ctx context.Context, cso *api.CreateServerOptions) (server.Server, error) {
+	if len(cso.Volumes) > 0 {
+		return nil, api.ErrVolumesUnsupported
+	}
+	if len(cso.ReservedIPs) > 0 {
+		return nil, api.ErrReservedIPsUnsupported
+	}
+	if cso.HasFallback() {
+		return nil, api.ErrFallbackUnsupported
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, host := range p.hosts {
+		if _, ok := p.leases[host.Name]; ok {
+			continue
+		}
+		p.leases[host.Name] = cso.Tag
+		log.Info("inventory host leased", "name", host.Name, "ip", host.IPv4, "tag", cso.Tag)
+		return NewServer(host, cso.Tag), nil
+	}
+	return nil, fmt.Errorf("no unused host left in inventory (%d hosts)", len(p.hosts))
+}
+
+func (p *Provider) DestroyServerByName(ctx context.Context, name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.leases[name]; ok {
+		delete(p.leases, name)
+		log.Info("inventory host released", "name", name)
+	}
+	return nil
+}
+
+func (p *Provider) DestroyServerByTag(ctx context.Context, tag string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name, t := range p.leases {
+		if t == tag {
+			delete(p.leases, name)
+			log.Info("inventory host released", "name", name, "tag", tag)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package static_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/api"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/static"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/resources"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+)
+
+func writeInventory(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Test LoadInventory
+func TestLoadInventory(t *testing.T) {
+	testcases := []struct {
+		name    string
+		content string
+	}{
+		{"inventory.yaml", "hosts:\n  - name: lab-1\n    ip: 10.0.0.1\n    tags: [lab]\n    capacity:\n      cpus: 4\n      memory_mb: 8192\n  - ip: 10.0.0.2\n    port: 2222\n    user: ubuntu\n"},
+		{"inventory.json", `{"hosts": [{"name": "lab-1", "ip": "10.0.0.1", "tags": ["lab"], "capacity": {"cpus": 4, "memory_mb": 8192}}, {"ip": "10.0.0.2", "port": 2222, "user": "ubuntu"}]}`},
+	}
+	for _, testcase := range testcases {
+		inventory, err := static.LoadInventory(writeInventory(t, testcase.name, testcase.content))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(inventory.Hosts) != 2 {
+			t.Fatalf("expected 2 hosts, got %d", len(inventory.Hosts))
+		}
+		if inventory.Hosts[0].Port != 22 || inventory.Hosts[0].User != "root" {
+			t.Errorf("expected default port and user, got %d and %s", inventory.Hosts[0].Port, inventory.Hosts[0].User)
+		}
+		if want := (resources.Resources{CPUs: 4, MemoryMB: 8192}); inventory.Hosts[0].Capacity != want {
+			t.Errorf("expected capacity %s, got %s", want, inventory.Hosts[0].Capacity)
+		}
+		if inventory.Hosts[1].Name != "10.0.0.2" {
+			t.Errorf("expected name to default to ip, got %s", inventory.Hosts[1].Name)
+		}
+	}
+}
+
+func TestLoadInventoryRejectsHostWithoutIP(t *testing.T) {
+	_, err := static.LoadInventory(writeInventory(t, "inventory.yaml", "hosts:\n  - name: lab-1\n"))
+	if err == nil {
+		t.Errorf("expected error for host without ip")
+	}
+}
+
+func TestLeaseAndRelease(t *testing.T) {
+	ctx := context.Background()
+	p := static.NewProvider(&static.Inventory{Hosts: []static.Host{
+		{Name: "lab-1", IPv4: "10.0.0.1", Port: 22, User: "root"},
+		{Name: "lab-2", IPv4: "10.0.0.2", Port: 22, User: "root"},
+	}})
+	cso := api.NewCreateServerOptions().WithTag("job")
+	for i := 0; i < 2; i++ {
+		if _, err := p.CreateServer(ctx, cso); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := p.CreateServer(ctx, cso); err == nil {
+		t.Errorf("expected error when inventory is exhausted")
+	}
+	fallback := api.NewCreateServerOptions().WithTag("job").WithRegions("nyc1", "sfo3")
+	if _, err := p.CreateServer(ctx, fallback); !errors.Is(err, api.ErrFallbackUnsupported) {
+		t.Errorf("expected fallback to be rejected, got %v", err)
+	}
+	if n := len(list(t)(p.ListServersByTag(ctx, "job"))); n != 2 {
+		t.Errorf("expected 2 leased servers, got %d", n)
+	}
+	if err := p.DestroyServerByTag(ctx, "job"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := len(list(t)(p.ListServersByTag(ctx, "job"))); n != 0 {
+		t.Errorf("expected no leased servers, got %d", n)
+	}
+	if n := len(list(t)(p.ListServers(ctx))); n != 2 {
+		t.Errorf("expected hosts to be kept after release, got %d", n)
+	}
+}
+
+// list fails the test if listing the servers failed
+func list(t *testing.T) func([]server.Server, error) []server.Server {
+	return func(servers []server.Server, err error) []server.Server {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return servers
+	}
+}
@@ -0,0 +1,25 @@
+package static
+
+import (
+	"errors"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider"
+)
+
+// The static provider reads InventoryPath, falling back to Token.
+func init() {
+	provider.Register("static", func(config provider.Config) (provider.CloudServiceProvider, error) {
+		path := config.InventoryPath
+		if path == "" {
+			path = config.Token
+		}
+		if path == "" {
+			return nil, errors.New("static: inventory path is required")
+		}
+		inventory, err := LoadInventory(path)
+		if err != nil {
+			return nil, err
+		}
+		return NewProvider(inventory), nil
+	})
+}
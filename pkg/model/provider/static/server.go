@@ -0,0 +1,114 @@
+package static
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/resources"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+)
+
+type Server struct {
+	host Host
+	tag  string
+}
+
+func NewServer(host Host, tag string) *Server {
+	return &Server{
+		host: host,
+		tag:  tag,
+	}
+}
+
+func (s *Server) ID() string {
+	return s.host.Name
+}
+
+func (s *Server) Name() string {
+	return s.host.Name
+}
+
+// address returns the address declared in the inventory unless it is empty.
+func (s *Server) address(kind, ip string) (string, error) {
+	if ip == "" {
+		return "", fmt.Errorf("%w: host %s has no %s address in the inventory", server.ErrNoAddress, s.host.Name, kind)
+	}
+	return ip, nil
+}
+
+func (s *Server) IPv4() (string, error) {
+	return s.address("ipv4", s.host.IPv4)
+}
+
+func (s *Server) IPv6() (string, error) {
+	return s.address("ipv6", s.host.IPv6)
+}
+
+func (s *Server) PrivateIPv4() (string, error) {
+	return s.address("private ipv4", s.host.PrivateIPv4)
+}
+
+func (s *Server) EgressIPv4() (string, error) {
+	if s.host.EgressIPv4 != "" {
+		return s.host.EgressIPv4, nil
+	}
+	return s.IPv4()
+}
+
+// Tags returns the tags declared in the inventory plus the tag of the
+// scheduler which is currently using the host.
+func (s *Server) Tags() []string {
+	tags := append([]string{}, s.host.Tags...)
+	if s.tag != "" {
+		tags = append(tags, s.tag)
+	}
+	return tags
+}
+
+func (s *Server) SSHPort() int {
+	return s.host.Port
+}
+
+func (s *Server) SSHUser() string {
+	return s.host.User
+}
+
+func (s *Server) SSHPrivateKeyPath() string {
+	return s.host.PrivateKeyPath
+}
+
+// Region, Size, Image and CreatedAt are unknown for inventory hosts.
+func (s *Server) Region() string {
+	return ""
+}
+
+func (s *Server) Size() string {
+	return ""
+}
+
+// Capacity returns the resources declared in the inventory.
+func (s *Server) Capacity() resources.Resources {
+	return s.host.Capacity
+}
+
+func (s *Server) Image() string {
+	return ""
+}
+
+// Status reports inventory hosts as running, they are not managed.
+func (s *Server) Status() server.Status {
+	return server.StatusRunning
+}
+
+func (s *Server) CreatedAt() time.Time {
+	return time.Time{}
+}
+
+// HourlyPrice is zero, inventory hosts are paid for anyway.
+func (s *Server) HourlyPrice() float64 {
+	return 0
+}
+
+func (s *Server) Provider() string {
+	return "static"
+}
@@ -0,0 +1,189 @@
+// Package readiness checks that a new server is able to run tasks.
+package readiness
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrNotReady is returned when a probe did not pass before its timeout.
+var ErrNotReady = errors.New("server is not ready")
+
+// Checker probes a server in turn for an open SSH port, a successful SSH
+// login, finished cloud-init and a responding docker daemon. Each probe is
+// retried until it passes or its own timeout expires.
+type Checker struct {
+	TCPTimeout       time.Duration
+	SSHTimeout       time.Duration
+	CloudInitTimeout time.Duration
+	DockerTimeout    time.Duration
+	// Interval is the delay between two attempts of a probe
+	Interval time.Duration
+}
+
+func NewChecker() *Checker {
+	return &Checker{
+		TCPTimeout:       3 * time.Minute,
+		SSHTimeout:       2 * time.Minute,
+		CloudInitTimeout: 20 * time.Minute,
+		DockerTimeout:    2 * time.Minute,
+		Interval:         5 * time.Second,
+	}
+}
+
+func (c *Checker) WithTCPTimeout(timeout time.Duration) *Checker {
+	c.TCPTimeout = timeout
+	return c
+}
+
+func (c *Checker) WithSSHTimeout(timeout time.Duration) *Checker {
+	c.SSHTimeout = timeout
+	return c
+}
+
+func (c *Checker) WithCloudInitTimeout(timeout time.Duration) *Checker {
+	c.CloudInitTimeout = timeout
+	return c
+}
+
+func (c *Checker) WithDockerTimeout(timeout time.Duration) *Checker {
+	c.DockerTimeout = timeout
+	return c
+}
+
+func (c *Checker) WithInterval(interval time.Duration) *Checker {
+	c.Interval = interval
+	return c
+}
+
+// fatal stops the retries of a probe, e.g. when cloud-init failed
+type fatal struct {
+	error
+}
+
+// Check returns nil once the server at addr (host:port) passed every probe.
+// The error wraps ErrNotReady if a probe did not pass.
+func (c *Checker) Check(ctx context.Context, addr string, config *ssh.ClientConfig) error {
+	err := c.probe(ctx, "tcp", addr, c.TCPTimeout, func(ctx context.Context) error {
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	})
+	if err != nil {
+		return err
+	}
+
+	var client *ssh.Client
+	err = c.probe(ctx, "ssh", addr, c.SSHTimeout, func(ctx context.Context) error {
+		var dialErr error
+		client, dialErr = dial(ctx, addr, config)
+		return dialErr
+	})
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	err = c.probe(ctx, "cloud-init", addr, c.CloudInitTimeout, func(ctx context.Context) error {
+		stdout, err := run(ctx, client, "cloud-init status --wait")
+		var exitErr *ssh.ExitError
+		switch {
+		case err == nil:
+			log.Info("cloud-init finished", "server", addr, "status", strings.TrimSpace(stdout))
+			return nil
+		case errors.As(err, &exitErr) && exitErr.ExitStatus() == 127:
+			log.Debug("cloud-init is not installed", "server", addr)
+			return nil
+		case errors.As(err, &exitErr) && exitErr.ExitStatus() == 2:
+			log.Warn("cloud-init finished with recoverable errors", "server", addr)
+			return nil
+		case errors.As(err, &exitErr):
+			return fatal{fmt.Errorf("cloud-init failed: %w", err)}
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.probe(ctx, "docker", addr, c.DockerTimeout, func(ctx context.Context) error {
+		stdout, err := run(ctx, client, "docker info --format {{.ServerVersion}}")
+		if err != nil {
+			return err
+		}
+		log.Debug("docker is running", "server", addr, "version", strings.TrimSpace(stdout))
+		return nil
+	})
+}
+
+// probe calls check until it succeeds, fails fatally or the timeout expires.
+func (c *Checker) probe(ctx context.Context, name, addr string, timeout time.Duration, check func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for numTries := 0; ; numTries++ {
+		err := check(ctx)
+		if err == nil {
+			log.Debug("probe passed", "probe", name, "server", addr, "num_tries", numTries)
+			return nil
+		}
+		var f fatal
+		if errors.As(err, &f) {
+			return fmt.Errorf("%w: %s probe failed: %w", ErrNotReady, name, f.error)
+		}
+		log.Debug("probe failed", "probe", name, "server", addr, "num_tries", numTries, "error", err.Error())
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %s probe did not pass within %s: %w", ErrNotReady, name, timeout, err)
+		case <-time.After(c.Interval):
+		}
+	}
+}
+
+// dial logs in over SSH, giving up when ctx is done.
+func dial(ctx context.Context, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// run runs the command, giving up when ctx is done.
+func run(ctx context.Context, client *ssh.Client, cmd string) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	stdout := &bytes.Buffer{}
+	session.Stdout = stdout
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(cmd)
+	}()
+	select {
+	case err := <-done:
+		return stdout.String(), err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
@@ -0,0 +1,92 @@
+package readiness_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/dodetest"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/executor/secureshell"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/readiness"
+)
+
+func TestChecker(t *testing.T) {
+	key, err := dodetest.WritePrivateKey(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := secureshell.NewSSHExecutor().WithPrivateKeyPath(key).GetConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testcases := []struct {
+		name  string
+		setup func(*dodetest.Server)
+		// probe is the probe expected to fail, empty if the server is ready
+		probe string
+	}{
+		{"ready", func(s *dodetest.Server) {}, ""},
+		{"flaky ssh", func(s *dodetest.Server) { s.WithConnectionFailures(3) }, ""},
+		{"provisioning", func(s *dodetest.Server) { s.WithCloudInit(50*time.Millisecond, "done") }, ""},
+		{"ssh down", func(s *dodetest.Server) { s.WithConnectionFailures(1000) }, "ssh"},
+		{"cloud-init failed", func(s *dodetest.Server) { s.WithCloudInit(0, "error") }, "cloud-init"},
+		{"cloud-init stuck", func(s *dodetest.Server) { s.WithCloudInit(time.Hour, "done") }, "cloud-init"},
+		{"docker down", func(s *dodetest.Server) {
+			s.Handle("docker", func(args []string, stdout, stderr io.Writer) int {
+				fmt.Fprintln(stderr, "Cannot connect to the Docker daemon")
+				return 1
+			})
+		}, "docker"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := dodetest.NewServer()
+			tc.setup(s)
+			if err := s.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			checker := readiness.NewChecker().
+				WithTCPTimeout(200 * time.Millisecond).
+				WithSSHTimeout(200 * time.Millisecond).
+				WithCloudInitTimeout(200 * time.Millisecond).
+				WithDockerTimeout(200 * time.Millisecond).
+				WithInterval(10 * time.Millisecond)
+
+			err := checker.Check(context.Background(), net.JoinHostPort(s.Host(), fmt.Sprint(s.Port())), config)
+			if tc.probe == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, readiness.ErrNotReady) || !strings.Contains(err.Error(), tc.probe+" probe") {
+				t.Fatalf("expected the %s probe to fail, got %v", tc.probe, err)
+			}
+		})
+	}
+}
+
+func TestCheckerPortClosed(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	checker := readiness.NewChecker().WithTCPTimeout(50 * time.Millisecond).WithInterval(10 * time.Millisecond)
+
+	start := time.Now()
+	err = checker.Check(context.Background(), addr, nil)
+	if !errors.Is(err, readiness.ErrNotReady) || !strings.Contains(err.Error(), "tcp probe") {
+		t.Fatalf("expected the tcp probe to fail, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the probe to honor its timeout, took %s", elapsed)
+	}
+}
@@ -0,0 +1,148 @@
+// Package reaper destroys the servers left behind by controllers which
+// crashed before Scheduler.Wait could destroy them.
+package reaper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/lease"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+	"github.com/charmbracelet/log"
+)
+
+// Orphan is a server the reaper destroys, with the reason why.
+type Orphan struct {
+	Server server.Server
+	Tag    string
+	Reason string
+}
+
+// Reaper finds the servers with a tag starting with the managed prefix which
+// are older than the TTL, or whose tag has no live lease.
+type Reaper struct {
+	provider provider.CloudServiceProvider
+	prefix   string
+	ttl      time.Duration
+	leases   lease.Store
+	dryRun   bool
+}
+
+func New(p provider.CloudServiceProvider) *Reaper {
+	return &Reaper{
+		provider: p,
+	}
+}
+
+// WithPrefix sets the prefix of the tags managed by the reaper, servers with
+// other tags are never touched.
+func (r *Reaper) WithPrefix(prefix string) *Reaper {
+	r.prefix = prefix
+	return r
+}
+
+// WithTTL caps the lifetime of servers, even if their controller is alive.
+// Servers with an unknown creation time are not subject to it.
+func (r *Reaper) WithTTL(ttl time.Duration) *Reaper {
+	r.ttl = ttl
+	return r
+}
+
+// WithLeases reaps the servers of tags without a live lease in the store.
+func (r *Reaper) WithLeases(store lease.Store) *Reaper {
+	r.leases = store
+	return r
+}
+
+// WithDryRun only lists the orphans, Reap then destroys nothing.
+func (r *Reaper) WithDryRun(dryRun bool) *Reaper {
+	r.dryRun = dryRun
+	return r
+}
+
+// Find lists the orphaned servers.
+func (r *Reaper) Find(ctx context.Context) ([]Orphan, error) {
+	if r.prefix == "" {
+		return nil, errors.New("a tag prefix is required")
+	}
+	if r.ttl <= 0 && r.leases == nil {
+		return nil, errors.New("either a TTL or a lease store is required")
+	}
+	servers, err := r.provider.ListServers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list servers: %w", err)
+	}
+	now := time.Now()
+	alive := map[string]bool{}
+	orphans := []Orphan{}
+	for _, s := range servers {
+		tag := r.managedTag(s)
+		if tag == "" {
+			continue
+		}
+		if age := now.Sub(s.CreatedAt()); r.ttl > 0 && !s.CreatedAt().IsZero() && age > r.ttl {
+			orphans = append(orphans, Orphan{Server: s, Tag: tag, Reason: fmt.Sprintf("up for %s, longer than the TTL", age.Round(time.Second))})
+			continue
+		}
+		if r.leases == nil {
+			continue
+		}
+		if _, ok := alive[tag]; !ok {
+			if alive[tag], err = r.alive(ctx, tag, now); err != nil {
+				return nil, err
+			}
+		}
+		if !alive[tag] {
+			orphans = append(orphans, Orphan{Server: s, Tag: tag, Reason: "no live controller lease"})
+		}
+	}
+	return orphans, nil
+}
+
+// managedTag returns the first tag of the server with the prefix, empty if
+// the server is not managed.
+func (r *Reaper) managedTag(s server.Server) string {
+	for _, tag := range s.Tags() {
+		if strings.HasPrefix(tag, r.prefix) {
+			return tag
+		}
+	}
+	return ""
+}
+
+func (r *Reaper) alive(ctx context.Context, tag string, now time.Time) (bool, error) {
+	l, err := r.leases.Get(ctx, tag)
+	if errors.Is(err, lease.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get lease of %s: %w", tag, err)
+	}
+	return l.Alive(now), nil
+}
+
+// Reap destroys the orphaned servers, unless in dry run, and returns them.
+// It keeps going when a server can not be destroyed.
+func (r *Reaper) Reap(ctx context.Context) ([]Orphan, error) {
+	orphans, err := r.Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	errs := []error{}
+	for _, orphan := range orphans {
+		if r.dryRun {
+			log.Info("would destroy orphaned server", "server", orphan.Server.Name(), "tag", orphan.Tag, "reason", orphan.Reason)
+			continue
+		}
+		log.Info("destroying orphaned server", "server", orphan.Server.Name(), "tag", orphan.Tag, "reason", orphan.Reason)
+		if err := r.provider.DestroyServerByName(ctx, orphan.Server.Name()); err != nil {
+			log.Error("error occured when destroying orphaned server", "server", orphan.Server.Name(), "error", err.Error())
+			errs = append(errs, fmt.Errorf("failed to destroy %s: %w", orphan.Server.Name(), err))
+		}
+	}
+	return orphans, errors.Join(errs...)
+}
@@ -0,0 +1,106 @@
+package reaper_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/dodetest"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/lease"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/api"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/reaper"
+)
+
+// newProvider creates one server per tag
+func newProvider(t *testing.T, tags ...string) *dodetest.Provider {
+	p := dodetest.NewProvider()
+	t.Cleanup(p.Close)
+	for _, tag := range tags {
+		if _, err := p.CreateServer(context.Background(), api.NewCreateServerOptions().WithName(tag+"-0").WithTag(tag)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return p
+}
+
+func names(orphans []reaper.Orphan) []string {
+	names := []string{}
+	for _, orphan := range orphans {
+		names = append(names, orphan.Server.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestReaperLeases(t *testing.T) {
+	ctx := context.Background()
+	store := lease.NewFileStore(t.TempDir())
+	for tag, expiresAt := range map[string]time.Time{
+		"dode-alive":   time.Now().Add(time.Hour),
+		"dode-expired": time.Now().Add(-time.Minute),
+	} {
+		if err := store.Renew(ctx, &lease.Lease{Tag: tag, ExpiresAt: expiresAt}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testcases := []struct {
+		name      string
+		dryRun    bool
+		destroyed int
+	}{
+		{"dry run", true, 0},
+		{"reap", false, 2},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newProvider(t, "dode-alive", "dode-expired", "dode-crashed", "other")
+			r := reaper.New(p).WithPrefix("dode-").WithLeases(store).WithDryRun(tc.dryRun)
+
+			orphans, err := r.Reap(ctx)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := names(orphans); len(got) != 2 || got[0] != "dode-crashed-0" || got[1] != "dode-expired-0" {
+				t.Errorf("unexpected orphans %v", got)
+			}
+			if p.NumDestroyed() != tc.destroyed {
+				t.Errorf("expected %d servers to be destroyed, got %d", tc.destroyed, p.NumDestroyed())
+			}
+		})
+	}
+}
+
+func TestReaperTTL(t *testing.T) {
+	p := newProvider(t, "dode-old", "other")
+	time.Sleep(20 * time.Millisecond)
+	if _, err := p.CreateServer(context.Background(), api.NewCreateServerOptions().WithName("dode-new-0").WithTag("dode-new")); err != nil {
+		t.Fatal(err)
+	}
+
+	orphans, err := reaper.New(p).WithPrefix("dode-").WithTTL(10 * time.Millisecond).Reap(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := names(orphans); len(got) != 1 || got[0] != "dode-old-0" {
+		t.Errorf("unexpected orphans %v", got)
+	}
+	if len(p.Instances()) != 2 {
+		t.Errorf("expected 2 servers to be left, got %d", len(p.Instances()))
+	}
+}
+
+func TestReaperRequiresCriteria(t *testing.T) {
+	p := newProvider(t, "dode-0")
+	for _, r := range []*reaper.Reaper{
+		reaper.New(p).WithTTL(time.Minute),
+		reaper.New(p).WithPrefix("dode-"),
+	} {
+		if _, err := r.Reap(context.Background()); err == nil {
+			t.Errorf("expected error")
+		}
+	}
+	if p.NumDestroyed() != 0 {
+		t.Errorf("expected no server to be destroyed")
+	}
+}
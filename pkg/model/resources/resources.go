@@ -0,0 +1,117 @@
+// Package resources describes what a task needs and what a server offers, so
+// that several tasks can be packed onto one server.
+package resources
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Resources are amounts of CPU, memory and bandwidth. A zero amount is not
+// required by a task, or unknown for a server, and never limits packing.
+type Resources struct {
+	CPUs          float64 `json:"cpus" yaml:"cpus"`
+	MemoryMB      int     `json:"memory_mb" yaml:"memory_mb"`
+	BandwidthMbps int     `json:"bandwidth_mbps" yaml:"bandwidth_mbps"`
+}
+
+func (r Resources) IsZero() bool {
+	return r == Resources{}
+}
+
+func (r Resources) Add(other Resources) Resources {
+	return Resources{
+		CPUs:          r.CPUs + other.CPUs,
+		MemoryMB:      r.MemoryMB + other.MemoryMB,
+		BandwidthMbps: r.BandwidthMbps + other.BandwidthMbps,
+	}
+}
+
+func (r Resources) Sub(other Resources) Resources {
+	return Resources{
+		CPUs:          r.CPUs - other.CPUs,
+		MemoryMB:      r.MemoryMB - other.MemoryMB,
+		BandwidthMbps: r.BandwidthMbps - other.BandwidthMbps,
+	}
+}
+
+// Or fills the zero amounts of r with the ones of fallback.
+func (r Resources) Or(fallback Resources) Resources {
+	if r.CPUs == 0 {
+		r.CPUs = fallback.CPUs
+	}
+	if r.MemoryMB == 0 {
+		r.MemoryMB = fallback.MemoryMB
+	}
+	if r.BandwidthMbps == 0 {
+		r.BandwidthMbps = fallback.BandwidthMbps
+	}
+	return r
+}
+
+// Fits reports whether r is within capacity, unknown amounts of the capacity
+// are not checked.
+func (r Resources) Fits(capacity Resources) bool {
+	if capacity.CPUs > 0 && r.CPUs > capacity.CPUs {
+		return false
+	}
+	if capacity.MemoryMB > 0 && r.MemoryMB > capacity.MemoryMB {
+		return false
+	}
+	if capacity.BandwidthMbps > 0 && r.BandwidthMbps > capacity.BandwidthMbps {
+		return false
+	}
+	return true
+}
+
+func (r Resources) String() string {
+	parts := []string{}
+	if r.CPUs > 0 {
+		parts = append(parts, fmt.Sprintf("%g vCPUs", r.CPUs))
+	}
+	if r.MemoryMB > 0 {
+		parts = append(parts, fmt.Sprintf("%d MB", r.MemoryMB))
+	}
+	if r.BandwidthMbps > 0 {
+		parts = append(parts, fmt.Sprintf("%d Mbps", r.BandwidthMbps))
+	}
+	if len(parts) == 0 {
+		return "unknown"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Sizes are the resources of common server sizes, for providers which do not
+// report them. Bandwidth is not guaranteed by any of them.
+var Sizes = map[string]Resources{
+	// DigitalOcean
+	"s-1vcpu-512mb-10gb": {CPUs: 1, MemoryMB: 512},
+	"s-1vcpu-1gb":        {CPUs: 1, MemoryMB: 1024},
+	"s-1vcpu-2gb":        {CPUs: 1, MemoryMB: 2048},
+	"s-2vcpu-2gb":        {CPUs: 2, MemoryMB: 2048},
+	"s-2vcpu-4gb":        {CPUs: 2, MemoryMB: 4096},
+	"s-4vcpu-8gb":        {CPUs: 4, MemoryMB: 8192},
+	"s-8vcpu-16gb":       {CPUs: 8, MemoryMB: 16384},
+	"c-2":                {CPUs: 2, MemoryMB: 4096},
+	"c-4":                {CPUs: 4, MemoryMB: 8192},
+	// Hetzner Cloud
+	"cx22":  {CPUs: 2, MemoryMB: 4096},
+	"cx32":  {CPUs: 4, MemoryMB: 8192},
+	"cx42":  {CPUs: 8, MemoryMB: 16384},
+	"cpx11": {CPUs: 2, MemoryMB: 2048},
+	"cpx21": {CPUs: 3, MemoryMB: 4096},
+	"cax11": {CPUs: 2, MemoryMB: 4096},
+	// AWS EC2
+	"t3.micro":  {CPUs: 2, MemoryMB: 1024},
+	"t3.small":  {CPUs: 2, MemoryMB: 2048},
+	"t3.medium": {CPUs: 2, MemoryMB: 4096},
+	"c6i.large": {CPUs: 2, MemoryMB: 4096},
+	// Alibaba Cloud ECS
+	"ecs.c6.large": {CPUs: 2, MemoryMB: 4096},
+	"ecs.g6.large": {CPUs: 2, MemoryMB: 8192},
+}
+
+// ForSize returns the resources of the size, zero if it is unknown.
+func ForSize(size string) Resources {
+	return Sizes[size]
+}
@@ -0,0 +1,47 @@
+package resources_test
+
+import (
+	"testing"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/resources"
+)
+
+func TestFits(t *testing.T) {
+	capacity := resources.Resources{CPUs: 2, MemoryMB: 4096}
+	testcases := []struct {
+		name     string
+		required resources.Resources
+		fits     bool
+	}{
+		{"nothing", resources.Resources{}, true},
+		{"exactly", resources.Resources{CPUs: 2, MemoryMB: 4096}, true},
+		{"too many cpus", resources.Resources{CPUs: 2.5}, false},
+		{"too much memory", resources.Resources{CPUs: 0.5, MemoryMB: 8192}, false},
+		{"unknown bandwidth", resources.Resources{BandwidthMbps: 1000}, true},
+	}
+	for _, tc := range testcases {
+		if fits := tc.required.Fits(capacity); fits != tc.fits {
+			t.Errorf("%s: expected fits to be %v", tc.name, tc.fits)
+		}
+	}
+
+	used := resources.Resources{CPUs: 0.5, MemoryMB: 1024}
+	for i := 0; i < 3; i++ {
+		used = used.Add(resources.Resources{CPUs: 0.5, MemoryMB: 1024})
+	}
+	if !used.Fits(capacity) || used.Add(resources.Resources{CPUs: 0.5}).Fits(capacity) {
+		t.Errorf("expected 4 tasks of 0.5 vCPUs to fill 2 vCPUs, used %s", used)
+	}
+}
+
+func TestOr(t *testing.T) {
+	reported := resources.Resources{MemoryMB: 2048}
+	capacity := resources.Resources{BandwidthMbps: 100}.Or(reported.Or(resources.ForSize("s-2vcpu-4gb")))
+	want := resources.Resources{CPUs: 2, MemoryMB: 2048, BandwidthMbps: 100}
+	if capacity != want {
+		t.Errorf("expected %s, got %s", want, capacity)
+	}
+	if !resources.ForSize("unknown").IsZero() {
+		t.Errorf("expected an unknown size to have no resources")
+	}
+}
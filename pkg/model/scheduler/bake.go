@@ -0,0 +1,81 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/api"
+	"github.com/charmbracelet/log"
+)
+
+// Bake creates the image named name from a server provisioned like the ones
+// of the scheduler, with the docker images already pulled, and makes the
+// servers boot from it. An existing image with the same name is reused, so
+// bump the name to bake again (e.g. zmap-v2).
+func (s *Scheduler) Bake(ctx context.Context, name string, images ...string) (string, error) {
+	baker, ok := s.provider.(provider.ImageBaker)
+	if !ok {
+		return "", errors.New("the provider does not bake images")
+	}
+	id, err := baker.FindImage(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to find image: %w", err)
+	}
+	if id == "" {
+		if err := s.ensureKey(ctx); err != nil {
+			return "", err
+		}
+		if id, err = s.bake(ctx, baker, name, images); err != nil {
+			return "", err
+		}
+	} else {
+		log.Info("reusing image", "name", name, "image_id", id)
+	}
+	s.cso.WithImage(id)
+	return id, nil
+}
+
+func (s *Scheduler) bake(ctx context.Context, baker provider.ImageBaker, name string, images []string) (string, error) {
+	// The server has its own tag, so that it is never assigned a task
+	cso := *s.cso
+	cso.Name = name
+	cso.Tag = name
+	cso.Volumes = nil
+	log.Info("baking image", "name", name, "images", images)
+	srv, err := s.provider.CreateServer(ctx, &cso)
+	if err != nil {
+		return "", fmt.Errorf("failed to create server to bake: %w", err)
+	}
+	defer func() {
+		cleanupCtx, cancel := api.CleanupContext(ctx)
+		defer cancel()
+		if err := s.provider.DestroyServerByTag(cleanupCtx, name); err != nil {
+			log.Error("error occured when destroying baking server", "name", name, "error", err.Error())
+		}
+	}()
+	e, err := s.newExecutor(srv)
+	if err != nil {
+		return "", err
+	}
+	if err := s.ready(ctx, e); err != nil {
+		return "", err
+	}
+	if err := e.Connect(); err != nil {
+		return "", err
+	}
+	defer e.Close()
+	for _, image := range images {
+		if _, stderr, err := e.RunCommand("docker pull " + image); err != nil {
+			return "", fmt.Errorf("failed to pull %s: %w: %s", image, err, stderr)
+		}
+		log.Info("image pulled", "image", image)
+	}
+	id, err := baker.SnapshotServer(ctx, srv, name, s.cso.PreferredRegions())
+	if err != nil {
+		return "", fmt.Errorf("failed to snapshot server: %w", err)
+	}
+	log.Info("image baked", "name", name, "image_id", id)
+	return id, nil
+}
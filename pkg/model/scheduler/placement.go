@@ -0,0 +1,207 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/resources"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/task"
+)
+
+// load is what the tasks placed on a server use
+type load struct {
+	numTasks int
+	// exclusive is set when a task requiring nothing has the server
+	exclusive bool
+	used      resources.Resources
+}
+
+// WithServerCapacity sets what the servers offer to the tasks. Its non-zero
+// amounts override the ones reported by the servers or looked up by size in
+// resources.Sizes, e.g. to limit the bandwidth.
+func (s *Scheduler) WithServerCapacity(capacity resources.Resources) *Scheduler {
+	s.capacity = capacity
+	return s
+}
+
+// capacityOf returns what the server offers to the tasks, zero if unknown.
+func (s *Scheduler) capacityOf(srv server.Server) resources.Resources {
+	reported := resources.Resources{}
+	if sized, ok := srv.(server.Sized); ok {
+		reported = sized.Capacity()
+	}
+	return s.capacity.Or(reported.Or(resources.ForSize(srv.Size())))
+}
+
+// requirements returns what the task needs, and false if it needs a server of
+// its own.
+func requirements(t task.TaskInterface) (resources.Resources, bool) {
+	if requirer, ok := t.(task.Requirer); ok {
+		r := requirer.Requirements()
+		return r, !r.IsZero()
+	}
+	return resources.Resources{}, false
+}
+
+// checkRequirements fails if the task does not fit on an empty server of the
+// preferred size, so that no server is created for nothing.
+func (s *Scheduler) checkRequirements(t task.TaskInterface) error {
+	r, shared := requirements(t)
+	capacity := s.capacity.Or(resources.ForSize(s.cso.Size))
+	if shared && !r.Fits(capacity) {
+		return fmt.Errorf("%s requires %s, more than the %s of a server", t.String(), r, capacity)
+	}
+	return nil
+}
+
+// place counts the task towards the load of the server if it fits there,
+// until unplace. Servers being created only take the task of the dispatcher
+// creating them. It returns the number of tasks already placed on the server.
+func (s *Scheduler) place(srv server.Server, t task.TaskInterface) (int, bool) {
+	s.serversMu.Lock()
+	defer s.serversMu.Unlock()
+	if s.creating[srv.Name()] {
+		return 0, false
+	}
+	return s.placeLocked(srv, t)
+}
+
+func (s *Scheduler) placeLocked(srv server.Server, t task.TaskInterface) (int, bool) {
+	l := s.loads[srv.ID()]
+	if l == nil {
+		l = &load{}
+	}
+	r, shared := requirements(t)
+	capacity := s.capacityOf(srv)
+	switch {
+	case l.numTasks == 0:
+		if shared && !r.Fits(capacity) {
+			return 0, false
+		}
+	case !shared || l.exclusive || capacity.IsZero():
+		// Tasks share a server only if they all fit its known capacity
+		return l.numTasks, false
+	case !l.used.Add(r).Fits(capacity):
+		return l.numTasks, false
+	}
+	numTasks := l.numTasks
+	s.addLoad(srv.ID(), t)
+	return numTasks, true
+}
+
+// occupy counts a task found running on the server towards its load, even if
+// it does not fit there.
+func (s *Scheduler) occupy(t task.TaskInterface, id string) {
+	s.serversMu.Lock()
+	defer s.serversMu.Unlock()
+	if _, ok := s.placed[t]; ok {
+		return
+	}
+	s.addLoad(id, t)
+}
+
+func (s *Scheduler) addLoad(id string, t task.TaskInterface) {
+	l := s.loads[id]
+	if l == nil {
+		l = &load{}
+		s.loads[id] = l
+	}
+	r, shared := requirements(t)
+	l.numTasks++
+	l.exclusive = l.exclusive || !shared
+	l.used = l.used.Add(r)
+	s.placed[t] = id
+}
+
+// unplace removes the task from the load of its server, e.g. once it is
+// finished. It is a no-op if the task is not placed.
+func (s *Scheduler) unplace(t task.TaskInterface) {
+	s.serversMu.Lock()
+	defer s.serversMu.Unlock()
+	id, ok := s.placed[t]
+	if !ok {
+		return
+	}
+	delete(s.placed, t)
+	l := s.loads[id]
+	l.numTasks--
+	if l.numTasks == 0 {
+		delete(s.loads, id)
+		return
+	}
+	r, shared := requirements(t)
+	l.used = l.used.Sub(r)
+	if !shared {
+		l.exclusive = false
+	}
+}
+
+// reserveServer returns the name of the server to create, empty if the max
+// concurrency is reached. The servers being created count towards the max
+// concurrency and the budget until created is called.
+func (s *Scheduler) reserveServer(servers []server.Server, price float64) (string, error) {
+	s.serversMu.Lock()
+	defer s.serversMu.Unlock()
+	numServers := len(s.creating)
+	names := map[string]bool{}
+	for name := range s.creating {
+		names[name] = true
+	}
+	for _, srv := range servers {
+		if !s.creating[srv.Name()] {
+			numServers++
+		}
+		names[srv.Name()] = true
+	}
+	if numServers >= s.maxConcurrency {
+		return "", nil
+	}
+	if err := s.costs.CheckN(s.budget, len(s.creating)+1, price); err != nil {
+		return "", err
+	}
+	for i := 0; ; i++ {
+		name := fmt.Sprintf("%s-%d", s.name, i)
+		if !names[name] {
+			s.creating[name] = true
+			return name, nil
+		}
+	}
+}
+
+// created ends the reservation of the server. If it has been created, it is
+// tracked and the task of the calling dispatcher is placed on it first.
+func (s *Scheduler) created(ctx context.Context, name string, srv server.Server, price float64, t task.TaskInterface) error {
+	s.serversMu.Lock()
+	defer s.serversMu.Unlock()
+	delete(s.creating, name)
+	if srv == nil {
+		return nil
+	}
+	s.track(ctx, srv, price)
+	if _, ok := s.placeLocked(srv, t); !ok {
+		r, _ := requirements(t)
+		return fmt.Errorf("%s requires %s, more than the %s of %s", t.String(), r, s.capacityOf(srv), srv.Name())
+	}
+	return nil
+}
+
+// wasReady reports whether the server passed the readiness probes in this run.
+func (s *Scheduler) wasReady(srv server.Server) bool {
+	s.serversMu.Lock()
+	defer s.serversMu.Unlock()
+	return s.seenReady[srv.ID()]
+}
+
+func (s *Scheduler) markReady(srv server.Server) {
+	s.serversMu.Lock()
+	defer s.serversMu.Unlock()
+	s.seenReady[srv.ID()] = true
+}
+
+// isCreating reports whether the server is still being created by a dispatcher.
+func (s *Scheduler) isCreating(srv server.Server) bool {
+	s.serversMu.Lock()
+	defer s.serversMu.Unlock()
+	return s.creating[srv.Name()]
+}
@@ -0,0 +1,150 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/cost"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/state"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/task"
+	"github.com/charmbracelet/log"
+)
+
+// job is a submitted task along with the context it was submitted with
+type job struct {
+	ctx context.Context
+	t   task.TaskInterface
+}
+
+// WithQueueSize sets how many submitted tasks wait for a dispatcher before
+// Submit blocks, the max concurrency by default.
+func (s *Scheduler) WithQueueSize(queueSize int) *Scheduler {
+	s.queueSize = queueSize
+	return s
+}
+
+// Submit queues the task, a dispatcher then runs it on an idle server or waits
+// for it if it is already running. It blocks while the queue is full, so that
+// a generator does not get ahead of the servers. The errors of the tasks are
+// reported by Wait, only cost.ErrBudgetExceeded is also returned by the next
+// calls to stop submitting. In plan mode, the task is planned right away.
+func (s *Scheduler) Submit(ctx context.Context, t task.TaskInterface) error {
+	log.Info("submitting task", "task", t.String())
+	if s.plan != nil {
+		return s.planTask(ctx, t)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	err := s.stopErr
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	queue := s.startDispatchers()
+	s.wg.Add(1)
+	select {
+	case queue <- job{ctx: ctx, t: t}:
+		return nil
+	case <-ctx.Done():
+		s.wg.Done()
+		return ctx.Err()
+	}
+}
+
+// startDispatchers starts one dispatcher per server the scheduler may run, so
+// that servers are created in parallel. It returns the queue they read.
+func (s *Scheduler) startDispatchers() chan job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.queue != nil {
+		return s.queue
+	}
+	numDispatchers := max(s.maxConcurrency, 1)
+	queueSize := s.queueSize
+	if queueSize <= 0 {
+		queueSize = numDispatchers
+	}
+	s.queue = make(chan job, queueSize)
+	for i := 0; i < numDispatchers; i++ {
+		s.dispatchers.Add(1)
+		go s.dispatch(s.queue)
+	}
+	return s.queue
+}
+
+// stopDispatchers closes the queue and waits for the dispatchers to return.
+func (s *Scheduler) stopDispatchers() {
+	s.mu.Lock()
+	queue := s.queue
+	s.queue = nil
+	s.stopErr = nil
+	s.mu.Unlock()
+	if queue != nil {
+		close(queue)
+	}
+	s.dispatchers.Wait()
+}
+
+func (s *Scheduler) dispatch(queue <-chan job) {
+	defer s.dispatchers.Done()
+	for j := range queue {
+		started, err := s.dispatchTask(j.ctx, j.t)
+		if err != nil {
+			s.fail(j.t, err)
+		}
+		if started {
+			// Wait task to finish, the dispatcher moves on to the next task
+			go s.WaitTask(j.ctx, j.t)
+			continue
+		}
+		s.wg.Done()
+	}
+}
+
+// dispatchTask runs the task unless it is already running or finished. It
+// reports whether the task has to be waited for.
+func (s *Scheduler) dispatchTask(ctx context.Context, t task.TaskInterface) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	// Check if the task is already assigned to a server
+	needRun, err := s.NeedRun(ctx, t)
+	if err != nil {
+		return false, err
+	}
+	if !needRun {
+		if s.finished(ctx, t) {
+			log.Info("task already finished", "task", t.String())
+			return false, nil
+		}
+		log.Warn("task already started", "task", t.String())
+		if id, ok := s.assignments.Load(t); ok {
+			s.occupy(t, id.(string))
+		}
+		return true, nil
+	}
+	// Now the task is pending state on any server
+	if err := s.run(ctx, t); err != nil {
+		s.record(ctx, t, func(r *state.Record) {
+			r.Status = state.StatusFailed
+			r.Error = err.Error()
+		})
+		return false, err
+	}
+	return true, nil
+}
+
+// fail records the error of the task for Wait. Once the budget is exceeded,
+// Submit refuses new tasks.
+func (s *Scheduler) fail(t task.TaskInterface, err error) {
+	log.Error("task failed", "task", t.String(), "error", err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errs = append(s.errs, fmt.Errorf("%s: %w", t.String(), err))
+	if errors.Is(err, cost.ErrBudgetExceeded) && s.stopErr == nil {
+		s.stopErr = err
+	}
+}
@@ -1,15 +1,29 @@
 package scheduler
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
+	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/cost"
 	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/executor/secureshell"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/keys"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/lease"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/plan"
 	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider"
 	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/api"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/readiness"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/resources"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/state"
 	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/task"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/util/egress"
 	"github.com/charmbracelet/log"
 )
 
@@ -21,8 +35,42 @@ type Scheduler struct {
 	cso                  *api.CreateServerOptions
 	wg                   *sync.WaitGroup
 	destroyAfterFinished bool
+	pollInterval         time.Duration
+	assignments          sync.Map // task.TaskInterface -> server ID
+	mu                   sync.Mutex
+	errs                 []error
+	budget               *cost.Budget
+	pricer               cost.Pricer
+	costs                *cost.Tracker
+	firewall             *api.FirewallOptions
+	firewallMu           sync.Mutex // held while creating the firewall, unlike mu
+	firewallReady        bool
+	readiness            *readiness.Checker
+	leases               lease.Store
+	leaseTTL             time.Duration
+	leaseMu              sync.Mutex // held while acquiring the lease
+	stopLease            context.CancelFunc
+	plan                 *plan.Plan
+	keys                 *keys.Manager
+	keyMu                sync.Mutex // held while creating the key pair
+	key                  *keys.Key
+	state                state.Store
+	queue                chan job
+	queueSize            int
+	dispatchers          *sync.WaitGroup
+	stopErr              error
+	serversMu            sync.Mutex
+	loads                map[string]*load              // server ID -> the tasks placed on it
+	placed               map[task.TaskInterface]string // task -> the server ID it is placed on
+	creating             map[string]bool               // server name -> the server is being created
+	seenReady            map[string]bool               // server ID -> the server passed the probes in this run
+	capacity             resources.Resources
 }
 
+// maxUnhealthyServers is the number of servers in a row which may fail the
+// readiness probes before giving up
+const maxUnhealthyServers = 3
+
 func New(name string) *Scheduler {
 	return &Scheduler{
 		name:                 name,
@@ -30,7 +78,15 @@ func New(name string) *Scheduler {
 		maxConcurrency:       1,
 		cso:                  &api.CreateServerOptions{},
 		wg:                   &sync.WaitGroup{},
+		dispatchers:          &sync.WaitGroup{},
+		loads:                make(map[string]*load),
+		placed:               make(map[task.TaskInterface]string),
+		creating:             make(map[string]bool),
+		seenReady:            make(map[string]bool),
 		destroyAfterFinished: true,
+		pollInterval:         5 * time.Second,
+		costs:                cost.NewTracker(),
+		readiness:            readiness.NewChecker(),
 	}
 }
 
@@ -49,21 +105,426 @@ func (s *Scheduler) WithMaxConcurrency(maxConcurrency int) *Scheduler {
 	return s
 }
 
+// WithPollInterval sets the delay between retries and task status checks,
+// including the attempts of the readiness probes
+func (s *Scheduler) WithPollInterval(pollInterval time.Duration) *Scheduler {
+	s.pollInterval = pollInterval
+	s.readiness.WithInterval(pollInterval)
+	return s
+}
+
+// WithReadiness sets the probes a server has to pass before running tasks,
+// servers which do not pass them are destroyed and replaced.
+func (s *Scheduler) WithReadiness(checker *readiness.Checker) *Scheduler {
+	s.readiness = checker
+	return s
+}
+
 func (s *Scheduler) WithProvider(provider provider.CloudServiceProvider) *Scheduler {
 	s.provider = provider
+	s.wrapProvider()
+	return s
+}
+
+// WithPlan switches the scheduler to plan mode: every create, destroy and
+// command is recorded in the plan instead of being made. Only the status of
+// the tasks on existing servers is checked, to tell which ones would be
+// skipped.
+func (s *Scheduler) WithPlan(p *plan.Plan) *Scheduler {
+	s.plan = p
+	s.wrapProvider()
+	return s
+}
+
+// wrapProvider wraps the provider in a plan provider in plan mode.
+func (s *Scheduler) wrapProvider() {
+	if s.plan == nil || s.provider == nil {
+		return
+	}
+	if _, ok := s.provider.(*plan.Provider); !ok {
+		s.provider = plan.NewProvider(s.provider, s.plan)
+	}
+}
+
+// WithFirewall locks the servers down behind a cloud firewall bound to the
+// tag, which is destroyed along with the servers.
+func (s *Scheduler) WithFirewall(fo *api.FirewallOptions) *Scheduler {
+	s.firewall = fo
+	return s
+}
+
+// WithLease holds the lease of the tag in the store while the scheduler runs,
+// renewing it before the TTL expires (5 minutes if not positive). The reaper
+// destroys the servers of tags without a live lease, e.g. after the
+// controller crashed. Servers kept with WithDestroyAfterFinished(false) get a
+// kept lease, which never expires.
+func (s *Scheduler) WithLease(store lease.Store, ttl time.Duration) *Scheduler {
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	s.leases = store
+	s.leaseTTL = ttl
+	return s
+}
+
+// WithEphemeralKey makes the scheduler create a key pair for its tag before
+// creating the first server, instead of the key pair of the create server
+// options, and delete it once the servers are destroyed.
+func (s *Scheduler) WithEphemeralKey(m *keys.Manager) *Scheduler {
+	s.keys = m
+	return s
+}
+
+// WithState records the state of the tasks in the store. Submitted tasks are
+// then resumed from their records instead of probing every server: finished
+// tasks are skipped and running ones are waited for on their server.
+func (s *Scheduler) WithState(store state.Store) *Scheduler {
+	s.state = store
+	return s
+}
+
+// WithBudget refuses to create servers once the projected spend of the fleet
+// would exceed the budget.
+func (s *Scheduler) WithBudget(budget *cost.Budget) *Scheduler {
+	s.budget = budget
+	return s
+}
+
+// WithPricer sets the prices of servers, by default the provider is used if
+// it has a pricing API.
+func (s *Scheduler) WithPricer(pricer cost.Pricer) *Scheduler {
+	s.pricer = pricer
 	return s
 }
 
-func (s *Scheduler) FindOrCreateAnIdleExecutor() (*secureshell.SSHExecutor, error) {
+// CostReport returns the cost of every server used so far.
+func (s *Scheduler) CostReport() *cost.Report {
+	return s.costs.Report()
+}
+
+// hourlyPrice returns the price of a server, zero if it is unknown and no
+// budget in dollars is set.
+func (s *Scheduler) hourlyPrice(ctx context.Context, region, size string) (float64, error) {
+	pricer := s.pricer
+	if pricer == nil {
+		pricer, _ = s.provider.(cost.Pricer)
+	}
+	needed := s.budget != nil && s.budget.Dollars > 0
+	if pricer == nil {
+		if needed {
+			return 0, errors.New("a budget in dollars requires the prices of servers")
+		}
+		return 0, nil
+	}
+	price, err := pricer.HourlyPrice(ctx, region, size)
+	if err != nil {
+		if needed {
+			return 0, fmt.Errorf("failed to get server price: %w", err)
+		}
+		log.Warn("failed to get server price", "region", region, "size", size, "error", err)
+		return 0, nil
+	}
+	return price, nil
+}
+
+// worstPrice returns the highest price among the regions and sizes the
+// provider may fall back to, so that the budget holds whichever one the
+// server ends up with. Fallbacks without a price are left out.
+func (s *Scheduler) worstPrice(ctx context.Context, preferredPrice float64) float64 {
+	worst := preferredPrice
+	for _, placement := range s.cso.Placements() {
+		if placement.Region == s.cso.Region && placement.Size == s.cso.Size {
+			continue
+		}
+		price, err := s.hourlyPrice(ctx, placement.Region, placement.Size)
+		if err != nil {
+			log.Warn("failed to price fallback, leaving it out of the budget", "region", placement.Region, "size", placement.Size, "error", err)
+			continue
+		}
+		worst = max(worst, price)
+	}
+	return worst
+}
+
+// track accounts for the cost of the server, priced by the region and size it
+// ended up with if known, by the preferred price otherwise. Without a price
+// table, the price reported by the cloud is preferred.
+func (s *Scheduler) track(ctx context.Context, srv server.Server, preferredPrice float64) {
+	region, size, price := s.cso.Region, s.cso.Size, preferredPrice
+	if srv.Region() != "" && srv.Size() != "" {
+		region, size = srv.Region(), srv.Size()
+	}
+	switch {
+	case s.pricer == nil && srv.HourlyPrice() > 0:
+		price = srv.HourlyPrice()
+	case region != s.cso.Region || size != s.cso.Size:
+		var err error
+		if price, err = s.hourlyPrice(ctx, region, size); err != nil {
+			log.Error("failed to get server price, using the preferred one", "server", srv.Name(), "error", err)
+			price = preferredPrice
+		}
+	}
+	s.costs.Start(srv.ID(), srv.Name(), region, size, price)
+}
+
+// newExecutor creates an executor for the server, honoring the SSH settings
+// of servers which implement server.SSHEndpoint
+func (s *Scheduler) newExecutor(srv server.Server) (*secureshell.SSHExecutor, error) {
+	if _, ok := srv.(*plan.Server); ok {
+		return s.recordingExecutor(srv), nil
+	}
+	ip, err := srv.IPv4()
+	if err != nil {
+		return nil, err
+	}
+	e := secureshell.NewSSHExecutor().
+		WithIP(ip).
+		WithPrivateKeyPath(s.cso.PrivateKeyPath)
+	if endpoint, ok := srv.(server.SSHEndpoint); ok {
+		e.WithPort(endpoint.SSHPort()).WithUser(endpoint.SSHUser())
+		if endpoint.SSHPrivateKeyPath() != "" {
+			e.WithPrivateKeyPath(endpoint.SSHPrivateKeyPath())
+		}
+	}
+	return e, nil
+}
+
+// recordingExecutor creates an executor recording its calls in the plan.
+func (s *Scheduler) recordingExecutor(srv server.Server) *secureshell.SSHExecutor {
+	return secureshell.NewSSHExecutor().WithRecorder(func(kind, detail string) {
+		s.plan.Record(kind, srv.Name(), detail)
+	})
+}
+
+// sleep waits for the poll interval, returning early with an error if ctx is done
+func (s *Scheduler) sleep(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(s.pollInterval):
+		return nil
+	}
+}
+
+func (s *Scheduler) FindOrCreateAnIdleExecutor(ctx context.Context) (*secureshell.SSHExecutor, error) {
+	_, e, err := s.findOrCreateAnIdleServer(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.unplace(nil)
+	return e, nil
+}
+
+// ensureFirewall creates the firewall of the tag once, allowing SSH from the
+// egress IP of the controller unless SSH sources are given.
+func (s *Scheduler) ensureFirewall(ctx context.Context) error {
+	if s.firewall == nil {
+		return nil
+	}
+	s.firewallMu.Lock()
+	defer s.firewallMu.Unlock()
+	if s.firewallReady {
+		return nil
+	}
+	manager, ok := s.provider.(provider.FirewallManager)
+	if !ok {
+		return errors.New("the provider does not manage firewalls")
+	}
+	fo := *s.firewall
+	if len(fo.SSHSources) == 0 {
+		ip, err := egress.IPv4(ctx, egress.DefaultEndpoint)
+		if err != nil {
+			return err
+		}
+		fo.SSHSources = []string{ip + "/32"}
+	}
+	if err := manager.CreateFirewall(ctx, s.tag, &fo); err != nil {
+		return fmt.Errorf("failed to create firewall: %w", err)
+	}
+	log.Info("firewall ready", "tag", s.tag, "ssh_sources", fo.SSHSources)
+	s.firewallReady = true
+	return nil
+}
+
+// ensureKey creates the key pair of the tag once and makes the servers
+// authorize it.
+func (s *Scheduler) ensureKey(ctx context.Context) error {
+	if s.keys == nil || s.plan != nil {
+		return nil
+	}
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+	if s.key != nil {
+		return nil
+	}
+	key, err := s.keys.Create(ctx, s.tag)
+	if err != nil {
+		return fmt.Errorf("failed to create key pair: %w", err)
+	}
+	s.cso.WithPrivateKeyPath(key.PrivateKeyPath).WithPublicKeyPath(key.PublicKeyPath).WithPublicKeyName(key.Name)
+	s.key = key
+	return nil
+}
+
+// deleteKey deletes the key pair of the tag, once no server authorizes it.
+func (s *Scheduler) deleteKey(ctx context.Context) error {
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+	if s.key == nil {
+		return nil
+	}
+	if err := s.keys.Delete(ctx, s.key); err != nil {
+		return err
+	}
+	s.key = nil
+	return nil
+}
+
+// ensureLease acquires the lease of the tag once and keeps renewing it until
+// Wait.
+func (s *Scheduler) ensureLease(ctx context.Context) error {
+	if s.leases == nil || s.plan != nil {
+		return nil
+	}
+	s.leaseMu.Lock()
+	defer s.leaseMu.Unlock()
+	if s.stopLease != nil {
+		return nil
+	}
+	renew := func(ctx context.Context) error {
+		return s.leases.Renew(ctx, &lease.Lease{Tag: s.tag, Holder: lease.Holder(), ExpiresAt: time.Now().Add(s.leaseTTL)})
+	}
+	if err := renew(ctx); err != nil {
+		return fmt.Errorf("failed to acquire lease: %w", err)
+	}
+	leaseCtx, cancel := context.WithCancel(context.Background())
+	s.stopLease = cancel
+	go func() {
+		ticker := time.NewTicker(s.leaseTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-leaseCtx.Done():
+				return
+			case <-ticker.C:
+				if err := renew(leaseCtx); err != nil && leaseCtx.Err() == nil {
+					log.Error("error occured when renewing lease", "tag", s.tag, "error", err.Error())
+				}
+			}
+		}
+	}()
+	log.Info("lease acquired", "tag", s.tag, "ttl", s.leaseTTL)
+	return nil
+}
+
+// releaseLease stops renewing the lease, and releases it if the servers are
+// gone. The servers of an interrupted run are left to the reaper once the
+// lease expires after its TTL, unless the run is resumed.
+func (s *Scheduler) releaseLease(ctx context.Context, destroyed bool) error {
+	s.leaseMu.Lock()
+	defer s.leaseMu.Unlock()
+	if s.stopLease == nil {
+		return nil
+	}
+	s.stopLease()
+	s.stopLease = nil
+	if !destroyed {
+		return nil
+	}
+	if err := s.leases.Release(ctx, s.tag); err != nil {
+		return fmt.Errorf("failed to release lease: %w", err)
+	}
+	return nil
+}
+
+// keepLease stops renewing the lease, and marks the servers as kept so that
+// the reaper leaves them alone.
+func (s *Scheduler) keepLease(ctx context.Context) error {
+	s.leaseMu.Lock()
+	defer s.leaseMu.Unlock()
+	if s.stopLease == nil {
+		return nil
+	}
+	s.stopLease()
+	s.stopLease = nil
+	if err := s.leases.Renew(ctx, &lease.Lease{Tag: s.tag, Holder: lease.Holder(), Kept: true}); err != nil {
+		return fmt.Errorf("failed to keep lease: %w", err)
+	}
+	log.Info("servers kept, the reaper will skip them", "tag", s.tag)
+	return nil
+}
+
+// findOrCreateAnIdleServer returns a server with room for the task, which is
+// placed on it until unplace.
+func (s *Scheduler) findOrCreateAnIdleServer(ctx context.Context, t task.TaskInterface) (server.Server, *secureshell.SSHExecutor, error) {
+	if err := s.checkRequirements(t); err != nil {
+		return nil, nil, err
+	}
+	if err := s.ensureLease(ctx); err != nil {
+		return nil, nil, err
+	}
+	if err := s.ensureFirewall(ctx); err != nil {
+		return nil, nil, err
+	}
+	if err := s.ensureKey(ctx); err != nil {
+		return nil, nil, err
+	}
+	price, err := s.hourlyPrice(ctx, s.cso.Region, s.cso.Size)
+	if err != nil {
+		return nil, nil, err
+	}
+	worstPrice := s.worstPrice(ctx, price)
+	numUnhealthy := 0
+	unhealthy := func(srv server.Server, err error) error {
+		// Leased hosts are not ours to destroy, they are probed again later
+		leaser, leased := s.provider.(provider.HostLeaser)
+		leased = leased && leaser.LeasesHosts()
+		if leased {
+			log.Warn("host is unhealthy, keeping it", "server", srv.Name(), "error", err)
+		} else {
+			s.replace(ctx, srv, err)
+		}
+		s.unplace(t)
+		numUnhealthy++
+		if numUnhealthy >= maxUnhealthyServers {
+			return fmt.Errorf("%d servers in a row are unhealthy: %w", numUnhealthy, err)
+		}
+		if leased {
+			return s.sleep(ctx)
+		}
+		return nil
+	}
 	for {
 		// Check if there is an idle server
-		for _, server := range s.provider.ListServersByTag(s.tag) {
-			e := secureshell.NewSSHExecutor().
-				WithIP(server.IPv4()).
-				WithPrivateKeyPath(s.cso.PrivateKeyPath)
-			err := e.Connect()
+		servers, err := s.provider.ListServersByTag(ctx, s.tag)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list servers: %w", err)
+		}
+		// Account for servers left by a previous run and reclaimed ones, the
+		// ones being created are accounted for by their dispatcher
+		ids := []string{}
+		for _, server := range servers {
+			ids = append(ids, server.ID())
+			if !s.isCreating(server) {
+				s.track(ctx, server, price)
+			}
+		}
+		s.costs.StopMissing(ids...)
+		for _, server := range servers {
+			numTasks, ok := s.place(server, t)
+			if !ok {
+				continue
+			}
+			e, err := s.newExecutor(server)
+			if err != nil {
+				log.Error("failed to create executor", "server", server.Name(), "error", err)
+				s.unplace(t)
+				continue
+			}
+			err = e.Connect()
 			if err != nil {
 				log.Error("failed to connect to server", "error", err)
+				s.unplace(t)
 				continue
 			}
 			stdout, _, err := e.RunCommand(strings.Join([]string{
@@ -75,44 +536,103 @@ func (s *Scheduler) FindOrCreateAnIdleExecutor() (*secureshell.SSHExecutor, erro
 			}, " "))
 			if err != nil {
 				log.Error("failed to run command", "error", err)
-				time.Sleep(5 * time.Second)
+				s.unplace(t)
+				if err := s.sleep(ctx); err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			if len(strings.Fields(stdout)) > numTasks {
+				// The server runs containers which are not placed, e.g. by
+				// a previous run
+				s.unplace(t)
 				continue
 			}
-			log.Warn("find an idle server", "server", server.IPv4())
-			if strings.TrimSpace(stdout) == "" {
-				return e, nil
+			log.Warn("find an idle server", "server", server.Name(), "tasks", numTasks)
+			if numTasks > 0 || s.wasReady(server) {
+				// The server already passed the probes in this run
+				return server, e, nil
 			}
+			// The server may still be provisioning, e.g. after a restart
+			if err := s.ready(ctx, e); err != nil {
+				if ctx.Err() != nil {
+					s.unplace(t)
+					return nil, nil, err
+				}
+				if err := unhealthy(server, err); err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			s.markReady(server)
+			return server, e, nil
 		}
 		// Check if the number of servers is less than max concurrency
-		servers := s.provider.ListServersByTag(s.tag)
-		if len(servers) < s.maxConcurrency {
+		name, err := s.reserveServer(servers, worstPrice)
+		if err != nil {
+			log.Error("refusing to create server", "error", err)
+			return nil, nil, err
+		}
+		if name != "" {
 			// Create a new server
-			log.Info("create a new server because of no idle server and not reach max concurrency")
-			server, err := s.provider.CreateServer(
-				s.cso.WithName(fmt.Sprintf("%s-%d", s.name, len(servers))).WithTag(s.tag),
-			)
+			log.Info("create a new server because of no idle server and not reach max concurrency", "server", name)
+			cso := *s.cso
+			cso.WithName(name).WithTag(s.tag)
+			server, err := s.provider.CreateServer(ctx, &cso)
 			if err != nil {
+				s.created(ctx, name, nil, price, t)
 				log.Error("failed to create server", "error", err)
-				return nil, fmt.Errorf("failed to create server: %s", err.Error())
+				return nil, nil, fmt.Errorf("failed to create server: %w", err)
+			}
+			if err := s.created(ctx, name, server, price, t); err != nil {
+				return nil, nil, err
+			}
+			e, err := s.newExecutor(server)
+			if err == nil {
+				err = s.ready(ctx, e)
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					s.unplace(t)
+					return nil, nil, err
+				}
+				if err := unhealthy(server, err); err != nil {
+					return nil, nil, err
+				}
+				continue
 			}
-			log.Warn("sleep 5 seconds to avoid digital ocean firewall", "server", server.IPv4())
-			time.Sleep(5 * time.Second)
-			return secureshell.NewSSHExecutor().
-				WithIP(server.IPv4()).
-				WithPrivateKeyPath(s.cso.PrivateKeyPath), nil
+			s.markReady(server)
+			egressIP, _ := server.EgressIPv4()
+			log.Info("server is ready", "server", server.Name(), "egress_ip", egressIP)
+			return server, e, nil
+		}
+		if err := s.sleep(ctx); err != nil {
+			return nil, nil, err
 		}
-		time.Sleep(5 * time.Second)
 	}
 }
 
-// A task is marked NeedRun if and only if it is not in [task.RUNNING, task.FINISHED] on any listed servers
-func (s *Scheduler) NeedRun(t task.TaskInterface) bool {
-	for _, server := range s.provider.ListServersByTag(s.tag) {
-		log.Info("check task status", "task", t, "server", server.IPv4())
-		e := secureshell.NewSSHExecutor().
-			WithIP(server.IPv4()).
-			WithPrivateKeyPath(s.cso.PrivateKeyPath)
-		err := t.Assign(e)
+// A task is marked NeedRun if and only if it is not in [task.RUNNING, task.FINISHED] on any listed servers,
+// or according to its recorded state if any
+func (s *Scheduler) NeedRun(ctx context.Context, t task.TaskInterface) (bool, error) {
+	if s.state != nil {
+		return s.needRunFromState(ctx, t)
+	}
+	servers, err := s.provider.ListServersByTag(ctx, s.tag)
+	if err != nil {
+		return false, fmt.Errorf("failed to list servers: %w", err)
+	}
+	for _, server := range servers {
+		if _, ok := server.(*plan.Server); ok {
+			continue
+		}
+		log.Info("check task status", "task", t, "server", server.Name())
+		e, err := s.newExecutor(server)
+		if err != nil {
+			log.Error("failed to create executor", "server", server.Name(), "error", err)
+			continue
+		}
+		err = t.Assign(e)
 		if err != nil {
 			log.Error("failed to assign task to executor", "error", err)
 			continue
@@ -123,28 +643,171 @@ func (s *Scheduler) NeedRun(t task.TaskInterface) bool {
 			continue
 		}
 		if status.GetStatus() == task.RUNNING || status.GetStatus() == task.FINISHED {
-			return false
+			s.assignments.Store(t, server.ID())
+			return false, nil
 		}
 	}
-	return true
+	return true, nil
 }
 
-func (s *Scheduler) Submit(t task.TaskInterface) error {
-	log.Info("submitting task", "task", t.String())
-	s.wg.Add(1)
-	// Check if the task is already assigned to a server
-	if !s.NeedRun(t) {
-		log.Warn("task already started", t)
-		// Wait task to finish
-		go s.WaitTask(t)
+// needRunFromState tells from the record of the task whether it needs to run.
+// Only the server a running task was started on is connected to.
+func (s *Scheduler) needRunFromState(ctx context.Context, t task.TaskInterface) (bool, error) {
+	r, err := s.state.Get(ctx, s.tag, t.String())
+	if errors.Is(err, state.ErrNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get task state: %w", err)
+	}
+	switch r.Status {
+	case state.StatusFinished:
+		s.assignments.Store(t, r.ServerID)
+		return false, nil
+	case state.StatusRunning:
+		servers, err := s.provider.ListServersByTag(ctx, s.tag)
+		if err != nil {
+			return false, fmt.Errorf("failed to list servers: %w", err)
+		}
+		for _, server := range servers {
+			if server.ID() != r.ServerID {
+				continue
+			}
+			e, err := s.newExecutor(server)
+			if err == nil {
+				err = t.Assign(e)
+			}
+			if err != nil {
+				log.Error("failed to assign task to executor", "server", server.Name(), "error", err)
+				return true, nil
+			}
+			s.assignments.Store(t, server.ID())
+			return false, nil
+		}
+		log.Warn("the server of the task is gone, running it again", "task", t.String(), "server", r.Server)
+	}
+	return true, nil
+}
+
+// finished reports whether the task is recorded as finished, its output has
+// then already been downloaded.
+func (s *Scheduler) finished(ctx context.Context, t task.TaskInterface) bool {
+	if s.state == nil {
+		return false
+	}
+	r, err := s.state.Get(ctx, s.tag, t.String())
+	return err == nil && r.Status == state.StatusFinished
+}
+
+// record updates the recorded state of the task. A failure is only logged, at
+// worst the task is run again when the job is resumed.
+func (s *Scheduler) record(ctx context.Context, t task.TaskInterface, update func(*state.Record)) {
+	if s.state == nil || s.plan != nil {
+		return
+	}
+	now := time.Now()
+	r, err := s.state.Get(ctx, s.tag, t.String())
+	if errors.Is(err, state.ErrNotFound) {
+		r, err = &state.Record{Job: s.tag, TaskID: t.String(), CreatedAt: now}, nil
+	}
+	if err != nil {
+		log.Error("error occured when getting task state", "task", t.String(), "error", err.Error())
+		return
+	}
+	update(r)
+	r.UpdatedAt = now
+	if err := s.state.Put(ctx, r); err != nil {
+		log.Error("error occured when recording task state", "task", t.String(), "error", err.Error())
+	}
+}
+
+// planTask records the server the task would run on and the commands it
+// would run there.
+func (s *Scheduler) planTask(ctx context.Context, t task.TaskInterface) error {
+	needRun, err := s.NeedRun(ctx, t)
+	if err != nil {
+		return err
+	}
+	if !needRun {
+		id, _ := s.assignments.Load(t)
+		s.plan.Skip(t.String(), fmt.Sprint(id))
 		return nil
 	}
-	// Now the task is pending state on any server
+	srv, err := s.planServer(ctx)
+	if err != nil {
+		return err
+	}
+	s.plan.Assign(t.String(), srv.Name())
+	e := s.recordingExecutor(srv)
+	if err := t.Assign(e); err != nil {
+		return err
+	}
+	if err := t.Prepare(); err != nil {
+		return err
+	}
+	return t.Start()
+}
+
+// planServer returns the server the next task would run on: a new one while
+// there are less servers than the max concurrency, then the server with the
+// fewest tasks.
+func (s *Scheduler) planServer(ctx context.Context) (server.Server, error) {
+	if err := s.ensureFirewall(ctx); err != nil {
+		return nil, err
+	}
+	servers, err := s.provider.ListServersByTag(ctx, s.tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list servers: %w", err)
+	}
+	if len(servers) >= s.maxConcurrency && len(servers) > 0 {
+		numTasks := map[string]int{}
+		for _, a := range s.plan.Assignments() {
+			if !a.Done {
+				numTasks[a.Server]++
+			}
+		}
+		least := servers[0]
+		for _, srv := range servers[1:] {
+			if numTasks[srv.Name()] < numTasks[least.Name()] {
+				least = srv
+			}
+		}
+		return least, nil
+	}
+	srv, err := s.provider.CreateServer(ctx, s.cso.WithName(fmt.Sprintf("%s-%d", s.name, len(servers))).WithTag(s.tag))
+	if err != nil {
+		return nil, err
+	}
+	if planned, ok := srv.(*plan.Server); ok && s.pricer != nil {
+		price, err := s.hourlyPrice(ctx, srv.Region(), srv.Size())
+		if err != nil {
+			return nil, err
+		}
+		planned.WithHourlyPrice(price)
+	}
+	return srv, nil
+}
+
+// Plan returns the plan filled in plan mode, nil otherwise.
+func (s *Scheduler) Plan() *plan.Plan {
+	return s.plan
+}
+
+// run starts the task on an idle server
+func (s *Scheduler) run(ctx context.Context, t task.TaskInterface) error {
 	// Find or create an idle server
-	e, err := s.FindOrCreateAnIdleExecutor()
+	server, e, err := s.findOrCreateAnIdleServer(ctx, t)
 	if err != nil {
 		return err
 	}
+	started := false
+	defer func() {
+		// The task only stays placed on the server once started
+		if !started {
+			s.unplace(t)
+		}
+	}()
+	s.assignments.Store(t, server.ID())
 	// Assign the task to the server (executer)
 	err = t.Assign(e)
 	if err != nil {
@@ -155,7 +818,9 @@ func (s *Scheduler) Submit(t task.TaskInterface) error {
 		err := t.Prepare()
 		if err != nil {
 			log.Error("prepare failed", "error", err)
-			time.Sleep(5 * time.Second)
+			if err := s.sleep(ctx); err != nil {
+				return err
+			}
 			continue
 		}
 		log.Info("prepare succeed")
@@ -166,52 +831,194 @@ func (s *Scheduler) Submit(t task.TaskInterface) error {
 		err := t.Start()
 		if err != nil {
 			log.Error("start failed", "error", err)
-			time.Sleep(5 * time.Second)
+			if err := s.sleep(ctx); err != nil {
+				return err
+			}
 			continue
 		}
 		log.Info("start succeed")
 		break
 	}
-	// Wait task to finish
-	go s.WaitTask(t)
+	started = true
+	s.record(ctx, t, func(r *state.Record) {
+		r.Server, r.ServerID, r.ContainerID = server.Name(), server.ID(), ""
+		if c, ok := t.(task.Container); ok {
+			r.ContainerID = c.ContainerID()
+		}
+		r.Attempts++
+		r.Status = state.StatusRunning
+		r.Error = ""
+		r.StartedAt = time.Now()
+	})
 	return nil
 }
 
-func (s *Scheduler) WaitTask(t task.TaskInterface) {
+// ready probes the server until it is able to run tasks.
+func (s *Scheduler) ready(ctx context.Context, e *secureshell.SSHExecutor) error {
+	if e.Recorder != nil {
+		return nil
+	}
+	config, err := e.GetConfig()
+	if err != nil {
+		return err
+	}
+	return s.readiness.Check(ctx, net.JoinHostPort(e.IP, strconv.Itoa(e.Port)), config)
+}
+
+// replace destroys an unhealthy server, a new one is created in its place.
+func (s *Scheduler) replace(ctx context.Context, srv server.Server, reason error) {
+	log.Warn("server is unhealthy, replacing it", "server", srv.Name(), "error", reason)
+	cleanupCtx, cancel := api.CleanupContext(ctx)
+	defer cancel()
+	if err := s.provider.DestroyServerByName(cleanupCtx, srv.Name()); err != nil {
+		log.Error("error occured when destroying unhealthy server", "server", srv.Name(), "error", err.Error())
+		return
+	}
+	s.costs.Stop(srv.ID())
+}
+
+// interrupted reports whether the server the task was assigned to has been
+// reclaimed by the cloud (e.g. a spot instance)
+func (s *Scheduler) interrupted(ctx context.Context, t task.TaskInterface) bool {
+	interruptible, ok := s.provider.(provider.Interruptible)
+	if !ok {
+		return false
+	}
+	id, ok := s.assignments.Load(t)
+	if !ok {
+		return false
+	}
+	servers, err := interruptible.ListInterruptedServersByTag(ctx, s.tag)
+	if err != nil {
+		log.Error("failed to list interrupted servers", "error", err)
+		return false
+	}
+	for _, server := range servers {
+		if server.ID() == id {
+			return true
+		}
+	}
+	return false
+}
+
+// WaitTask waits for the task to finish and downloads its output. It gives
+// up when ctx is done, the error is then reported by Wait.
+func (s *Scheduler) WaitTask(ctx context.Context, t task.TaskInterface) {
 	defer s.wg.Done()
+	defer s.unplace(t)
+	if err := s.waitTask(ctx, t); err != nil {
+		log.Error("task abandoned", "task", t.String(), "error", err)
+		s.mu.Lock()
+		s.errs = append(s.errs, fmt.Errorf("%s: %w", t.String(), err))
+		s.mu.Unlock()
+	}
+}
 
+func (s *Scheduler) waitTask(ctx context.Context, t task.TaskInterface) error {
 	for {
 		// Wait task status become task.FINISHED
 		status, err := t.Status()
 		if err != nil {
 			log.Error("task status failed", "error", err)
-			time.Sleep(5 * time.Second)
+			if s.interrupted(ctx, t) {
+				// Run the task again on another server
+				log.Warn("server has been reclaimed, rescheduling task", "task", t.String())
+				s.unplace(t)
+				if err := s.run(ctx, t); err != nil {
+					log.Error("reschedule failed", "error", err)
+				}
+			}
+			if err := s.sleep(ctx); err != nil {
+				return err
+			}
 			continue
 		}
 		log.Debug("waiting task", "status", status, "task", t.String())
 		if status.GetStatus() == task.FINISHED {
+			// The server has room for another task while downloading
+			s.unplace(t)
 			break
 		}
-		time.Sleep(5 * time.Second)
+		if err := s.sleep(ctx); err != nil {
+			return err
+		}
 	}
 	for {
 		// Download task output files
 		err := t.Download()
 		if err != nil {
 			log.Error("task output download failed", "error", err)
-			time.Sleep(5 * time.Second)
+			if err := s.sleep(ctx); err != nil {
+				return err
+			}
 			continue
 		}
 		log.Info("task output download succeed")
-		break
+		s.record(ctx, t, func(r *state.Record) {
+			if c, ok := t.(task.Container); ok && c.ContainerID() != "" {
+				r.ContainerID = c.ContainerID()
+			}
+			r.Status = state.StatusFinished
+			r.FinishedAt = time.Now()
+		})
+		return nil
 	}
 }
 
-func (s *Scheduler) Wait() {
+// Wait waits for every submitted task, then destroys the servers if
+// configured to. If ctx is done first, the servers are kept so that the run
+// can be resumed. Tasks must not be submitted while waiting.
+func (s *Scheduler) Wait(ctx context.Context) error {
 	// Wait for all tasks to complete
 	s.wg.Wait()
+	s.stopDispatchers()
+	s.mu.Lock()
+	err := errors.Join(s.errs...)
+	s.errs = nil
+	s.mu.Unlock()
+	if ctx.Err() != nil {
+		log.Warn("keeping servers because the run was interrupted", "tag", s.tag)
+		s.printCostReport()
+		return errors.Join(err, ctx.Err(), s.releaseLease(ctx, false))
+	}
+	if !s.destroyAfterFinished {
+		err = errors.Join(err, s.keepLease(ctx))
+		s.printCostReport()
+		return err
+	}
 	// Destroy all servers
-	if s.destroyAfterFinished {
-		s.provider.DestroyServerByTag(s.tag)
+	destroyed := false
+	if destroyErr := s.provider.DestroyServerByTag(ctx, s.tag); destroyErr != nil {
+		err = errors.Join(err, fmt.Errorf("failed to destroy servers: %w", destroyErr))
+	} else {
+		destroyed = true
+		s.costs.StopAll()
+		err = errors.Join(err, s.destroyFirewall(ctx), s.deleteKey(ctx))
+	}
+	err = errors.Join(err, s.releaseLease(ctx, destroyed))
+	s.printCostReport()
+	return err
+}
+
+func (s *Scheduler) destroyFirewall(ctx context.Context) error {
+	manager, ok := s.provider.(provider.FirewallManager)
+	if s.firewall == nil || !ok {
+		return nil
+	}
+	s.firewallMu.Lock()
+	defer s.firewallMu.Unlock()
+	if err := manager.DestroyFirewall(ctx, s.tag); err != nil {
+		return fmt.Errorf("failed to destroy firewall: %w", err)
+	}
+	s.firewallReady = false
+	return nil
+}
+
+func (s *Scheduler) printCostReport() {
+	report := s.costs.Report()
+	if len(report.Servers) == 0 {
+		return
 	}
+	log.Info("cost report", "servers", len(report.Servers), "server_hours", fmt.Sprintf("%.2f", report.ServerHours), "dollars", fmt.Sprintf("%.2f", report.Dollars))
+	fmt.Fprintln(os.Stderr, report.String())
 }
@@ -0,0 +1,926 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/dodetest"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/cost"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/executor/secureshell"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/keys"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/lease"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/plan"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/api"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/readiness"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/reaper"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/resources"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/scheduler"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/server"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/state"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/task"
+)
+
+type sleepStatus task.TaskStatus
+
+func (s sleepStatus) String() string             { return fmt.Sprintf("%d", s) }
+func (s sleepStatus) GetStatus() task.TaskStatus { return task.TaskStatus(s) }
+func (s sleepStatus) NumTotal() int64            { return 1 }
+func (s sleepStatus) NumDoneWithSuccess() int64  { return 0 }
+func (s sleepStatus) NumDoneWithError() int64    { return 0 }
+
+// sleepTask runs a container labeled with the scheduler tag and its index
+type sleepTask struct {
+	e           *secureshell.SSHExecutor
+	label       string
+	index       int
+	mu          sync.Mutex
+	downloaded  bool
+	containerID string
+	// requirements of zero make the task run alone on its server
+	requirements resources.Resources
+}
+
+func (s *sleepTask) String() string {
+	return fmt.Sprintf("sleep-%d", s.index)
+}
+
+func (s *sleepTask) Assign(e *secureshell.SSHExecutor) error {
+	s.e = e
+	return s.e.Connect()
+}
+
+func (s *sleepTask) Prepare() error {
+	_, _, err := s.e.RunCommand("docker pull busybox")
+	return err
+}
+
+func (s *sleepTask) Start() error {
+	stdout, _, err := s.e.RunCommand(fmt.Sprintf(
+		"docker run --detach --label task.label=%s --label task.index=%d busybox sleep 1",
+		s.label, s.index,
+	))
+	s.containerID = strings.TrimSpace(stdout)
+	return err
+}
+
+func (s *sleepTask) ContainerID() string {
+	return s.containerID
+}
+
+func (s *sleepTask) Requirements() resources.Resources {
+	return s.requirements
+}
+
+func (s *sleepTask) Stop() error {
+	return nil
+}
+
+func (s *sleepTask) Status() (task.StatusInterface, error) {
+	stdout, _, err := s.e.RunCommand(fmt.Sprintf(
+		"docker ps --all --quiet --filter label=task.label=%s --filter label=task.index=%d",
+		s.label, s.index,
+	))
+	if err != nil {
+		return nil, err
+	}
+	id := strings.TrimSpace(stdout)
+	if id == "" {
+		return sleepStatus(task.PENDING), nil
+	}
+	stdout, _, err = s.e.RunCommand("docker inspect --format {{.State.Running}} " + id)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(stdout) == "true" {
+		return sleepStatus(task.RUNNING), nil
+	}
+	return sleepStatus(task.FINISHED), nil
+}
+
+func (s *sleepTask) Download() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.downloaded = true
+	return nil
+}
+
+func newScheduler(t *testing.T, name string, p *dodetest.Provider, options ...func(*api.CreateServerOptions)) *scheduler.Scheduler {
+	key, err := dodetest.WritePrivateKey(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+	cso := api.NewCreateServerOptions().WithPrivateKeyPath(key)
+	for _, option := range options {
+		option(cso)
+	}
+	return scheduler.New(name).
+		WithProvider(p).
+		WithCreateServerOptions(cso).
+		WithPollInterval(10 * time.Millisecond)
+}
+
+// waitFor polls until cond holds, as tasks are dispatched in the background
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the dispatchers")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// numContainers returns the number of containers started on the servers
+func numContainers(p *dodetest.Provider) int {
+	n := 0
+	for _, instance := range p.Instances() {
+		n += len(instance.Server.Docker.Containers())
+	}
+	return n
+}
+
+func TestSchedulerRunsAllTasks(t *testing.T) {
+	ctx := context.Background()
+	p := dodetest.NewProvider().WithServerSetup(func(s *dodetest.Server) {
+		s.Docker.WithRunDuration(50 * time.Millisecond)
+	})
+	s := newScheduler(t, "sleep", p).WithMaxConcurrency(2)
+
+	tasks := []*sleepTask{}
+	for i := 0; i < 5; i++ {
+		tasks = append(tasks, &sleepTask{label: "sleep", index: i})
+	}
+	for _, task := range tasks {
+		if err := s.Submit(ctx, task); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := s.Wait(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, task := range tasks {
+		if !task.downloaded {
+			t.Errorf("expected %s to be downloaded", task)
+		}
+	}
+	if p.NumCreated() != 2 {
+		t.Errorf("expected 2 servers to be created, got %d", p.NumCreated())
+	}
+	if len(p.Instances()) != 0 {
+		t.Errorf("expected all servers to be destroyed, got %d", len(p.Instances()))
+	}
+}
+
+func TestSchedulerSkipsStartedTasks(t *testing.T) {
+	ctx := context.Background()
+	p := dodetest.NewProvider().WithServerSetup(func(s *dodetest.Server) {
+		s.Docker.WithRunDuration(50 * time.Millisecond)
+	})
+	s := newScheduler(t, "resume", p).WithDestroyAfterFinished(false)
+
+	first := &sleepTask{label: "resume", index: 0}
+	if err := s.Submit(ctx, first); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Wait(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Submitting the same task again must not start a second container
+	again := &sleepTask{label: "resume", index: 0}
+	if err := s.Submit(ctx, again); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Wait(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	instances := p.Instances()
+	if len(instances) != 1 {
+		t.Fatalf("expected 1 server, got %d", len(instances))
+	}
+	if n := len(instances[0].Server.Docker.Containers()); n != 1 {
+		t.Errorf("expected 1 container, got %d", n)
+	}
+	if !again.downloaded {
+		t.Errorf("expected finished task to be downloaded")
+	}
+}
+
+func TestSchedulerCreateFailure(t *testing.T) {
+	ctx := context.Background()
+	p := dodetest.NewProvider().WithCreateFailures(1).WithServerSetup(func(s *dodetest.Server) {
+		s.Docker.WithRunDuration(10 * time.Millisecond)
+		s.WithLatency(5 * time.Millisecond)
+	})
+	s := newScheduler(t, "failure", p)
+
+	failed := &sleepTask{label: "failure", index: 0}
+	task := &sleepTask{label: "failure", index: 1}
+	for _, task := range []*sleepTask{failed, task} {
+		if err := s.Submit(ctx, task); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := s.Wait(ctx); err == nil || !strings.Contains(err.Error(), "sleep-0: failed to create server") {
+		t.Fatalf("expected error when the server can not be created, got %v", err)
+	}
+	if failed.downloaded || !task.downloaded {
+		t.Errorf("expected only the second task to be downloaded")
+	}
+}
+
+func TestSchedulerReschedulesInterruptedTasks(t *testing.T) {
+	ctx := context.Background()
+	numServers := 0
+	p := dodetest.NewProvider().WithServerSetup(func(s *dodetest.Server) {
+		// The container on the first server never finishes
+		numServers++
+		if numServers > 1 {
+			s.Docker.WithRunDuration(20 * time.Millisecond)
+		}
+	})
+	s := newScheduler(t, "spot", p)
+
+	task := &sleepTask{label: "spot", index: 0}
+	if err := s.Submit(ctx, task); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitFor(t, func() bool { return numContainers(p) == 1 })
+	p.Interrupt(p.Instances()[0].ID())
+	if err := s.Wait(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !task.downloaded {
+		t.Errorf("expected task to be downloaded")
+	}
+	if p.NumCreated() != 2 {
+		t.Errorf("expected a second server to be created, got %d", p.NumCreated())
+	}
+}
+
+func TestSchedulerCancellation(t *testing.T) {
+	// The container never finishes
+	p := dodetest.NewProvider()
+	s := newScheduler(t, "cancel", p)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	task := &sleepTask{label: "cancel", index: 0}
+	if err := s.Submit(ctx, task); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitFor(t, func() bool { return numContainers(p) == 1 })
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if err := s.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation error, got %v", err)
+	}
+	if task.downloaded {
+		t.Errorf("expected unfinished task not to be downloaded")
+	}
+	// The servers are kept to resume the run later
+	if len(p.Instances()) != 1 {
+		t.Errorf("expected the server to be kept, got %d", len(p.Instances()))
+	}
+}
+
+// concurrentCreates records how many servers are created at the same time
+type concurrentCreates struct {
+	*dodetest.Provider
+	mu       sync.Mutex
+	inFlight int
+	max      int
+}
+
+func (c *concurrentCreates) CreateServer(ctx context.Context, cso *api.CreateServerOptions) (server.Server, error) {
+	c.mu.Lock()
+	c.inFlight++
+	c.max = max(c.max, c.inFlight)
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.inFlight--
+		c.mu.Unlock()
+	}()
+	return c.Provider.CreateServer(ctx, cso)
+}
+
+func (c *concurrentCreates) Max() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.max
+}
+
+func TestSchedulerQueue(t *testing.T) {
+	// The containers never finish, every server runs a single task
+	p := dodetest.NewProvider().WithCreateLatency(50 * time.Millisecond)
+	creates := &concurrentCreates{Provider: p}
+	s := newScheduler(t, "queue", p).
+		WithProvider(creates).
+		WithMaxConcurrency(2).
+		WithQueueSize(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.Submit(ctx, &sleepTask{label: "queue", index: 0}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.NumCreated() != 0 {
+		t.Errorf("expected Submit not to wait for a server")
+	}
+	// Two tasks run, two more wait for an idle server in the dispatchers and
+	// the last one in the queue
+	for i := 1; i < 5; i++ {
+		if err := s.Submit(ctx, &sleepTask{label: "queue", index: i}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	submitCtx, cancelSubmit := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancelSubmit()
+	if err := s.Submit(submitCtx, &sleepTask{label: "queue", index: 5}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected Submit to block while the queue is full, got %v", err)
+	}
+	if p.NumCreated() != 2 || creates.Max() != 2 {
+		t.Errorf("expected 2 servers to be created in parallel, got %d created and at most %d at once", p.NumCreated(), creates.Max())
+	}
+	cancel()
+	if err := s.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation error, got %v", err)
+	}
+	if numContainers(p) != 2 {
+		t.Errorf("expected 2 tasks to be started, got %d", numContainers(p))
+	}
+}
+
+func TestSchedulerPacking(t *testing.T) {
+	// The containers never finish until told to
+	p := dodetest.NewProvider()
+	s := newScheduler(t, "packing", p, func(cso *api.CreateServerOptions) {
+		cso.WithSize("s-2vcpu-4gb")
+	}).WithMaxConcurrency(2)
+
+	ctx := context.Background()
+	tasks := []*sleepTask{}
+	for i := 0; i < 10; i++ {
+		tasks = append(tasks, &sleepTask{label: "packing", index: i, requirements: resources.Resources{CPUs: 0.5, MemoryMB: 512}})
+		if err := s.Submit(ctx, tasks[i]); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// 4 tasks fit the 2 vCPUs of each server, the others wait for room
+	waitFor(t, func() bool { return numContainers(p) == 8 })
+	time.Sleep(50 * time.Millisecond)
+	if n := numContainers(p); n != 8 || p.NumCreated() != 2 {
+		t.Errorf("expected 8 tasks to be packed onto 2 servers, got %d on %d", n, p.NumCreated())
+	}
+	for _, instance := range p.Instances() {
+		instance.Server.Docker.FinishAll()
+		instance.Server.Docker.WithRunDuration(10 * time.Millisecond)
+	}
+	if err := s.Wait(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, task := range tasks {
+		if !task.downloaded {
+			t.Errorf("expected %s to be downloaded", task)
+		}
+	}
+	if p.NumCreated() != 2 {
+		t.Errorf("expected the servers to be reused, got %d created", p.NumCreated())
+	}
+}
+
+func TestSchedulerPackingTooLarge(t *testing.T) {
+	p := dodetest.NewProvider()
+	s := newScheduler(t, "large", p, func(cso *api.CreateServerOptions) {
+		cso.WithSize("s-1vcpu-1gb")
+	}).WithServerCapacity(resources.Resources{BandwidthMbps: 100})
+
+	ctx := context.Background()
+	if err := s.Submit(ctx, &sleepTask{label: "large", index: 0, requirements: resources.Resources{CPUs: 0.5, BandwidthMbps: 1000}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Wait(ctx); err == nil || !strings.Contains(err.Error(), "requires") {
+		t.Fatalf("expected error when the task does not fit a server, got %v", err)
+	}
+	if p.NumCreated() != 0 {
+		t.Errorf("expected no server to be created, got %d", p.NumCreated())
+	}
+}
+
+func TestSchedulerCreateTimeout(t *testing.T) {
+	p := dodetest.NewProvider().WithCreateLatency(time.Hour)
+	s := newScheduler(t, "timeout", p, func(cso *api.CreateServerOptions) {
+		cso.WithCreateTimeout(50 * time.Millisecond)
+	})
+
+	ctx := context.Background()
+	if err := s.Submit(ctx, &sleepTask{label: "timeout", index: 0}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+}
+
+func TestSchedulerWaitsForCloudInit(t *testing.T) {
+	p := dodetest.NewProvider().WithServerSetup(func(s *dodetest.Server) {
+		s.WithCloudInit(100*time.Millisecond, "done")
+		s.Docker.WithRunDuration(10 * time.Millisecond)
+	})
+	s := newScheduler(t, "cloud-init", p, func(cso *api.CreateServerOptions) {
+		cso.WithProvisioningScripts("../../../assets/scripts/ubuntu-22-04-x64/add-swap.sh")
+	})
+
+	ctx := context.Background()
+	task := &sleepTask{label: "cloud-init", index: 0}
+	if err := s.Submit(ctx, task); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitFor(t, func() bool { return numContainers(p) == 1 })
+	instance := p.Instances()[0]
+	if !strings.Contains(instance.UserData, "swapon") {
+		t.Errorf("expected the provisioning script in the user data, got %q", instance.UserData)
+	}
+	commands := instance.Server.Commands()
+	waited := false
+	for _, command := range commands {
+		if command == "cloud-init status --wait" {
+			waited = true
+		}
+		if strings.HasPrefix(command, "docker pull") && !waited {
+			t.Fatalf("task prepared before cloud-init finished: %v", commands)
+		}
+	}
+	if err := s.Wait(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSchedulerCloudInitFailure(t *testing.T) {
+	p := dodetest.NewProvider().WithServerSetup(func(s *dodetest.Server) {
+		s.WithCloudInit(0, "error")
+	})
+	s := newScheduler(t, "cloud-init-failure", p)
+
+	ctx := context.Background()
+	if err := s.Submit(ctx, &sleepTask{label: "cloud-init-failure", index: 0}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Wait(ctx); err == nil {
+		t.Fatalf("expected error when cloud-init failed")
+	}
+	// Each server is replaced until giving up
+	if p.NumCreated() != 3 || len(p.Instances()) != 0 {
+		t.Errorf("expected 3 servers to be tried and destroyed, %d created and %d left", p.NumCreated(), len(p.Instances()))
+	}
+}
+
+func TestSchedulerProbesServersOnce(t *testing.T) {
+	p := dodetest.NewProvider().WithServerSetup(func(s *dodetest.Server) {
+		s.WithCloudInit(0, "done")
+		s.Docker.WithRunDuration(10 * time.Millisecond)
+	})
+	s := newScheduler(t, "probe-once", p)
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if err := s.Submit(ctx, &sleepTask{label: "probe-once", index: i}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	waitFor(t, func() bool { return numContainers(p) == 3 })
+	probes := 0
+	for _, command := range p.Instances()[0].Server.Commands() {
+		if command == "cloud-init status --wait" {
+			probes++
+		}
+	}
+	if p.NumCreated() != 1 || probes != 1 {
+		t.Errorf("expected the server to be probed once, %d created and %d probes", p.NumCreated(), probes)
+	}
+	if err := s.Wait(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// leased makes the hosts of the provider leased rather than created
+type leased struct {
+	*dodetest.Provider
+}
+
+func (leased) LeasesHosts() bool {
+	return true
+}
+
+func TestSchedulerKeepsUnhealthyLeasedHosts(t *testing.T) {
+	p := dodetest.NewProvider().WithServerSetup(func(s *dodetest.Server) {
+		s.WithCloudInit(0, "error")
+	})
+	s := newScheduler(t, "leased", p).
+		WithProvider(leased{p}).
+		WithDestroyAfterFinished(false)
+
+	ctx := context.Background()
+	if err := s.Submit(ctx, &sleepTask{label: "leased", index: 0}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Wait(ctx); err == nil {
+		t.Fatalf("expected error when cloud-init failed")
+	}
+	if p.NumCreated() != 1 || p.NumDestroyed() != 0 {
+		t.Errorf("expected the leased host to be kept, %d created and %d destroyed", p.NumCreated(), p.NumDestroyed())
+	}
+}
+
+func TestSchedulerReplacesUnhealthyServers(t *testing.T) {
+	ctx := context.Background()
+	numServers := 0
+	p := dodetest.NewProvider().WithServerSetup(func(s *dodetest.Server) {
+		// The docker daemon of the first server never starts
+		numServers++
+		if numServers == 1 {
+			s.Handle("docker", func(args []string, stdout, stderr io.Writer) int {
+				return 1
+			})
+		}
+		s.Docker.WithRunDuration(10 * time.Millisecond)
+	})
+	s := newScheduler(t, "unhealthy", p).
+		WithReadiness(readiness.NewChecker().WithDockerTimeout(50 * time.Millisecond).WithInterval(10 * time.Millisecond))
+
+	task := &sleepTask{label: "unhealthy", index: 0}
+	if err := s.Submit(ctx, task); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitFor(t, func() bool { return numContainers(p) == 1 })
+	if p.NumCreated() != 2 || p.NumDestroyed() != 1 {
+		t.Errorf("expected the unhealthy server to be replaced, %d created and %d destroyed", p.NumCreated(), p.NumDestroyed())
+	}
+	if err := s.Wait(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !task.downloaded {
+		t.Errorf("expected task to be downloaded")
+	}
+}
+
+func TestSchedulerBudget(t *testing.T) {
+	prices := cost.PriceTable{"s-1vcpu-1gb": 0.5, "s-2vcpu-2gb": 1}
+	fallback := []string{"s-1vcpu-1gb", "s-2vcpu-2gb"}
+	testcases := []struct {
+		name       string
+		budget     *cost.Budget
+		sizes      []string
+		prices     cost.PriceTable
+		numServers int
+	}{
+		// Each server is projected to run for an hour at $0.5
+		{"dollars", cost.NewBudget().WithDollars(1.2), nil, prices, 2},
+		{"server-hours", cost.NewBudget().WithServerHours(1.5), nil, prices, 1},
+		// The server being created is projected at the $1 of the fallback size
+		{"fallback size", cost.NewBudget().WithDollars(1.2), fallback, prices, 1},
+		// A fallback size without a price is left out of the budget
+		{"unpriced fallback size", cost.NewBudget().WithDollars(1.2), fallback, cost.PriceTable{"s-1vcpu-1gb": 0.5}, 2},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			// The containers never finish, every task needs a new server
+			p := dodetest.NewProvider()
+			s := newScheduler(t, "budget", p, func(cso *api.CreateServerOptions) {
+				cso.WithSize("s-1vcpu-1gb").WithSizes(tc.sizes...)
+			}).
+				WithMaxConcurrency(10).
+				WithBudget(tc.budget).
+				WithPricer(tc.prices)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			var err error
+			for i := 0; err == nil && i < 100; i++ {
+				err = s.Submit(ctx, &sleepTask{label: "budget", index: i})
+			}
+			if !errors.Is(err, cost.ErrBudgetExceeded) {
+				t.Fatalf("expected budget error, got %v", err)
+			}
+			// The servers reserved before the budget was exceeded are still created
+			waitFor(t, func() bool { return numContainers(p) == tc.numServers })
+			cancel()
+			if err := s.Wait(ctx); !errors.Is(err, cost.ErrBudgetExceeded) {
+				t.Errorf("expected the budget error to be reported, got %v", err)
+			}
+			if p.NumCreated() != tc.numServers {
+				t.Errorf("expected %d servers, got %d", tc.numServers, p.NumCreated())
+			}
+			report := s.CostReport()
+			if len(report.Servers) != tc.numServers || report.Servers[0].HourlyPrice != 0.5 {
+				t.Errorf("unexpected cost report %v", report.Servers)
+			}
+		})
+	}
+}
+
+func TestSchedulerFirewall(t *testing.T) {
+	ctx := context.Background()
+	p := dodetest.NewProvider().WithServerSetup(func(s *dodetest.Server) {
+		s.Docker.WithRunDuration(10 * time.Millisecond)
+	})
+	s := newScheduler(t, "firewall", p).
+		WithFirewall(api.NewFirewallOptions().WithSSHSources("127.0.0.1/32").WithInboundRule("tcp", "80"))
+
+	if err := s.Submit(ctx, &sleepTask{label: "firewall", index: 0}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitFor(t, func() bool { return numContainers(p) == 1 })
+	if !p.Instances()[0].Firewalled {
+		t.Errorf("expected the server to be created behind the firewall")
+	}
+	if fo := p.Firewall("firewall"); fo == nil || len(fo.Rules()) != 2 {
+		t.Errorf("unexpected firewall %+v", fo)
+	}
+	if err := s.Wait(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.Firewall("firewall") != nil {
+		t.Errorf("expected the firewall to be destroyed with the servers")
+	}
+}
+
+// unmanaged hides the firewall management of the provider
+type unmanaged struct {
+	provider.CloudServiceProvider
+}
+
+func TestSchedulerFirewallUnsupported(t *testing.T) {
+	p := dodetest.NewProvider()
+	s := newScheduler(t, "unmanaged", p).
+		WithProvider(unmanaged{p}).
+		WithFirewall(api.NewFirewallOptions().WithSSHSources("127.0.0.1/32"))
+
+	ctx := context.Background()
+	if err := s.Submit(ctx, &sleepTask{label: "unmanaged", index: 0}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Wait(ctx); err == nil {
+		t.Fatalf("expected error when the provider can not lock the servers down")
+	}
+	if p.NumCreated() != 0 {
+		t.Errorf("expected no server to be created, got %d", p.NumCreated())
+	}
+}
+
+func TestSchedulerBake(t *testing.T) {
+	ctx := context.Background()
+	p := dodetest.NewProvider().WithServerSetup(func(s *dodetest.Server) {
+		s.Docker.WithRunDuration(10 * time.Millisecond)
+	})
+	s := newScheduler(t, "bake", p)
+
+	id, err := s.Bake(ctx, "bake-v1", "amazon/aws-cli", "busybox")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.NumCreated() != 1 || len(p.Instances()) != 0 {
+		t.Errorf("expected the baking server to be destroyed, %d created and %d left", p.NumCreated(), len(p.Instances()))
+	}
+	// The image is reused
+	if again, err := s.Bake(ctx, "bake-v1", "amazon/aws-cli", "busybox"); err != nil || again != id {
+		t.Fatalf("expected image %s to be reused, got %s: %v", id, again, err)
+	}
+	if p.NumCreated() != 1 {
+		t.Errorf("expected no server to bake again, got %d", p.NumCreated())
+	}
+
+	if err := s.Submit(ctx, &sleepTask{label: "bake", index: 0}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitFor(t, func() bool { return numContainers(p) == 1 })
+	instance := p.Instances()[0]
+	if instance.Image() != id {
+		t.Errorf("expected the server to boot from %s, got %s", id, instance.Image())
+	}
+	if images := instance.Server.Docker.Images(); len(images) != 2 {
+		t.Errorf("expected the images to be cached, got %v", images)
+	}
+	if err := s.Wait(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSchedulerLease(t *testing.T) {
+	ctx := context.Background()
+	p := dodetest.NewProvider().WithServerSetup(func(s *dodetest.Server) {
+		s.Docker.WithRunDuration(10 * time.Millisecond)
+	})
+	store := lease.NewFileStore(t.TempDir())
+	s := newScheduler(t, "leased", p).WithLease(store, time.Minute)
+
+	if err := s.Submit(ctx, &sleepTask{label: "leased", index: 0}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitFor(t, func() bool { return numContainers(p) == 1 })
+	l, err := store.Get(ctx, "leased")
+	if err != nil || !l.Alive(time.Now()) {
+		t.Fatalf("expected a live lease while running, got %+v (%v)", l, err)
+	}
+	if orphans, err := reaper.New(p).WithPrefix("leased").WithLeases(store).Find(ctx); err != nil || len(orphans) != 0 {
+		t.Errorf("expected the running servers not to be orphaned, got %d (%v)", len(orphans), err)
+	}
+	if err := s.Wait(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := store.Get(ctx, "leased"); !errors.Is(err, lease.ErrNotFound) {
+		t.Errorf("expected the lease to be released with the servers, got %v", err)
+	}
+}
+
+func TestSchedulerLeaseKept(t *testing.T) {
+	ctx := context.Background()
+	p := dodetest.NewProvider().WithServerSetup(func(s *dodetest.Server) {
+		s.Docker.WithRunDuration(10 * time.Millisecond)
+	})
+	store := lease.NewFileStore(t.TempDir())
+	s := newScheduler(t, "kept", p).WithLease(store, time.Minute).WithDestroyAfterFinished(false)
+
+	if err := s.Submit(ctx, &sleepTask{label: "kept", index: 0}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Wait(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	l, err := store.Get(ctx, "kept")
+	if err != nil || !l.Kept || !l.Alive(time.Now().Add(time.Hour)) {
+		t.Fatalf("expected the lease to be kept with the servers, got %+v (%v)", l, err)
+	}
+	if orphans, err := reaper.New(p).WithPrefix("kept").WithLeases(store).Find(ctx); err != nil || len(orphans) != 0 {
+		t.Errorf("expected the kept servers not to be orphaned, got %d (%v)", len(orphans), err)
+	}
+}
+
+func TestSchedulerEphemeralKey(t *testing.T) {
+	ctx := context.Background()
+	p := dodetest.NewProvider().WithServerSetup(func(s *dodetest.Server) {
+		s.Docker.WithRunDuration(10 * time.Millisecond)
+	})
+	s := newScheduler(t, "ephemeral", p).WithEphemeralKey(keys.NewManager(p, t.TempDir()))
+
+	if err := s.Submit(ctx, &sleepTask{label: "ephemeral", index: 0}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitFor(t, func() bool { return numContainers(p) == 1 })
+	if _, ok := p.KeyPairs()["ephemeral"]; !ok {
+		t.Errorf("expected the key pair to be registered while running")
+	}
+	if name := p.Instances()[0].KeyName; name != "ephemeral" {
+		t.Errorf("expected the server to authorize the ephemeral key pair, got %q", name)
+	}
+	if err := s.Wait(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := p.KeyPairs()["ephemeral"]; ok {
+		t.Errorf("expected the key pair to be deleted with the servers")
+	}
+}
+
+func TestSchedulerState(t *testing.T) {
+	// The containers never finish until told to
+	p := dodetest.NewProvider()
+	store, err := state.OpenBoltStore(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	s := newScheduler(t, "state", p).WithState(store).WithMaxConcurrency(2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	for i := 0; i < 2; i++ {
+		if err := s.Submit(ctx, &sleepTask{label: "state", index: i}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	waitFor(t, func() bool {
+		records, _ := store.List(context.Background(), "state")
+		return len(records) == 2
+	})
+	cancel()
+	if err := s.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation error, got %v", err)
+	}
+	r, err := store.Get(context.Background(), "state", "sleep-0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.Status != state.StatusRunning || r.Attempts != 1 || r.ContainerID == "" || r.ServerID != p.Instances()[0].ID() || r.StartedAt.IsZero() {
+		t.Errorf("unexpected record of a running task %+v", r)
+	}
+
+	// The controller restarts once the containers finished
+	for _, instance := range p.Instances() {
+		instance.Server.Docker.FinishAll()
+		instance.Server.Docker.WithRunDuration(10 * time.Millisecond)
+	}
+	ctx = context.Background()
+	s = newScheduler(t, "state", p).WithState(store).WithMaxConcurrency(2)
+	tasks := []*sleepTask{}
+	for i := 0; i < 3; i++ {
+		tasks = append(tasks, &sleepTask{label: "state", index: i})
+		if err := s.Submit(ctx, tasks[i]); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := s.Wait(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.NumCreated() != 2 {
+		t.Errorf("expected the servers to be reused, got %d created", p.NumCreated())
+	}
+	records, err := store.List(ctx, "state")
+	if err != nil || len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d (%v)", len(records), err)
+	}
+	for i, r := range records {
+		if !tasks[i].downloaded || r.Status != state.StatusFinished || r.Attempts != 1 || r.FinishedAt.IsZero() {
+			t.Errorf("expected %s to be resumed, not run again, got %+v", r.TaskID, r)
+		}
+	}
+
+	// Finished tasks are skipped without any server
+	s = newScheduler(t, "state", p).WithState(store)
+	again := &sleepTask{label: "state", index: 0}
+	if err := s.Submit(ctx, again); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Wait(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if again.downloaded || p.NumCreated() != 2 {
+		t.Errorf("expected the finished task to be skipped")
+	}
+}
+
+func TestSchedulerPlan(t *testing.T) {
+	ctx := context.Background()
+	p := dodetest.NewProvider().WithServerSetup(func(s *dodetest.Server) {
+		s.Docker.WithRunDuration(10 * time.Millisecond)
+	})
+	s := newScheduler(t, "plan", p).WithDestroyAfterFinished(false)
+	if err := s.Submit(ctx, &sleepTask{label: "plan", index: 0}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Wait(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pl := plan.New().WithTaskDuration(time.Hour)
+	s = newScheduler(t, "plan", p, func(cso *api.CreateServerOptions) { cso.WithSize("s-1vcpu-1gb") }).
+		WithMaxConcurrency(3).
+		WithPricer(cost.PriceTable{"s-1vcpu-1gb": 0.01}).
+		WithPlan(pl)
+	for i := 0; i < 5; i++ {
+		if err := s.Submit(ctx, &sleepTask{label: "plan", index: i}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := s.Wait(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if p.NumCreated() != 1 || p.NumDestroyed() != 0 || len(p.Instances()[0].Server.Docker.Containers()) != 1 {
+		t.Errorf("expected nothing to be changed in plan mode")
+	}
+	if n := len(pl.Servers()); n != 2 {
+		t.Errorf("expected 2 servers to be planned, got %d", n)
+	}
+	done := 0
+	for _, a := range pl.Assignments() {
+		if a.Done {
+			done++
+		}
+	}
+	if len(pl.Assignments()) != 5 || done != 1 {
+		t.Errorf("expected 4 tasks to run and 1 to be skipped, got %+v", pl.Assignments())
+	}
+	kinds := map[string]int{}
+	for _, a := range pl.Actions() {
+		kinds[a.Kind]++
+	}
+	if kinds["create"] != 2 || kinds["run"] != 8 || kinds["destroy"] != 1 {
+		t.Errorf("unexpected actions %v", kinds)
+	}
+	if hourly, total := pl.Estimate(); hourly != 0.02 || total != 0.02 {
+		t.Errorf("unexpected estimate $%v/h, $%v", hourly, total)
+	}
+	if out := pl.String(); !strings.Contains(out, "Plan: 2 to create, 1 to destroy, 4 tasks to run, 1 already started.") {
+		t.Errorf("unexpected plan:\n%s", out)
+	}
+}
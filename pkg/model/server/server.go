@@ -1,9 +1,69 @@
 package server
 
+import (
+	"errors"
+	"time"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/resources"
+)
+
+// ErrNoAddress is returned when a server has no address of the requested
+// kind, e.g. while it is being provisioned.
+var ErrNoAddress = errors.New("server has no such address")
+
+// Status is the lifecycle state of a server, as reported by its provider.
+type Status string
+
+const (
+	StatusProvisioning Status = "provisioning"
+	StatusRunning      Status = "running"
+	StatusStopped      Status = "stopped"
+	StatusTerminated   Status = "terminated"
+	StatusUnknown      Status = "unknown"
+)
+
 type Server interface {
 	Name() string
 	ID() string
-	IPv4() string
-	IPv6() string
+	// IPv4 returns the public IPv4 address.
+	IPv4() (string, error)
+	// IPv6 returns the public IPv6 address.
+	IPv6() (string, error)
+	// PrivateIPv4 returns the address in the private network of the server.
+	PrivateIPv4() (string, error)
+	// EgressIPv4 returns the public IPv4 address the outbound traffic of the
+	// server comes from, e.g. the reserved IP assigned to it. It is IPv4
+	// unless the provider routes the traffic otherwise.
+	EgressIPv4() (string, error)
 	Tags() []string
+	// Region and Size are the ones the server ended up with, which may differ
+	// from the preferred ones. They are empty if unknown.
+	Region() string
+	Size() string
+	// Image is the image slug or ID the server booted from, empty if unknown.
+	Image() string
+	Status() Status
+	// CreatedAt is zero if unknown.
+	CreatedAt() time.Time
+	// HourlyPrice is the price reported by the cloud in its billing currency
+	// (dollars for most), zero if unknown.
+	HourlyPrice() float64
+	// Provider is the name the provider of the server is registered with.
+	Provider() string
+}
+
+// SSHEndpoint is implemented by servers which are not reachable with the
+// default SSH settings (root@ipv4:22 with the scheduler's private key).
+type SSHEndpoint interface {
+	SSHPort() int
+	SSHUser() string
+	// SSHPrivateKeyPath returns an empty string to use the scheduler's private key.
+	SSHPrivateKeyPath() string
+}
+
+// Sized is implemented by servers which know the resources of their size.
+// Zero amounts are unknown, the scheduler then looks the size up in
+// resources.Sizes.
+type Sized interface {
+	Capacity() resources.Resources
 }
@@ -0,0 +1,130 @@
+// Package state records what happened to the tasks of a job, so that a
+// restarted controller resumes the job without probing every server.
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrNotFound is returned when a task has never been started.
+var ErrNotFound = errors.New("task state not found")
+
+// Status is the status of a task as far as the scheduler knows.
+type Status string
+
+const (
+	// StatusRunning is a task started on a server
+	StatusRunning Status = "running"
+	// StatusFinished is a task whose output has been downloaded
+	StatusFinished Status = "finished"
+	// StatusFailed is a task which could not be started, it is retried
+	StatusFailed Status = "failed"
+)
+
+// Record is the state of a task of a job.
+type Record struct {
+	Job    string `json:"job"`
+	TaskID string `json:"task_id"`
+	// Server and ServerID identify the server the task was last started on
+	Server      string `json:"server"`
+	ServerID    string `json:"server_id"`
+	ContainerID string `json:"container_id"`
+	// Attempts counts the starts of the task, e.g. after a spot interruption
+	Attempts   int       `json:"attempts"`
+	Status     Status    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	StartedAt  time.Time `json:"started_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// Store keeps the records, it outlives the servers of the jobs.
+type Store interface {
+	Get(ctx context.Context, job, taskID string) (*Record, error)
+	Put(ctx context.Context, r *Record) error
+	// List returns the records of the job ordered by task ID.
+	List(ctx context.Context, job string) ([]*Record, error)
+	Close() error
+}
+
+// BoltStore keeps the records in a bbolt file, one bucket per job.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens or creates the file. It fails if another process holds
+// it open.
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltStore{
+		db: db,
+	}, nil
+}
+
+func (b *BoltStore) Get(ctx context.Context, job, taskID string) (*Record, error) {
+	r := &Record{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(job))
+		if bucket == nil {
+			return ErrNotFound
+		}
+		data := bucket.Get([]byte(taskID))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (b *BoltStore) Put(ctx context.Context, r *Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(r.Job))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(r.TaskID), data)
+	})
+}
+
+func (b *BoltStore) List(ctx context.Context, job string) ([]*Record, error) {
+	records := []*Record{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(job))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			r := &Record{}
+			if err := json.Unmarshal(v, r); err != nil {
+				return err
+			}
+			records = append(records, r)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
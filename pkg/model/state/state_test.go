@@ -0,0 +1,65 @@
+package state_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/state"
+)
+
+func TestBoltStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "state.db")
+	store, err := state.OpenBoltStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := store.Get(ctx, "zmap", "shard-0"); !errors.Is(err, state.ErrNotFound) {
+		t.Fatalf("expected no record, got %v", err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	records := []*state.Record{
+		{Job: "zmap", TaskID: "shard-1", Server: "zmap-0", ServerID: "1", Attempts: 1, Status: state.StatusRunning, StartedAt: now},
+		{Job: "zmap", TaskID: "shard-0", Server: "zmap-1", ServerID: "2", ContainerID: "c0ffee", Attempts: 2, Status: state.StatusFinished, FinishedAt: now},
+		{Job: "http", TaskID: "shard-0", Status: state.StatusFailed, Error: "no capacity"},
+	}
+	for _, r := range records {
+		if err := store.Put(ctx, r); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The records survive the controller
+	store, err = state.OpenBoltStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if _, err := state.OpenBoltStore(path); err == nil {
+		t.Errorf("expected the file to be locked by the first store")
+	}
+	r, err := store.Get(ctx, "zmap", "shard-0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.ContainerID != "c0ffee" || r.Attempts != 2 || r.Status != state.StatusFinished || !r.FinishedAt.Equal(now) {
+		t.Errorf("unexpected record %+v", r)
+	}
+	listed, err := store.List(ctx, "zmap")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(listed) != 2 || listed[0].TaskID != "shard-0" || listed[1].TaskID != "shard-1" {
+		t.Errorf("expected the records of the job ordered by task ID, got %d", len(listed))
+	}
+	if listed, _ := store.List(ctx, "dns"); len(listed) != 0 {
+		t.Errorf("expected no record for an unknown job, got %d", len(listed))
+	}
+}
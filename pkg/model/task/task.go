@@ -1,6 +1,9 @@
 package task
 
-import "github.com/WangYihang/digital-ocean-docker-executor/pkg/model/executor/secureshell"
+import (
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/executor/secureshell"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/resources"
+)
 
 type TaskStatus int
 
@@ -45,3 +48,16 @@ type TaskInterface interface {
 	// Download the output files
 	Download() error
 }
+
+// Container is implemented by tasks which know the ID of the container they
+// started, it is recorded in the state of the task.
+type Container interface {
+	ContainerID() string
+}
+
+// Requirer is implemented by tasks which declare the resources they need.
+// Several of them share a server as long as they fit its capacity, tasks
+// requiring nothing get a server of their own.
+type Requirer interface {
+	Requirements() resources.Resources
+}
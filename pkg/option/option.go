@@ -1,5 +1,20 @@
 package option
 
+import (
+	"strings"
+	"time"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/cost"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/keys"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/lease"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/plan"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/provider/api"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/resources"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/model/state"
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/util/sshutil"
+)
+
 type S3Option struct {
 	S3AccessKey string `long:"s3-access-key" description:"AWS access key"`
 	S3SecretKey string `long:"s3-secret-key" description:"AWS secret key"`
@@ -7,17 +22,226 @@ type S3Option struct {
 	S3Bucket    string `long:"s3-bucket" description:"Bucket name" required:"true"`
 }
 
+type ProviderOption struct {
+	Provider        string   `long:"provider" description:"Cloud service provider (alibaba, aws, digitalocean, hetzner, static or any registered provider)" default:"digitalocean"`
+	Token           string   `long:"token" description:"Cloud service provider token (defaults to --do-token)"`
+	AccessKeyID     string   `long:"access-key-id" description:"Cloud service provider access key id"`
+	AccessKeySecret string   `long:"access-key-secret" description:"Cloud service provider access key secret"`
+	ProviderRegion  string   `long:"provider-region" description:"Region to list servers in"`
+	ProviderRegions []string `long:"provider-extra-region" description:"Another region to list servers in, e.g. one servers were created in (repeatable)"`
+	Endpoint        string   `long:"endpoint" description:"Cloud service provider API endpoint"`
+	InventoryPath   string   `long:"inventory" description:"Inventory file of the static provider"`
+	SSHUser         string   `long:"ssh-user" description:"User the servers are logged into, for providers whose images refuse root logins (aws, defaults to ubuntu)"`
+	SecurityGroups  []string `long:"security-group" description:"Security group of the servers, it must allow SSH from the controller (alibaba requires one, aws, repeatable)"`
+	Subnet          string   `long:"subnet" description:"VSwitch (alibaba) or subnet (aws) the servers are created in"`
+}
+
+// ProviderConfig returns the configuration of the selected provider, falling
+// back to the DigitalOcean token for backward compatibility.
+func (o *ProviderOption) ProviderConfig(digitalOceanToken string) provider.Config {
+	token := o.Token
+	if token == "" {
+		token = digitalOceanToken
+	}
+	return provider.Config{
+		Token:            token,
+		AccessKeyID:      o.AccessKeyID,
+		AccessKeySecret:  o.AccessKeySecret,
+		Region:           o.ProviderRegion,
+		Regions:          o.ProviderRegions,
+		Endpoint:         o.Endpoint,
+		InventoryPath:    o.InventoryPath,
+		SSHUser:          o.SSHUser,
+		SecurityGroupIDs: o.SecurityGroups,
+		SubnetID:         o.Subnet,
+	}
+}
+
 type DigitalOceanOption struct {
-	DigitalOceanToken string `long:"do-token" description:"DigitalOcean token" required:"true"`
+	DigitalOceanToken string `long:"do-token" description:"DigitalOcean token"`
 	NumDroplets       int    `long:"num-droplets" description:"Number of droplets" required:"true" default:"2"`
+	QueueSize         int    `long:"queue-size" description:"Number of tasks waiting for a droplet before the generator blocks, defaults to the number of droplets"`
 }
 
 type DropletOption struct {
 	DropletSize           string `long:"droplet-size" description:"Droplet size" required:"true" default:"s-1vcpu-1gb"`
 	DropletImage          string `long:"droplet-image" description:"Droplet image" required:"true" default:"docker-20-04"`
 	DropletRegion         string `long:"droplet-region" description:"Droplet region" required:"true" default:"sfo2"`
-	DropletPublicKeyPath  string `long:"droplet-public-key-path" description:"Public key path" required:"true"`
-	DropletPrivateKeyPath string `long:"droplet-private-key-path" description:"Private key path" required:"true"`
+	DropletPublicKeyPath  string `long:"droplet-public-key-path" description:"Public key path, required unless --ephemeral-key"`
+	DropletPrivateKeyPath string `long:"droplet-private-key-path" description:"Private key path, required unless --ephemeral-key"`
+	// e.g. assets/scripts/ubuntu-22-04-x64/add-swap.sh
+	DropletProvisioningScripts []string `long:"droplet-provisioning-script" description:"Script run by cloud-init at first boot (repeatable)"`
+	DropletFallbackRegions     []string `long:"droplet-fallback-region" description:"Region tried when the droplet region is out of capacity (repeatable)"`
+	DropletFallbackSizes       []string `long:"droplet-fallback-size" description:"Size tried when the droplet size is unavailable (repeatable)"`
+	DropletReservedIPs         []string `long:"droplet-reserved-ip" description:"Pre-allocated reserved IP, one is assigned to each droplet and its outbound traffic comes from it (repeatable)"`
+}
+
+// Regions returns the droplet regions in order of preference.
+func (o *DropletOption) Regions() []string {
+	return append([]string{o.DropletRegion}, o.DropletFallbackRegions...)
+}
+
+// Sizes returns the droplet sizes in order of preference.
+func (o *DropletOption) Sizes() []string {
+	return append([]string{o.DropletSize}, o.DropletFallbackSizes...)
+}
+
+type BudgetOption struct {
+	BudgetDollars     float64 `long:"budget-dollars" description:"Stop creating servers once the projected spend exceeds this many dollars"`
+	BudgetServerHours float64 `long:"budget-server-hours" description:"Stop creating servers once the projected usage exceeds this many server-hours"`
+	HourlyPrice       float64 `long:"hourly-price" description:"Hourly price in dollars of a server, for providers without a pricing API"`
+}
+
+// Budget returns the budget of the run, nil if there is none.
+func (o *BudgetOption) Budget() *cost.Budget {
+	if o.BudgetDollars <= 0 && o.BudgetServerHours <= 0 {
+		return nil
+	}
+	return cost.NewBudget().WithDollars(o.BudgetDollars).WithServerHours(o.BudgetServerHours)
+}
+
+// Pricer returns a price table holding the price for every size if a price
+// is given, nil to use the pricing API of the provider.
+func (o *BudgetOption) Pricer(sizes ...string) cost.Pricer {
+	if o.HourlyPrice <= 0 {
+		return nil
+	}
+	table := cost.PriceTable{}
+	for _, size := range sizes {
+		table[size] = o.HourlyPrice
+	}
+	return table
+}
+
+type FirewallOption struct {
+	Firewall             bool     `long:"firewall" description:"Lock the servers down behind a cloud firewall"`
+	FirewallSSHSources   []string `long:"firewall-ssh-source" description:"CIDR allowed to connect over SSH, defaults to the egress IP of the controller (repeatable)"`
+	FirewallInboundPorts []string `long:"firewall-inbound-port" description:"Inbound port open to everyone, e.g. tcp:80 or udp:8000-8080 (repeatable)"`
+}
+
+// FirewallOptions returns the firewall of the servers, nil if disabled.
+func (o *FirewallOption) FirewallOptions() *api.FirewallOptions {
+	if !o.Firewall {
+		return nil
+	}
+	fo := api.NewFirewallOptions().WithSSHSources(o.FirewallSSHSources...)
+	for _, port := range o.FirewallInboundPorts {
+		protocol, ports, ok := strings.Cut(port, ":")
+		if !ok {
+			protocol, ports = "tcp", port
+		}
+		fo.WithInboundRule(protocol, ports)
+	}
+	return fo
+}
+
+type VolumeOption struct {
+	VolumeSize       int    `long:"volume-size" description:"Size in GB of the volume attached to each server for the task outputs, none if 0"`
+	VolumeFilesystem string `long:"volume-filesystem" description:"Filesystem of the volume" default:"ext4"`
+	VolumeMountPoint string `long:"volume-mount-point" description:"Mount point of the volume" default:"/mnt/data"`
+	VolumeKeep       bool   `long:"volume-keep" description:"Keep the volumes when the servers are destroyed"`
+}
+
+// VolumeOptions returns the volume of the servers, nil if disabled.
+func (o *VolumeOption) VolumeOptions() *api.VolumeOptions {
+	if o.VolumeSize <= 0 {
+		return nil
+	}
+	return api.NewVolumeOptions("data", o.VolumeSize).
+		WithFilesystem(o.VolumeFilesystem).
+		WithMountPoint(o.VolumeMountPoint).
+		WithKeep(o.VolumeKeep)
+}
+
+// DataRoot returns the folder the tasks write their outputs to.
+func (o *VolumeOption) DataRoot() string {
+	if o.VolumeSize <= 0 {
+		return "/data"
+	}
+	return o.VolumeMountPoint
+}
+
+type BakeOption struct {
+	BakeImage string `long:"bake-image" description:"Bake the docker images of the tasks into an image named so, reused if it exists, and boot the servers from it"`
+}
+
+type LeaseOption struct {
+	LeaseFolder string        `long:"lease-folder" description:"Folder shared with the reaper where the controller keeps the lease of its tag, none if empty"`
+	LeaseTTL    time.Duration `long:"lease-ttl" description:"Time after which the lease of a crashed controller expires" default:"5m"`
+}
+
+// Leases returns the lease store, nil if disabled.
+func (o *LeaseOption) Leases() lease.Store {
+	if o.LeaseFolder == "" {
+		return nil
+	}
+	return lease.NewFileStore(o.LeaseFolder)
+}
+
+type PlanOption struct {
+	Plan             bool          `long:"plan" description:"Print what would be created, run and destroyed without doing it"`
+	PlanTaskDuration time.Duration `long:"plan-task-duration" description:"Estimated duration of a task, used to estimate the cost of the plan"`
+}
+
+// NewPlan returns the plan to fill, nil if not planning.
+func (o *PlanOption) NewPlan() *plan.Plan {
+	if !o.Plan {
+		return nil
+	}
+	return plan.New().WithTaskDuration(o.PlanTaskDuration)
+}
+
+type KeyOption struct {
+	EphemeralKey bool   `long:"ephemeral-key" description:"Create a key pair for the run and delete it from the provider account with the servers"`
+	KeyType      string `long:"key-type" description:"Type of the ephemeral key pair" choice:"ed25519" choice:"rsa" default:"ed25519"`
+	KeyFolder    string `long:"key-folder" description:"Folder the ephemeral key pairs are kept in until the servers are destroyed" default:"keys"`
+}
+
+// KeyManager returns the manager of the ephemeral key pair, nil if disabled.
+func (o *KeyOption) KeyManager(p provider.CloudServiceProvider) *keys.Manager {
+	if !o.EphemeralKey {
+		return nil
+	}
+	return keys.NewManager(p, o.KeyFolder).WithKeyType(sshutil.KeyType(o.KeyType))
+}
+
+type StateOption struct {
+	StateFile string `long:"state-file" description:"File the state of the tasks is kept in, so that a restarted run skips the finished tasks and resumes the running ones, none if empty"`
+}
+
+// OpenState opens the state store, nil if disabled.
+func (o *StateOption) OpenState() (state.Store, error) {
+	if o.StateFile == "" {
+		return nil, nil
+	}
+	return state.OpenBoltStore(o.StateFile)
+}
+
+type ResourcesOption struct {
+	TaskCPUs            float64 `long:"task-cpus" description:"vCPUs a task needs, tasks requiring resources share servers within their capacity"`
+	TaskMemoryMB        int     `long:"task-memory-mb" description:"Memory in MB a task needs"`
+	TaskBandwidthMbps   int     `long:"task-bandwidth-mbps" description:"Bandwidth in Mbps a task needs"`
+	ServerCPUs          float64 `long:"server-cpus" description:"vCPUs a server offers to the tasks, defaults to the ones of its size"`
+	ServerMemoryMB      int     `long:"server-memory-mb" description:"Memory in MB a server offers to the tasks, defaults to the one of its size"`
+	ServerBandwidthMbps int     `long:"server-bandwidth-mbps" description:"Bandwidth in Mbps a server offers to the tasks, unlimited if 0"`
+}
+
+// Requirements returns what each task needs, zero to run a task per server.
+func (o *ResourcesOption) Requirements() resources.Resources {
+	return resources.Resources{
+		CPUs:          o.TaskCPUs,
+		MemoryMB:      o.TaskMemoryMB,
+		BandwidthMbps: o.TaskBandwidthMbps,
+	}
+}
+
+// ServerCapacity returns what the servers offer, overriding their sizes.
+func (o *ResourcesOption) ServerCapacity() resources.Resources {
+	return resources.Resources{
+		CPUs:          o.ServerCPUs,
+		MemoryMB:      o.ServerMemoryMB,
+		BandwidthMbps: o.ServerBandwidthMbps,
+	}
 }
 
 type MetaOption struct {
@@ -27,8 +251,18 @@ type MetaOption struct {
 }
 
 type Option struct {
+	ProviderOption
 	S3Option
 	DigitalOceanOption
 	DropletOption
+	BudgetOption
+	FirewallOption
+	VolumeOption
+	BakeOption
+	LeaseOption
+	PlanOption
+	KeyOption
+	StateOption
+	ResourcesOption
 	MetaOption
 }
@@ -0,0 +1,128 @@
+// Package cloudinit assembles the user data run by cloud-init at first boot.
+package cloudinit
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"text/template"
+)
+
+const (
+	ContentTypeCloudConfig = "text/cloud-config"
+	ContentTypeShellScript = "text/x-shellscript"
+	ContentTypeInclude     = "text/x-include-url"
+	ContentTypeBoothook    = "text/cloud-boothook"
+)
+
+// Part is one document of a multipart user data.
+type Part struct {
+	Filename    string
+	ContentType string
+	Content     string
+}
+
+// NewPart creates a part, detecting its content type from the first line of
+// the content (e.g. "#cloud-config" or "#!/bin/bash").
+func NewPart(filename string, content string) Part {
+	return Part{
+		Filename:    filename,
+		ContentType: DetectContentType(content),
+		Content:     content,
+	}
+}
+
+// DetectContentType returns the content type of a user data document as
+// cloud-init does, defaulting to a shell script.
+func DetectContentType(content string) string {
+	switch {
+	case strings.HasPrefix(content, "#cloud-config"):
+		return ContentTypeCloudConfig
+	case strings.HasPrefix(content, "#include"):
+		return ContentTypeInclude
+	case strings.HasPrefix(content, "#cloud-boothook"):
+		return ContentTypeBoothook
+	default:
+		return ContentTypeShellScript
+	}
+}
+
+// Render executes a text/template with data, e.g. a cloud-config template
+// referring to the options of the server.
+func Render(text string, data interface{}) (string, error) {
+	tmpl, err := template.New("cloud-init").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse cloud-init template: %w", err)
+	}
+	buffer := &bytes.Buffer{}
+	if err := tmpl.Execute(buffer, data); err != nil {
+		return "", fmt.Errorf("failed to render cloud-init template: %w", err)
+	}
+	return buffer.String(), nil
+}
+
+// Multipart combines the parts into a MIME multipart user data, which
+// cloud-init processes in order. A single part is returned as is and no part
+// at all results in an empty string.
+func Multipart(parts ...Part) (string, error) {
+	switch len(parts) {
+	case 0:
+		return "", nil
+	case 1:
+		return parts[0].Content, nil
+	}
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, part := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", fmt.Sprintf("%s; charset=\"utf-8\"", part.ContentType))
+		header.Set("MIME-Version", "1.0")
+		header.Set("Content-Transfer-Encoding", "7bit")
+		header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", part.Filename))
+		w, err := writer.CreatePart(header)
+		if err != nil {
+			return "", err
+		}
+		if _, err := w.Write([]byte(part.Content)); err != nil {
+			return "", err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(
+		"Content-Type: multipart/mixed; boundary=\"%s\"\nMIME-Version: 1.0\n\n%s",
+		writer.Boundary(),
+		body.String(),
+	), nil
+}
+
+// Mount is a block device to format and mount at first boot.
+type Mount struct {
+	Device     string
+	Filesystem string
+	MountPoint string
+}
+
+// MountScript returns a shell script waiting for the devices to be attached,
+// formatting the blank ones and mounting them, also on later boots.
+func MountScript(mounts ...Mount) string {
+	lines := []string{
+		"#!/bin/sh",
+		"set -e",
+		"mount_volume() {",
+		"\tdevice=\"$1\" filesystem=\"$2\" mountpoint=\"$3\"",
+		"\tfor i in $(seq 1 120); do [ -b \"$device\" ] && break; sleep 1; done",
+		"\tblkid \"$device\" >/dev/null 2>&1 || mkfs -t \"$filesystem\" \"$device\"",
+		"\tmkdir -p \"$mountpoint\"",
+		"\tgrep -q \" $mountpoint \" /etc/fstab || echo \"$device $mountpoint $filesystem defaults,nofail,discard 0 2\" >> /etc/fstab",
+		"\tmountpoint -q \"$mountpoint\" || mount \"$mountpoint\"",
+		"}",
+	}
+	for _, mount := range mounts {
+		lines = append(lines, fmt.Sprintf("mount_volume %q %q %q", mount.Device, mount.Filesystem, mount.MountPoint))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
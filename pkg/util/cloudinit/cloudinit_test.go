@@ -0,0 +1,101 @@
+package cloudinit_test
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+	"testing"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/util/cloudinit"
+)
+
+func TestDetectContentType(t *testing.T) {
+	testcases := []struct {
+		content  string
+		expected string
+	}{
+		{"#cloud-config\npackages: [jq]\n", cloudinit.ContentTypeCloudConfig},
+		{"#!/bin/bash\necho hello\n", cloudinit.ContentTypeShellScript},
+		{"#include\nhttps://example.com/user-data\n", cloudinit.ContentTypeInclude},
+		{"#cloud-boothook\necho early\n", cloudinit.ContentTypeBoothook},
+	}
+	for _, tc := range testcases {
+		if got := cloudinit.DetectContentType(tc.content); got != tc.expected {
+			t.Errorf("expected %s for %q, got %s", tc.expected, tc.content, got)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	rendered, err := cloudinit.Render("#cloud-config\nhostname: {{ .Name }}\n", struct{ Name string }{"zmap-0"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rendered != "#cloud-config\nhostname: zmap-0\n" {
+		t.Errorf("unexpected rendered template %q", rendered)
+	}
+	if _, err := cloudinit.Render("{{ .Missing }}", map[string]string{}); err == nil {
+		t.Errorf("expected error for a missing key")
+	}
+}
+
+func TestMultipart(t *testing.T) {
+	if userData, _ := cloudinit.Multipart(); userData != "" {
+		t.Errorf("expected empty user data, got %q", userData)
+	}
+	script := "#!/bin/bash\necho hello\n"
+	if userData, _ := cloudinit.Multipart(cloudinit.NewPart("hello.sh", script)); userData != script {
+		t.Errorf("expected a single part to be returned as is, got %q", userData)
+	}
+
+	parts := []cloudinit.Part{
+		cloudinit.NewPart("cloud-config", "#cloud-config\npackages: [jq]\n"),
+		cloudinit.NewPart("add-swap.sh", script),
+	}
+	userData, err := cloudinit.Multipart(parts...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	header, body, _ := strings.Cut(userData, "\n\n")
+	mediaType, params, err := mime.ParseMediaType(strings.TrimPrefix(strings.Split(header, "\n")[0], "Content-Type: "))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("unexpected content type %q: %v", header, err)
+	}
+	reader := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	for _, expected := range parts {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		content, _ := io.ReadAll(part)
+		if string(content) != expected.Content || !strings.HasPrefix(part.Header.Get("Content-Type"), expected.ContentType) {
+			t.Errorf("unexpected part %s: %q", part.Header.Get("Content-Type"), content)
+		}
+		if part.FileName() != expected.Filename {
+			t.Errorf("expected filename %s, got %s", expected.Filename, part.FileName())
+		}
+	}
+	if _, err := reader.NextPart(); err != io.EOF {
+		t.Errorf("expected 2 parts")
+	}
+}
+
+func TestMountScript(t *testing.T) {
+	script := cloudinit.MountScript(
+		cloudinit.Mount{Device: "/dev/sda", Filesystem: "ext4", MountPoint: "/mnt/data"},
+		cloudinit.Mount{Device: "/dev/sdb", Filesystem: "xfs", MountPoint: "/mnt/archive"},
+	)
+	if !strings.HasPrefix(script, "#!/bin/sh\n") {
+		t.Errorf("expected a shell script, got %q", script)
+	}
+	for _, expected := range []string{
+		`mount_volume "/dev/sda" "ext4" "/mnt/data"`,
+		`mount_volume "/dev/sdb" "xfs" "/mnt/archive"`,
+		"nofail",
+	} {
+		if !strings.Contains(script, expected) {
+			t.Errorf("expected %q in %q", expected, script)
+		}
+	}
+}
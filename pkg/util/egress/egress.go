@@ -0,0 +1,39 @@
+// Package egress finds the public IP the controller reaches servers from.
+package egress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// DefaultEndpoint answers the IPv4 address of the caller in plain text.
+const DefaultEndpoint = "https://api.ipify.org"
+
+// IPv4 returns the public IPv4 address of the controller as seen by endpoint.
+func IPv4(ctx context.Context, endpoint string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get egress ip: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64))
+	if err != nil {
+		return "", fmt.Errorf("failed to get egress ip: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get egress ip: %s", resp.Status)
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil || ip.To4() == nil {
+		return "", fmt.Errorf("invalid egress ip %q", strings.TrimSpace(string(body)))
+	}
+	return ip.String(), nil
+}
@@ -0,0 +1,39 @@
+package egress_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/util/egress"
+)
+
+func TestIPv4(t *testing.T) {
+	testcases := []struct {
+		body     string
+		status   int
+		expected string
+		fails    bool
+	}{
+		{"198.51.100.7\n", http.StatusOK, "198.51.100.7", false},
+		{"2001:db8::1", http.StatusOK, "", true},
+		{"<html>", http.StatusOK, "", true},
+		{"198.51.100.7", http.StatusServiceUnavailable, "", true},
+	}
+	for _, tc := range testcases {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tc.status)
+			fmt.Fprint(w, tc.body)
+		}))
+		ip, err := egress.IPv4(context.Background(), ts.URL)
+		ts.Close()
+		if (err != nil) != tc.fails {
+			t.Errorf("%q: unexpected error %v", tc.body, err)
+		}
+		if ip != tc.expected {
+			t.Errorf("%q: expected %q, got %q", tc.body, tc.expected, ip)
+		}
+	}
+}
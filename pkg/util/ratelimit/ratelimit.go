@@ -0,0 +1,259 @@
+// Package ratelimit keeps the calls to cloud APIs under their rate limits and
+// retries the calls which were throttled anyway.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Headers sent by DigitalOcean (parsed by godo into godo.Rate) and Hetzner
+// Cloud with every response.
+const (
+	HeaderLimit     = "RateLimit-Limit"
+	HeaderRemaining = "RateLimit-Remaining"
+	HeaderReset     = "RateLimit-Reset"
+)
+
+// Limiter paces the calls to an API according to the rate limit it reports.
+// While plenty of calls remain they are not delayed, when running low the
+// remaining calls are spread until the limit resets.
+type Limiter struct {
+	mu        sync.Mutex
+	limit     int
+	remaining int
+	reset     time.Time
+	pausedTo  time.Time
+	next      time.Time
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{}
+}
+
+var errNotRewindable = errors.New("request body can not be sent again")
+
+var (
+	limitersMu sync.Mutex
+	limiters   = map[string]*Limiter{}
+)
+
+// ForHost returns the limiter shared by every client of the API host, as rate
+// limits are per account rather than per client.
+func ForHost(host string) *Limiter {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+	limiter, ok := limiters[host]
+	if !ok {
+		limiter = NewLimiter()
+		limiters[host] = limiter
+	}
+	return limiter
+}
+
+// Observe records the rate limit reported by a response.
+func (l *Limiter) Observe(limit, remaining int, reset time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit, l.remaining, l.reset = limit, remaining, reset
+}
+
+// ObserveHeader records the rate limit headers of a response, if any.
+func (l *Limiter) ObserveHeader(header http.Header) {
+	limit, err := strconv.Atoi(header.Get(HeaderLimit))
+	if err != nil {
+		return
+	}
+	remaining, err := strconv.Atoi(header.Get(HeaderRemaining))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(header.Get(HeaderReset), 10, 64)
+	if err != nil {
+		return
+	}
+	l.Observe(limit, remaining, time.Unix(reset, 0))
+}
+
+// Pause holds every call until the time, e.g. after the API answered 429.
+func (l *Limiter) Pause(until time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until.After(l.pausedTo) {
+		l.pausedTo = until
+	}
+}
+
+// delay reserves a slot for a call and returns how long to wait for it.
+func (l *Limiter) delay(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	start := now
+	if l.pausedTo.After(start) {
+		start = l.pausedTo
+	}
+	if l.limit > 0 && l.reset.After(now) && l.remaining < l.limit/10 {
+		if l.remaining == 0 {
+			// Exhausted, wait for the reset
+			if l.reset.After(start) {
+				start = l.reset
+			}
+		} else {
+			// Running low, spread the remaining calls until the reset
+			interval := l.reset.Sub(now) / time.Duration(l.remaining)
+			if l.next.After(start) {
+				start = l.next
+			}
+			l.next = start.Add(interval)
+			l.remaining--
+		}
+	}
+	return start.Sub(now)
+}
+
+// Wait blocks until the call may be made or ctx is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+	delay := l.delay(time.Now())
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	log.Debug("waiting for the rate limit", "delay", delay)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// Transport is an http.RoundTripper pacing the calls with the limiter of the
+// host and retrying the calls which were throttled (429), or failed with a
+// transient error (502, 503, 504) if they are idempotent, with an exponential
+// backoff and jitter.
+type Transport struct {
+	base       http.RoundTripper
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// NewTransport wraps base, http.DefaultTransport if nil.
+func NewTransport(base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{
+		base:       base,
+		maxRetries: 8,
+		minBackoff: time.Second,
+		maxBackoff: time.Minute,
+	}
+}
+
+func (t *Transport) WithMaxRetries(maxRetries int) *Transport {
+	t.maxRetries = maxRetries
+	return t
+}
+
+func (t *Transport) WithBackoff(minBackoff, maxBackoff time.Duration) *Transport {
+	t.minBackoff = minBackoff
+	t.maxBackoff = maxBackoff
+	return t
+}
+
+// NewClient returns an HTTP client using a Transport over base.
+func NewClient(base http.RoundTripper) *http.Client {
+	return &http.Client{Transport: NewTransport(base)}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	limiter := ForHost(req.URL.Host)
+	for attempt := 0; ; attempt++ {
+		if err := limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+		r := req
+		if attempt > 0 {
+			var err error
+			if r, err = rewind(req); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := t.base.RoundTrip(r)
+		if err != nil {
+			return nil, err
+		}
+		limiter.ObserveHeader(resp.Header)
+		if attempt >= t.maxRetries || !retryable(req, resp) {
+			return resp, nil
+		}
+		delay := t.backoff(attempt, resp.Header)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			limiter.Pause(time.Now().Add(delay))
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		log.Warn("api call failed, retrying", "method", req.Method, "url", req.URL.Redacted(), "status", resp.StatusCode, "attempt", attempt+1, "delay", delay)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// rewind returns a copy of the request with a fresh body to send it again.
+func rewind(req *http.Request) (*http.Request, error) {
+	r := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, errNotRewindable
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	return r, nil
+}
+
+func retryable(req *http.Request, resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		switch req.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+			return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+		}
+	}
+	return false
+}
+
+// backoff honors Retry-After, otherwise it doubles the delay at every attempt
+// with a random jitter so that throttled clients do not retry in lockstep.
+func (t *Transport) backoff(attempt int, header http.Header) time.Duration {
+	if retryAfter := header.Get("Retry-After"); retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+		if at, err := http.ParseTime(retryAfter); err == nil {
+			return time.Until(at)
+		}
+	}
+	delay := t.minBackoff << attempt
+	if delay > t.maxBackoff || delay <= 0 {
+		delay = t.maxBackoff
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
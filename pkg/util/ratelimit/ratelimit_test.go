@@ -0,0 +1,131 @@
+package ratelimit_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/util/ratelimit"
+)
+
+// flakyAPI answers the given statuses in turn, then 200
+type flakyAPI struct {
+	mu       sync.Mutex
+	statuses []int
+	header   http.Header
+	bodies   []string
+}
+
+func (f *flakyAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	f.bodies = append(f.bodies, string(body))
+	for key, values := range f.header {
+		w.Header()[key] = values
+	}
+	if len(f.statuses) > 0 {
+		status := f.statuses[0]
+		f.statuses = f.statuses[1:]
+		w.WriteHeader(status)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestTransportRetries(t *testing.T) {
+	testcases := []struct {
+		name     string
+		method   string
+		statuses []int
+		expected int
+		calls    int
+	}{
+		{"throttled", http.MethodPost, []int{429, 429}, http.StatusOK, 3},
+		{"unavailable get", http.MethodGet, []int{503}, http.StatusOK, 2},
+		{"unavailable post", http.MethodPost, []int{503}, http.StatusServiceUnavailable, 1},
+		{"client error", http.MethodGet, []int{422}, http.StatusUnprocessableEntity, 1},
+		{"too many retries", http.MethodGet, []int{429, 429, 429, 429}, http.StatusTooManyRequests, 4},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			api := &flakyAPI{statuses: tc.statuses, header: http.Header{"Retry-After": {"0"}}}
+			ts := httptest.NewServer(api)
+			defer ts.Close()
+			client := &http.Client{Transport: ratelimit.NewTransport(nil).WithMaxRetries(3)}
+
+			req, err := http.NewRequest(tc.method, ts.URL, strings.NewReader(`{"name":"zmap-0"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.expected {
+				t.Errorf("expected status %d, got %d", tc.expected, resp.StatusCode)
+			}
+			if len(api.bodies) != tc.calls {
+				t.Fatalf("expected %d calls, got %d", tc.calls, len(api.bodies))
+			}
+			for _, body := range api.bodies {
+				if body != `{"name":"zmap-0"}` {
+					t.Errorf("expected the body to be sent again, got %q", body)
+				}
+			}
+		})
+	}
+}
+
+func TestTransportBacksOff(t *testing.T) {
+	// Without Retry-After the delay grows exponentially
+	api := &flakyAPI{statuses: []int{429, 429}}
+	ts := httptest.NewServer(api)
+	defer ts.Close()
+	client := &http.Client{Transport: ratelimit.NewTransport(nil).WithBackoff(40*time.Millisecond, time.Second)}
+
+	start := time.Now()
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	// At least half of 40ms then half of 80ms
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("expected to back off, took %s", elapsed)
+	}
+}
+
+func TestLimiterWaitsForReset(t *testing.T) {
+	limiter := ratelimit.NewLimiter()
+	reset := time.Now().Add(2 * time.Second)
+	header := http.Header{}
+	header.Set(ratelimit.HeaderLimit, "250")
+	header.Set(ratelimit.HeaderRemaining, "0")
+	header.Set(ratelimit.HeaderReset, strconv.FormatInt(reset.Unix(), 10))
+	limiter.ObserveHeader(header)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected to wait for the reset, got %v", err)
+	}
+
+	// Plenty of calls remain
+	limiter.Observe(250, 200, reset)
+	start := time.Now()
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Errorf("expected no delay, took %s", elapsed)
+	}
+}
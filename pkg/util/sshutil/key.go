@@ -1,6 +1,7 @@
 package sshutil
 
 import (
+	"crypto/ed25519"
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/rsa"
@@ -15,6 +16,40 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
+// KeyType is the algorithm of a key pair.
+type KeyType string
+
+const (
+	RSA     KeyType = "rsa"
+	Ed25519 KeyType = "ed25519"
+)
+
+// GenerateKeyPair returns a PEM encoded private key and an authorized_keys
+// line. numBits is only used for RSA keys.
+func GenerateKeyPair(keyType KeyType, numBits int) (string, string, error) {
+	switch keyType {
+	case RSA:
+		return GenerateSSHKeyPair(numBits)
+	case Ed25519:
+		publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return "", "", err
+		}
+		privatePEM, err := ssh.MarshalPrivateKey(privateKey, "")
+		if err != nil {
+			return "", "", err
+		}
+		sshPublicKey, err := ssh.NewPublicKey(publicKey)
+		if err != nil {
+			return "", "", err
+		}
+		return string(pem.EncodeToMemory(privatePEM)), string(ssh.MarshalAuthorizedKey(sshPublicKey)), nil
+	}
+	return "", "", fmt.Errorf("unsupported key type %q", keyType)
+}
+
+// GenerateSSHKeyPair returns a PEM encoded RSA private key and an
+// authorized_keys line.
 func GenerateSSHKeyPair(bits int) (string, string, error) {
 	privateKey, err := rsa.GenerateKey(rand.Reader, bits)
 	if err != nil {
@@ -32,23 +67,37 @@ func GenerateSSHKeyPair(bits int) (string, string, error) {
 	return string(privatePEM), string(publicSSHKey), nil
 }
 
-func CreateSSHKeyPair(folder, name string, numBits int) (string, string, error) {
+func checkKeyName(name string) error {
 	if strings.Contains(name, ".") {
-		return "", "", fmt.Errorf("name should not contain '.'")
+		return fmt.Errorf("name should not contain '.'")
 	}
 	if strings.Contains(name, "/") {
-		return "", "", fmt.Errorf("name should not contain '/'")
+		return fmt.Errorf("name should not contain '/'")
 	}
-	privkey, pubkey, err := GenerateSSHKeyPair(numBits)
+	return nil
+}
+
+// CreateSSHKeyPair generates an RSA key pair and saves it as folder/name and
+// folder/name.pub, returning the private and the public key.
+func CreateSSHKeyPair(folder, name string, numBits int) (string, string, error) {
+	return CreateKeyPair(folder, name, RSA, numBits)
+}
+
+// CreateKeyPair generates a key pair and saves it as folder/name and
+// folder/name.pub, returning the private and the public key.
+func CreateKeyPair(folder, name string, keyType KeyType, numBits int) (string, string, error) {
+	if err := checkKeyName(name); err != nil {
+		return "", "", err
+	}
+	privkey, pubkey, err := GenerateKeyPair(keyType, numBits)
 	if err != nil {
 		return "", "", err
 	}
-	slog.Info("ssh key pair generated", slog.Int("num_bits", numBits), slog.String("public_key", pubkey))
-	fingerprint, err := GetSSHPublicKeyFingerprintMD5(pubkey)
+	fingerprint, err := Fingerprint(pubkey)
 	if err != nil {
 		return "", "", err
 	}
-	slog.Info("public key fingerprint", slog.String("fingerprint", fingerprint))
+	slog.Info("ssh key pair generated", slog.String("type", string(keyType)), slog.String("fingerprint", fingerprint))
 	os.MkdirAll(folder, 0755)
 	err = os.WriteFile(filepath.Join(
 		folder, name,
@@ -67,24 +116,25 @@ func CreateSSHKeyPair(folder, name string, numBits int) (string, string, error)
 	return privkey, pubkey, nil
 }
 
+// LoadSSHKeyPair returns the private and the public key saved by
+// CreateKeyPair.
 func LoadSSHKeyPair(folder, name string) (string, string, error) {
-	if strings.Contains(name, ".") {
-		return "", "", fmt.Errorf("name should not contain '.'")
-	}
-	if strings.Contains(name, "/") {
-		return "", "", fmt.Errorf("name should not contain '/'")
+	if err := checkKeyName(name); err != nil {
+		return "", "", err
 	}
-	pubkey, err := os.ReadFile(filepath.Join(folder, fmt.Sprintf("%s.pub", name)))
+	privkey, err := os.ReadFile(filepath.Join(folder, name))
 	if err != nil {
 		return "", "", err
 	}
-	privkey, err := os.ReadFile(filepath.Join(folder, name))
+	pubkey, err := os.ReadFile(filepath.Join(folder, fmt.Sprintf("%s.pub", name)))
 	if err != nil {
 		return "", "", err
 	}
-	return string(pubkey), string(privkey), nil
+	return string(privkey), string(pubkey), nil
 }
 
+// LoadOrCreateSSHKeyPair returns the private and the public key, creating a
+// 4096 bits RSA key pair if there is none.
 func LoadOrCreateSSHKeyPair(folder, name string) (string, string, error) {
 	privkey, pubkey, err := LoadSSHKeyPair(folder, name)
 	if err == nil {
@@ -93,6 +143,30 @@ func LoadOrCreateSSHKeyPair(folder, name string) (string, string, error) {
 	return CreateSSHKeyPair(folder, name, 4096)
 }
 
+// Fingerprint returns the SHA256 fingerprint of the public key, as printed by
+// ssh-keygen -l (e.g. SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s).
+func Fingerprint(publicKey string) (string, error) {
+	sshPubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return "", err
+	}
+	return ssh.FingerprintSHA256(sshPubKey), nil
+}
+
+// SameKey reports whether both public keys are the same key, comparing their
+// SHA256 fingerprints so that comments and whitespace do not matter.
+func SameKey(a, b string) bool {
+	fa, err := Fingerprint(a)
+	if err != nil {
+		return false
+	}
+	fb, err := Fingerprint(b)
+	return err == nil && fa == fb
+}
+
+// GetSSHPublicKeyFingerprintMD5 returns the legacy MD5 fingerprint of the
+// public key. Only use it for clouds which identify keys by MD5 fingerprint
+// and do not return the public keys, use Fingerprint otherwise.
 func GetSSHPublicKeyFingerprintMD5(publicKey string) (string, error) {
 	sshPubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
 	if err != nil {
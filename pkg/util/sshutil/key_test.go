@@ -0,0 +1,82 @@
+package sshutil_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/WangYihang/digital-ocean-docker-executor/pkg/util/sshutil"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGenerateKeyPair(t *testing.T) {
+	testcases := []struct {
+		keyType sshutil.KeyType
+		numBits int
+		want    string
+	}{
+		{keyType: sshutil.Ed25519, want: ssh.KeyAlgoED25519},
+		{keyType: sshutil.RSA, numBits: 2048, want: ssh.KeyAlgoRSA},
+	}
+	for _, testcase := range testcases {
+		privkey, pubkey, err := sshutil.GenerateKeyPair(testcase.keyType, testcase.numBits)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", testcase.keyType, err)
+		}
+		signer, err := ssh.ParsePrivateKey([]byte(privkey))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", testcase.keyType, err)
+		}
+		if signer.PublicKey().Type() != testcase.want {
+			t.Errorf("%s: expected a %s key, got %s", testcase.keyType, testcase.want, signer.PublicKey().Type())
+		}
+		if !sshutil.SameKey(string(ssh.MarshalAuthorizedKey(signer.PublicKey())), pubkey) {
+			t.Errorf("%s: expected the public key to match the private key", testcase.keyType)
+		}
+	}
+	if _, _, err := sshutil.GenerateKeyPair("dsa", 0); err == nil {
+		t.Errorf("expected an error for an unsupported key type")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	_, pubkey, err := sshutil.GenerateKeyPair(sshutil.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprint, err := sshutil.Fingerprint(pubkey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(fingerprint, "SHA256:") {
+		t.Errorf("expected a SHA256 fingerprint, got %s", fingerprint)
+	}
+	commented := strings.TrimSpace(pubkey) + " zmap@controller\n"
+	if !sshutil.SameKey(pubkey, commented) {
+		t.Errorf("expected the comment not to matter")
+	}
+	_, other, err := sshutil.GenerateKeyPair(sshutil.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sshutil.SameKey(pubkey, other) || sshutil.SameKey(pubkey, "garbage") {
+		t.Errorf("expected different keys not to match")
+	}
+}
+
+func TestLoadOrCreateSSHKeyPair(t *testing.T) {
+	folder := t.TempDir()
+	privkey, pubkey, err := sshutil.CreateKeyPair(folder, "id_ed25519", sshutil.Ed25519, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	loadedPrivkey, loadedPubkey, err := sshutil.LoadOrCreateSSHKeyPair(folder, "id_ed25519")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if loadedPrivkey != privkey || loadedPubkey != pubkey {
+		t.Errorf("expected the private then the public key, as CreateKeyPair")
+	}
+	if _, _, err := sshutil.LoadSSHKeyPair(folder, "id_ed25519.pub"); err == nil {
+		t.Errorf("expected an error for a name with a '.'")
+	}
+}